@@ -6,16 +6,18 @@ package cli
 */
 
 import (
+	"github.com/strickyak/tcl67/posix"
 	"github.com/strickyak/tcl67/tcl"
 
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime/pprof"
+	"sort"
 	"strings"
 
 	"github.com/chzyer/readline"
@@ -25,6 +27,7 @@ var dFlag = flag.String("d", "", "Debugging flags, each a single letter.")
 var cFlag = flag.String("c", "", "Immediate command to execute.")
 var recoverFlag = flag.Bool("recover", true, "Set to false to disable recover in the REPL.")
 var testFlag = flag.Bool("test", false, "Print test summary at end.")
+var eventsFlag = flag.Bool("events", true, "Run events (after, go -command) while the REPL waits for input.")
 
 var scriptName string
 
@@ -38,21 +41,20 @@ func saveArgvStarting(fr *tcl.Frame, n int) {
 	fr.SetVar("Argv", tcl.MkList(argv)) // New: Argv
 }
 
-func setEnvironInChirp(fr *tcl.Frame, varName string) {
-	h := make(tcl.Hash)
-	for _, s := range os.Environ() {
-		kv := strings.SplitN(s, "=", 2)
-		if len(kv) == 2 {
-			h[kv[0]] = tcl.MkString(kv[1])
-		}
+// setAutoPath initializes the auto_path list, which "package require"
+// and auto_load search, from the TCL67PATH environment variable.
+func setAutoPath(fr *tcl.Frame) {
+	var dirs []tcl.T
+	for _, d := range filepath.SplitList(os.Getenv("TCL67PATH")) {
+		dirs = append(dirs, tcl.MkString(d))
 	}
-	fr.SetVar(varName, tcl.MkHash(h))
+	fr.SetVar("auto_path", tcl.MkList(dirs))
 }
 
 func Main() {
 	flag.Parse()
 	fr := tcl.NewInterpreter()
-	setEnvironInChirp(fr, "Env")
+	setAutoPath(fr)
 
 	for _, ch := range *dFlag {
 		if ch < 256 {
@@ -69,13 +71,12 @@ func Main() {
 	if len(flag.Args()) > 0 {
 		// Script mode.
 		scriptName = flag.Arg(0)
-		contents, err := ioutil.ReadFile(scriptName)
-		if err != nil {
+		if _, err := os.Stat(scriptName); err != nil {
 			log.Fatalf("Cannot read file %s: %v", scriptName, err)
 		}
 		saveArgvStarting(fr, 1)
 
-		fr.Eval(tcl.MkString(string(contents)))
+		posix.SourceFile(fr, scriptName, "")
 		goto End
 	}
 
@@ -99,8 +100,22 @@ func Main() {
 			log.Fatalf("Cannot create readline object: %v", err)
 		}
 		defer rl.Close()
+		fr.G.Unknown = interactiveUnknown
 
 		i := 1
+		evalLine := func(line string) {
+			result := EvalStringOrPrintError(fr, line)
+			resultStr := result.String()
+			if resultStr != "" { // Traditionally, if result is empty, tclsh doesn't print.
+				fmt.Printf("$%d = %s\n", i, resultStr)
+				fr.SetVar(tcl.Str(i), result)
+				i++
+			}
+		}
+		if *eventsFlag {
+			replWithEvents(fr, rl, evalLine)
+			goto End
+		}
 		for {
 			line, err := rl.Readline()
 			if err != nil {
@@ -109,23 +124,47 @@ func Main() {
 				}
 				goto End
 			}
-			result := EvalStringOrPrintError(fr, string(line))
-			resultStr := result.String()
-			if resultStr != "" { // Traditionally, if result is empty, tclsh doesn't print.
-				fmt.Printf("$%d = %s\n", i, resultStr)
-				fr.SetVar(tcl.Str(i), result)
-				i++
-			}
+			evalLine(string(line))
 		}
 	}
 
 End:
+	posix.FlushChannels(fr)
 	logAllCounters()
 	if tcl.Debug['h'] {
 		pprof.Lookup("heap").WriteTo(os.Stderr, 0)
 	}
 }
 
+// replWithEvents reads lines in another goroutine, and posts them to
+// the event loop, so timers and other events run while waiting for input.
+func replWithEvents(fr *tcl.Frame, rl *readline.Instance, evalLine func(string)) {
+	el := fr.G.Events
+	quit := false
+	el.Hold()
+	go func() {
+		defer el.Release()
+		for {
+			line, err := rl.Readline()
+			if err != nil {
+				if err != io.EOF {
+					log.Fatalf("*** ERROR in Readline: %s\n", err.Error())
+				}
+				el.Post(func(*tcl.Frame) { quit = true })
+				return
+			}
+			done := make(chan struct{})
+			el.Post(func(*tcl.Frame) {
+				defer close(done)
+				evalLine(string(line))
+			})
+			<-done // Wait before prompting again.
+		}
+	}()
+	for !quit && fr.DoOneEvent(true, true) {
+	}
+}
+
 func logAllCounters() {
 	if tcl.Debug['c'] {
 		tcl.LogAllCounters()
@@ -142,6 +181,49 @@ func logAllCounters() {
 	}
 }
 
+// interactiveUnknown handles missing commands typed at the prompt like
+// tclsh: try auto_load, then run an external program with the terminal as
+// its stdin and stdout, then a unique abbreviation of a command.
+func interactiveUnknown(fr *tcl.Frame, argv []tcl.T) (tcl.T, bool) {
+	if fr != &fr.G.Fr {
+		return nil, false // Only for commands at the prompt.
+	}
+	name := argv[0].String()
+	if fr.AutoLoad(name) {
+		return fr.Apply(argv), true
+	}
+
+	if path, err := exec.LookPath(name); err == nil {
+		var args []string
+		for _, a := range argv[1:] {
+			args = append(args, a.String())
+		}
+		cmd := exec.Command(path, args...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			panic(fmt.Sprintf("%s: %v", name, err))
+		}
+		return tcl.Empty, true
+	}
+
+	var matches []string
+	for k := range fr.G.Cmds {
+		if strings.HasPrefix(k, name) {
+			matches = append(matches, k)
+		}
+	}
+	switch len(matches) {
+	case 0:
+	case 1:
+		argv2 := append([]tcl.T{tcl.MkString(matches[0])}, argv[1:]...)
+		return fr.Apply(argv2), true
+	default:
+		sort.Strings(matches)
+		panic(fmt.Sprintf("ambiguous command name %q: %s", name, strings.Join(matches, " ")))
+	}
+	return nil, false
+}
+
 func EvalStringOrPrintError(fr *tcl.Frame, cmd string) (out tcl.T) {
 	if *recoverFlag {
 		defer func() {
@@ -1,7 +1,6 @@
 package extra
 
 import (
-	"io/ioutil"
 	"log"
 
 	. "github.com/strickyak/tcl67/tcl"
@@ -39,7 +38,7 @@ func cmdBinaryJoin(fr *Frame, argv []T) T {
 
 func cmdBinaryReadfile(fr *Frame, argv []T) T {
 	name, more := Arg1v(argv)
-	contents, err := ioutil.ReadFile(name.String())
+	contents, err := ReadFile(fr.FileSystem(), name.String())
 	if err != nil {
 		log.Panicf("binary readfile: cannot read file %q: %v", name, err)
 	}
@@ -57,18 +56,21 @@ func cmdBinaryReadfile(fr *Frame, argv []T) T {
 
 func cmdBinaryWritefile(fr *Frame, argv []T) T {
 	name, contents := Arg2(argv)
-	err := ioutil.WriteFile(name.String(), []byte(contents.String()), 0777)
+	err := WriteFile(fr.FileSystem(), name.String(), []byte(contents.String()), 0777)
 	if err != nil {
 		log.Panicf("binary writefile: cannot write file %q: %v", name, err)
 	}
 	return Empty
 }
 
+// cmdBinaryExplode returns the bytes (not the characters) of the string,
+// so it is the inverse of implode.
 func cmdBinaryExplode(fr *Frame, argv []T) T {
 	a := Arg1(argv)
-	var z []T
-	for _, c := range a.String() {
-		z = append(z, MkInt(int64(c)))
+	s := a.String()
+	z := make([]T, len(s))
+	for i := 0; i < len(s); i++ {
+		z[i] = MkInt(int64(s[i]))
 	}
 	return MkList(z)
 }
@@ -147,4 +149,6 @@ func init() {
 	}
 
 	Safes["binary"] = MkEnsemble(binaryEnsemble)
+	RequireCaps("binary readfile", "file")
+	RequireCaps("binary writefile", "file")
 }
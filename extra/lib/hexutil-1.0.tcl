@@ -0,0 +1,24 @@
+# hexutil -- hex encoding of strings, using the binary command from extra.
+
+package provide hexutil 1.0
+
+# Returns the bytes of s as lower-case hex digits.
+proc hexutil::encode {s} {
+  set z ""
+  foreach b [binary explode $s] {
+    append z [format %02x $b]
+  }
+  set z
+}
+
+# Returns the string whose bytes are given as hex digits.
+proc hexutil::decode {hex} {
+  set bytes {}
+  set i 0
+  while {$i < [string length $hex]} {
+    scan [string range $hex $i [expr {$i + 1}]] %x b
+    lappend bytes $b
+    incr i 2
+  }
+  binary implode $bytes
+}
@@ -0,0 +1,14 @@
+package extra
+
+import (
+	"embed"
+
+	. "github.com/strickyak/tcl67/tcl"
+)
+
+//go:embed lib/*.tcl
+var libFS embed.FS
+
+func init() {
+	AddLibraries(libFS, "lib")
+}
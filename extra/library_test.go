@@ -0,0 +1,20 @@
+package extra
+
+import (
+	"testing"
+
+	. "github.com/strickyak/tcl67/tcl"
+)
+
+var hexutilTests = `
+  must 1.0 [package require hexutil]
+  must 414263 [hexutil::encode ABc]
+  must {} [hexutil::encode {}]
+  must ABc [hexutil::decode 414263]
+  must "\x00\xff" [hexutil::decode [hexutil::encode "\x00\xff"]]
+`
+
+func TestHexutil(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(hexutilTests))
+}
@@ -0,0 +1,437 @@
+package posix
+
+import (
+	"bufio"
+	. "fmt"
+	. "github.com/strickyak/tcl67/tcl"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Channel I/O on terpFiles, which may hold any Channel (see channel.go).
+// Input is decoded from the channel's encoding and its line endings
+// translated to "\n"; output is the reverse.
+// Encoding "binary" and translation "binary" pass bytes unchanged.
+//
+// fconfigure -blocking is recorded, but reads always block.
+
+func (tf *terpFile) reader() *bufio.Reader {
+	if tf.c == nil {
+		panic(Sprintf("channel %q is closed", tf.name))
+	}
+	if tf.r == nil {
+		tf.r = bufio.NewReader(tf.c)
+	}
+	return tf.r
+}
+
+func (tf *terpFile) writer() *bufio.Writer {
+	if tf.c == nil {
+		panic(Sprintf("channel %q is closed", tf.name))
+	}
+	if tf.w == nil {
+		tf.w = bufio.NewWriter(tf.c)
+	}
+	return tf.w
+}
+
+func (tf *terpFile) decode(data string) string {
+	switch tf.transIn {
+	case "auto":
+		data = strings.ReplaceAll(data, "\r\n", "\n")
+		data = strings.ReplaceAll(data, "\r", "\n")
+	case "crlf":
+		data = strings.ReplaceAll(data, "\r\n", "\n")
+	case "cr":
+		data = strings.ReplaceAll(data, "\r", "\n")
+	}
+	if tf.encoding != "binary" {
+		data = ConvertFrom(tf.encoding, data)
+	}
+	return data
+}
+
+func (tf *terpFile) encode(data string) string {
+	if tf.encoding != "binary" {
+		data = ConvertTo(tf.encoding, data)
+	}
+	switch tf.transOut {
+	case "crlf":
+		data = strings.ReplaceAll(data, "\n", "\r\n")
+	case "cr":
+		data = strings.ReplaceAll(data, "\n", "\r")
+	}
+	return data
+}
+
+// readLine reads a line, without its line ending.
+// It returns false if it is at end of file.
+func (tf *terpFile) readLine() (string, bool) {
+	tf.rmu.Lock()
+	defer tf.rmu.Unlock()
+	delim := byte('\n')
+	if tf.transIn == "cr" {
+		delim = '\r'
+	}
+	data, err := tf.reader().ReadString(delim)
+	if err != nil && err != io.EOF {
+		panic(Sprintf(`Error during "gets": %s`, err.Error()))
+	}
+	if err == io.EOF {
+		tf.eof = true
+		if data == "" {
+			return "", false
+		}
+	}
+	data = strings.TrimSuffix(data, string(delim))
+	if tf.transIn == "auto" || tf.transIn == "crlf" {
+		data = strings.TrimSuffix(data, "\r")
+	}
+	return tf.decode(data), true
+}
+
+// readAll reads to the end of file.
+func (tf *terpFile) readAll() string {
+	tf.rmu.Lock()
+	defer tf.rmu.Unlock()
+	bb, err := io.ReadAll(tf.reader())
+	if err != nil {
+		panic(Sprintf(`Error during "read": %s`, err.Error()))
+	}
+	tf.eof = true
+	return tf.decode(string(bb))
+}
+
+// charWidth is the number of bytes per character, or 0 for UTF-8.
+func (tf *terpFile) charWidth() int {
+	switch strings.ToLower(tf.encoding) {
+	case "utf-8":
+		return 0
+	case "utf-16", "utf-16le", "utf-16be", "unicode":
+		return 2
+	}
+	return 1
+}
+
+// readChars reads up to n characters.
+func (tf *terpFile) readChars(n int) string {
+	tf.rmu.Lock()
+	defer tf.rmu.Unlock()
+	r := tf.reader()
+	var buf strings.Builder
+	width := tf.charWidth()
+	for i := 0; i < n; i++ {
+		var err error
+		if width == 0 {
+			var c rune
+			c, _, err = r.ReadRune()
+			if err == nil {
+				buf.WriteRune(c)
+			}
+		} else {
+			bb := make([]byte, width)
+			var cc int
+			cc, err = io.ReadFull(r, bb)
+			buf.Write(bb[:cc])
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			tf.eof = true
+			break
+		}
+		if err != nil {
+			panic(Sprintf(`Error during "read": %s`, err.Error()))
+		}
+	}
+	return tf.decode(buf.String())
+}
+
+// readBytes reads up to n bytes.
+func (tf *terpFile) readBytes(n int64) string {
+	tf.rmu.Lock()
+	defer tf.rmu.Unlock()
+	bb, err := io.ReadAll(io.LimitReader(tf.reader(), n))
+	if err != nil {
+		panic(Sprintf(`Error during "read": %s`, err.Error()))
+	}
+	if int64(len(bb)) < n {
+		tf.eof = true
+	}
+	return tf.decode(string(bb))
+}
+
+// write writes the data, flushing as the buffering option says,
+// and returns the number of bytes written.
+func (tf *terpFile) write(data string) int {
+	w := tf.writer()
+	n, err := w.WriteString(tf.encode(data))
+	if err == nil {
+		switch {
+		case tf.buffering == "none",
+			tf.buffering == "line" && strings.Contains(data, "\n"):
+			err = w.Flush()
+		}
+	}
+	if err != nil {
+		panic(Sprintf(`Error during "puts": %s`, err.Error()))
+	}
+	return n
+}
+
+// seek flushes output, drops buffered input, and moves the file offset.
+func (tf *terpFile) seek(offset int64, whence int) int64 {
+	if tf.w != nil {
+		Flush(tf)
+	}
+	if tf.c == nil {
+		panic(Sprintf("channel %q is closed", tf.name))
+	}
+	seeker, ok := tf.c.(io.Seeker)
+	if !ok {
+		panic(Sprintf("channel %q cannot seek", tf.name))
+	}
+	tf.rmu.Lock()
+	defer tf.rmu.Unlock()
+	pos, err := seeker.Seek(offset, whence)
+	if err != nil {
+		panic(Sprintf(`Error during "seek": %s`, err.Error()))
+	}
+	if tf.r != nil {
+		tf.r.Reset(tf.c)
+	}
+	tf.eof = false
+	return pos
+}
+
+// tell returns the offset of the next byte the script will read or write,
+// or -1 if the channel cannot seek.
+func (tf *terpFile) tell() int64 {
+	if tf.c == nil {
+		panic(Sprintf("channel %q is closed", tf.name))
+	}
+	seeker, ok := tf.c.(io.Seeker)
+	if !ok {
+		return -1
+	}
+	tf.rmu.Lock()
+	defer tf.rmu.Unlock()
+	pos, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	if tf.r != nil {
+		pos -= int64(tf.r.Buffered())
+	}
+	if tf.w != nil {
+		pos += int64(tf.w.Buffered())
+	}
+	return pos
+}
+
+// cmdRead implements "read ?-nonewline? channel" and "read channel numChars".
+func cmdRead(fr *Frame, argv []T) T {
+	switch {
+	case len(argv) == 2:
+		return MkString(fileArg(fr, argv[1]).readAll())
+	case len(argv) == 3 && argv[1].String() == "-nonewline":
+		return MkString(strings.TrimSuffix(fileArg(fr, argv[2]).readAll(), "\n"))
+	case len(argv) == 3:
+		tf := fileArg(fr, argv[1])
+		n, err := strconv.Atoi(argv[2].String())
+		if err != nil || n < 0 {
+			panic(Sprintf(`Bad number of characters for "read": %q`, argv[2].String()))
+		}
+		return MkString(tf.readChars(n))
+	}
+	panic("usage: read ?-nonewline? channel | read channel numChars")
+}
+
+func cmdSeek(fr *Frame, argv []T) T {
+	var fileT, offsetT, originT T
+	if len(argv) == 4 {
+		fileT, offsetT, originT = Arg3(argv)
+	} else {
+		fileT, offsetT = Arg2(argv)
+	}
+	whence := io.SeekStart
+	if originT != nil {
+		switch originT.String() {
+		case "start":
+		case "current":
+			whence = io.SeekCurrent
+		case "end":
+			whence = io.SeekEnd
+		default:
+			panic(Sprintf(`Bad origin for "seek": %q: should be start, current, or end`, originT.String()))
+		}
+	}
+	tf := fileArg(fr, fileT)
+	offset := offsetT.Int()
+	if whence == io.SeekCurrent {
+		// Account for input already buffered.
+		offset = tf.tell() + offset
+		whence = io.SeekStart
+	}
+	tf.seek(offset, whence)
+	return Empty
+}
+
+func cmdTell(fr *Frame, argv []T) T {
+	fileT := Arg1(argv)
+	return MkInt(fileArg(fr, fileT).tell())
+}
+
+func cmdEof(fr *Frame, argv []T) T {
+	fileT := Arg1(argv)
+	return MkBool(fileArg(fr, fileT).eof)
+}
+
+var fconfigureOptions = []string{"-blocking", "-buffering", "-encoding", "-translation"}
+
+func (tf *terpFile) getOption(opt string) T {
+	switch opt {
+	case "-blocking":
+		return MkBool(tf.blocking)
+	case "-buffering":
+		return MkString(tf.buffering)
+	case "-encoding":
+		return MkString(tf.encoding)
+	case "-translation":
+		if tf.transIn == tf.transOut {
+			return MkString(tf.transIn)
+		}
+		return MkStringList([]string{tf.transIn, tf.transOut})
+	case "-sockname", "-peername":
+		if addr, ok := tf.socketAddr(opt == "-peername"); ok {
+			return addrList(addr)
+		}
+	}
+	panic(Sprintf("bad option %q: should be one of %s", opt, strings.Join(fconfigureOptions, ", ")))
+}
+
+func checkTranslation(s string) string {
+	switch s {
+	case "auto", "lf", "crlf", "cr", "binary":
+		return s
+	}
+	panic(Sprintf("bad value for -translation: %q", s))
+}
+
+func (tf *terpFile) setOption(opt string, value T) {
+	switch opt {
+	case "-blocking":
+		tf.blocking = value.Bool()
+	case "-buffering":
+		switch s := value.String(); s {
+		case "full", "line", "none":
+			tf.buffering = s
+		default:
+			panic(Sprintf("bad value for -buffering: %q: should be full, line, or none", s))
+		}
+	case "-encoding":
+		s := value.String()
+		if s != "binary" {
+			ConvertTo(s, "") // Panics if unknown.
+		}
+		tf.encoding = s
+	case "-translation":
+		vv := value.List()
+		switch len(vv) {
+		case 1:
+			tf.transIn = checkTranslation(vv[0].String())
+			tf.transOut = tf.transIn
+			if tf.transOut == "auto" {
+				tf.transOut = "lf"
+			}
+		case 2:
+			tf.transIn = checkTranslation(vv[0].String())
+			tf.transOut = checkTranslation(vv[1].String())
+		default:
+			panic(Sprintf("bad value for -translation: %q", value.String()))
+		}
+		if tf.transIn == "binary" {
+			tf.encoding = "binary"
+		}
+	default:
+		tf.getOption(opt) // Panics with the list of options.
+	}
+}
+
+// cmdFConfigure implements "fconfigure channel ?-option? ?-option value ...?".
+func cmdFConfigure(fr *Frame, argv []T) T {
+	fileT, args := Arg1v(argv)
+	tf := fileArg(fr, fileT)
+	switch len(args) {
+	case 0:
+		var zz []T
+		for _, opt := range fconfigureOptions {
+			zz = append(zz, MkString(opt), tf.getOption(opt))
+		}
+		if tf.isSocket() {
+			for _, opt := range []string{"-peername", "-sockname"} {
+				zz = append(zz, MkString(opt), tf.getOption(opt))
+			}
+		}
+		return MkList(zz)
+	case 1:
+		return tf.getOption(args[0].String())
+	}
+	if len(args)%2 != 0 {
+		panic("usage: fconfigure channel ?-option value ...?")
+	}
+	for i := 0; i < len(args); i += 2 {
+		tf.setOption(args[i].String(), args[i+1])
+	}
+	return Empty
+}
+
+// cmdFCopy implements "fcopy in out ?-size n? ?-command callback?",
+// copying at most n bytes of input.  The copy is done at once; the number
+// of bytes written is returned, or passed to the callback from the event loop.
+func cmdFCopy(fr *Frame, argv []T) T {
+	inT, outT, opts := Arg2v(argv)
+	in, out := fileArg(fr, inT), fileArg(fr, outT)
+	size := int64(-1)
+	var callback T
+	if len(opts)%2 != 0 {
+		panic("usage: fcopy input output ?-size size? ?-command callback?")
+	}
+	for i := 0; i < len(opts); i += 2 {
+		switch opts[i].String() {
+		case "-size":
+			size = opts[i+1].Int()
+		case "-command":
+			callback = opts[i+1]
+		default:
+			panic(Sprintf("bad option %q for fcopy: should be -size or -command", opts[i].String()))
+		}
+	}
+
+	var data string
+	if size < 0 {
+		data = in.readAll()
+	} else {
+		data = in.readBytes(size)
+	}
+	n := int64(out.write(data))
+	if callback == nil {
+		return MkInt(n)
+	}
+	fr.G.Events.PostWithCred(fr.Cred, func(fr *Frame) {
+		EvalOrApplyLists(fr, []T{callback, MkList([]T{MkInt(n)})})
+	})
+	return Empty
+}
+
+func init() {
+	if Unsafes == nil {
+		Unsafes = make(map[string]Command, 333)
+	}
+
+	Unsafes["read"] = cmdRead
+	Unsafes["seek"] = cmdSeek
+	Unsafes["tell"] = cmdTell
+	Unsafes["eof"] = cmdEof
+	Unsafes["fconfigure"] = cmdFConfigure
+	Unsafes["fcopy"] = cmdFCopy
+}
@@ -0,0 +1,201 @@
+package posix
+
+import (
+	"errors"
+	. "fmt"
+	. "github.com/strickyak/tcl67/tcl"
+	"io"
+	"strconv"
+	"sync/atomic"
+)
+
+// Channels.  A Tcl channel is a Channel wrapped with buffers and the
+// options of fconfigure, registered by name in an interpreter (see
+// Frame.RegisterChannel), where gets, puts, read, close and the rest
+// find it.  Files are Channels, and so is any io.ReadWriteCloser, so a
+// host program can hand a script an HTTP body or a bytes.Buffer:
+//
+//	name := posix.AddChannel(fr, posix.ReaderChannel(resp.Body))
+//
+// "chan create mode cmdPrefix" makes a reflected channel, whose
+// methods are Tcl commands; see reflectedChannel.
+
+// Channel is the stream behind a Tcl channel.
+type Channel interface {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+var chanCounter int64
+
+// NewChannel wraps the Channel as a Tcl channel named name,
+// or "chanN" if name is empty.
+func NewChannel(name string, c Channel) T {
+	if name == "" {
+		name = Sprintf("chan%d", atomic.AddInt64(&chanCounter, 1))
+	}
+	return newTerpFile(name, c)
+}
+
+// AddChannel wraps the Channel and registers it in the interpreter,
+// returning its name.
+func AddChannel(fr *Frame, c Channel) string {
+	ch := NewChannel("", c)
+	fr.RegisterChannel(ch)
+	return ch.String()
+}
+
+// ChannelOf returns the Channel of the channel named in the interpreter.
+func ChannelOf(fr *Frame, name string) Channel {
+	tf := fileArg(fr, MkString(name))
+	Flush(tf)
+	return tf.c
+}
+
+type readerChannel struct{ io.Reader }
+
+func (readerChannel) Write([]byte) (int, error) {
+	return 0, errors.New("channel was not opened for writing")
+}
+
+func (rc readerChannel) Close() error {
+	if c, ok := rc.Reader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+type writerChannel struct{ io.Writer }
+
+func (writerChannel) Read([]byte) (int, error) {
+	return 0, errors.New("channel was not opened for reading")
+}
+
+func (wc writerChannel) Close() error {
+	if c, ok := wc.Writer.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// ReaderChannel makes a read-only Channel.  Closing it closes r,
+// if r is an io.Closer.
+func ReaderChannel(r io.Reader) Channel {
+	return readerChannel{r}
+}
+
+// WriterChannel makes a write-only Channel.  Closing it closes w,
+// if w is an io.Closer.
+func WriterChannel(w io.Writer) Channel {
+	return writerChannel{w}
+}
+
+// reflectedChannel is a Channel whose methods are Tcl commands: the
+// command prefix is called with "read id count", "write id data" and
+// "finalize id", like a Tcl 8.5 reflected channel.  It runs in the
+// global frame of the interpreter that made it.
+type reflectedChannel struct {
+	fr     *Frame
+	cmd    T
+	id     string
+	read   bool
+	write  bool
+	closed bool
+}
+
+func (rc *reflectedChannel) call(method string, args ...T) T {
+	words := append([]T{MkString(method), MkString(rc.id)}, args...)
+	return EvalOrApplyLists(rc.fr, []T{rc.cmd, MkList(words)})
+}
+
+func (rc *reflectedChannel) Read(p []byte) (int, error) {
+	if !rc.read {
+		return 0, errors.New("channel was not opened for reading")
+	}
+	data := rc.call("read", MkInt(int64(len(p)))).String()
+	if len(data) > len(p) {
+		return 0, Errorf("reflected channel %q read returned too much data", rc.id)
+	}
+	if data == "" {
+		return 0, io.EOF
+	}
+	return copy(p, data), nil
+}
+
+func (rc *reflectedChannel) Write(p []byte) (int, error) {
+	if !rc.write {
+		return 0, errors.New("channel was not opened for writing")
+	}
+	n, err := strconv.Atoi(rc.call("write", MkString(string(p))).String())
+	if err != nil || n < 0 || n > len(p) {
+		return 0, Errorf("reflected channel %q write returned a bad count", rc.id)
+	}
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+func (rc *reflectedChannel) Close() error {
+	if !rc.closed {
+		rc.closed = true
+		rc.call("finalize")
+	}
+	return nil
+}
+
+var reflectedCounter int64
+
+// reflectChannel makes and registers a reflected channel for
+// "chan create mode cmdPrefix".  It calls "cmdPrefix initialize id mode",
+// which returns the methods the command supports.
+func reflectChannel(fr *Frame, mode, cmdPrefix T) T {
+	rc := &reflectedChannel{
+		fr:  &fr.G.Fr,
+		cmd: cmdPrefix,
+		id:  Sprintf("rc%d", atomic.AddInt64(&reflectedCounter, 1)),
+	}
+	for _, m := range mode.List() {
+		switch m.String() {
+		case "read":
+			rc.read = true
+		case "write":
+			rc.write = true
+		default:
+			panic(Sprintf("bad mode %q: should be read or write", m.String()))
+		}
+	}
+	if !rc.read && !rc.write {
+		panic("chan create: mode must include read or write")
+	}
+
+	methods := make(map[string]bool)
+	for _, m := range rc.call("initialize", mode).List() {
+		methods[m.String()] = true
+	}
+	for _, need := range []string{"initialize", "finalize"} {
+		if !methods[need] {
+			panic(Sprintf("chan create: handler does not support %q", need))
+		}
+	}
+	if rc.read && !methods["read"] || rc.write && !methods["write"] {
+		panic("chan create: handler does not support the mode")
+	}
+
+	ch := newTerpFile(rc.id, rc)
+	fr.RegisterChannel(ch)
+	return ch
+}
+
+// closeChannel closes a registered channel for "chan close".
+func closeChannel(fr *Frame, name T) {
+	tf := fileArg(fr, name)
+	fr.UnregisterChannel(tf)
+	Close(tf)
+}
+
+func init() {
+	ReflectChannel = reflectChannel
+	CloseChannel = closeChannel
+}
@@ -0,0 +1,102 @@
+package posix
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	. "github.com/strickyak/tcl67/tcl"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *bufferCloser) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestGoChannel(a *testing.T) {
+	fr := NewInterpreter()
+	in := AddChannel(fr, ReaderChannel(strings.NewReader("alpha\nbeta\n")))
+	out := &bufferCloser{}
+	outName := AddChannel(fr, out)
+	fr.SetVar("in", MkString(in))
+	fr.SetVar("out", MkString(outName))
+	fr.Eval(MkString(`
+    must alpha [gets $in]
+    puts $out [string length [gets $in]]
+    puts -nonewline $out [read $in]
+    must 1 [eof $in]
+    must -1 [tell $in]
+    mustfail {seek $in 0}
+    fconfigure $in -buffering none
+    mustfail {puts $in x}
+    mustfail {close $in}
+    mustfail {gets $in}
+    flush $out
+  `))
+	if got := out.String(); got != "4\n" {
+		a.Errorf("got %q", got)
+	}
+	if _, err := io.WriteString(ChannelOf(fr, outName), "direct"); err != nil {
+		a.Error(err)
+	}
+	fr.Eval(MkString(`close $out`))
+	if !out.closed || out.String() != "4\ndirect" {
+		a.Errorf("got %q closed=%v", out.String(), out.closed)
+	}
+}
+
+var reflectedTests = `
+  set Data "one\ntwo\n"
+  set Written ""
+  proc handler {method id args} {
+    if {$method eq "initialize"} {
+      return {initialize finalize read write watch}
+    }
+    if {$method eq "finalize"} {
+      set Finalized $id
+    }
+    if {$method eq "read"} {
+      set n [lindex $args 0]
+      set z [string range $Data 0 [expr $n - 1]]
+      set Data [string range $Data $n end]
+      return $z
+    }
+    if {$method eq "write"} {
+      append Written [lindex $args 0]
+      return [string length [lindex $args 0]]
+    }
+  }
+  set ch [chan create {read write} handler]
+  must one [gets $ch]
+  must two [gets $ch]
+  must -1 [gets $ch x]
+  puts $ch hello
+  flush $ch
+  must "hello\n" $Written
+  chan close $ch
+  must $ch $Finalized
+  mustfail {gets $ch}
+
+  proc readonly {method id args} {
+    if {$method eq "initialize"} {
+      return {initialize finalize read}
+    }
+    return ""
+  }
+  mustfail {chan create write readonly}
+  mustfail {chan create bogus readonly}
+  set ch [chan create read readonly]
+  must "" [read $ch]
+  close $ch
+`
+
+func TestReflectedChannel(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(reflectedTests))
+}
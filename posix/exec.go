@@ -1,62 +1,368 @@
 package posix
 
 import (
-	"log"
+	"bytes"
+	"errors"
+	. "fmt"
+	"io"
+	"os"
 	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
 
 	. "github.com/strickyak/tcl67/tcl"
 )
 
-func cmdExec(fr *Frame, argv []T) T {
-	commandT, argsT := Arg1v(argv)
-	command := commandT.String()
-	var args []string
-	for _, a := range argsT {
-		args = append(args, a.String())
-	}
+// exec runs a pipeline of programs, with Tcl's syntax:
+//
+//	exec ?-ignorestderr? ?-keepnewline? ?--? arg ?arg ...? ?&?
+//
+// where the args may include
+//
+//	| |&                 pipe stdout (and stderr) to the next program
+//	< file, <@ chan      read stdin from a file or channel
+//	<< value             read stdin from the value
+//	> file, >> file      write (or append) stdout to a file
+//	2> file, 2>> file    write (or append) stderr to a file
+//	>& file, >>& file    write (or append) both to a file
+//	>@ chan, 2>@ chan    write stdout (or stderr) to a channel
+//	>&@ chan             write both to a channel
+//	2>@1                 send stderr where stdout goes
+//
+// The file or channel may follow the operator as one word, as in ">out".
+// Unless redirected, the stdout of the last program is the result (without
+// its final newline, unless -keepnewline), and any output to stderr makes
+// an error, unless -ignorestderr.  A program exiting with a nonzero status
+// makes an error, setting errorCode to {CHILDSTATUS pid status}.
+// With "&", the programs run in the background, and their pids are returned.
 
-	cmd := exec.Command(command, args...)
-	/*
-		stdout, err := cmd.StdoutPipe()
-		if err != nil {
-			log.Panicf("Cannot create StdoutPipe for command %q: %v", command, err)
-		}
-	*/
+type execPipeline struct {
+	stages       [][]string
+	errToPipe    []bool // Stage's stderr also goes to the next stage.
+	stdin        io.Reader
+	stdout       io.Writer // nil to capture.
+	stderr       io.Writer // nil to capture.
+	errToOut     bool      // 2>@1
+	background   bool
+	keepNewline  bool
+	ignoreStderr bool
+	closers      []io.Closer
+}
+
+// redirections, longest first, so a prefix is not mistaken for another.
+var redirections = []string{
+	">>&", ">&@", "2>>", "2>@", "<<", "<@", ">>", ">&", ">@", "2>", "<", ">",
+}
+
+// lockedBuffer collects output written by several programs.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
 
-	bb, err := cmd.Output()
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func (p *execPipeline) openFile(fr *Frame, name string, flag int) File {
+	f, err := fr.FileSystem().OpenFile(name, flag, 0666)
 	if err != nil {
-		if ee, ok := err.(*exec.ExitError); ok {
-			log.Panicf("Error in command %q: %q", command, ee.Stderr)
-		}
-		log.Panicf("Error in command %q: %v", command, err)
+		panic(Sprintf(`couldn't open %q: %s`, name, err.Error()))
 	}
+	p.closers = append(p.closers, f)
+	return f
+}
 
-	return MkString(string(bb))
+// channelWriter returns a writer for the channel, flushing it first.
+func channelWriter(fr *Frame, name string) io.Writer {
+	tf := fileArg(fr, MkString(name))
+	Flush(tf)
+	if tf.c == nil {
+		panic(Sprintf("channel %q is closed", name))
+	}
+	return tf.c
+}
 
-	/*
-		if err := cmd.Start(); err != nil {
-			log.Panicf("Cannot Start command %q: %v", command, err)
+func channelReader(fr *Frame, name string) io.Reader {
+	tf := fileArg(fr, MkString(name))
+	if tf.r != nil && tf.r.Buffered() > 0 {
+		return tf.r
+	}
+	if tf.c == nil {
+		panic(Sprintf("channel %q is closed", name))
+	}
+	return tf.c
+}
+
+func (p *execPipeline) redirect(fr *Frame, op, target string) {
+	const (
+		truncFlags  = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		appendFlags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	)
+	switch op {
+	case "<":
+		p.stdin = p.openFile(fr, target, os.O_RDONLY)
+	case "<@":
+		p.stdin = channelReader(fr, target)
+	case "<<":
+		p.stdin = strings.NewReader(target)
+	case ">":
+		p.stdout = p.openFile(fr, target, truncFlags)
+	case ">>":
+		p.stdout = p.openFile(fr, target, appendFlags)
+	case "2>":
+		p.stderr = p.openFile(fr, target, truncFlags)
+	case "2>>":
+		p.stderr = p.openFile(fr, target, appendFlags)
+	case ">&":
+		p.stdout = p.openFile(fr, target, truncFlags)
+		p.stderr = p.stdout
+	case ">>&":
+		p.stdout = p.openFile(fr, target, appendFlags)
+		p.stderr = p.stdout
+	case ">@":
+		p.stdout = channelWriter(fr, target)
+	case "2>@":
+		p.stderr = channelWriter(fr, target)
+	case ">&@":
+		p.stdout = channelWriter(fr, target)
+		p.stderr = p.stdout
+	}
+}
+
+func parseExec(fr *Frame, argv []T) *execPipeline {
+	p := &execPipeline{}
+	words := argv[1:]
+	for len(words) > 0 && strings.HasPrefix(words[0].String(), "-") {
+		opt := words[0].String()
+		words = words[1:]
+		if opt == "--" {
+			break
+		}
+		switch opt {
+		case "-ignorestderr":
+			p.ignoreStderr = true
+		case "-keepnewline":
+			p.keepNewline = true
+		default:
+			panic(Sprintf(`bad option %q for "exec": should be -ignorestderr, -keepnewline, or --`, opt))
 		}
+	}
+	if n := len(words); n > 0 && words[n-1].String() == "&" {
+		p.background = true
+		words = words[:n-1]
+	}
 
-		var outbuf bytes.Buffer
-		for {
-			b := make([]byte, 4096)
-			cc, err := stdout.Read(b)
-			if err == io.EOF {
+	var stage []string
+	endStage := func(errToPipe bool) {
+		if len(stage) == 0 {
+			panic(`illegal use of | or |& in "exec" command`)
+		}
+		p.stages = append(p.stages, stage)
+		p.errToPipe = append(p.errToPipe, errToPipe)
+		stage = nil
+	}
+	for i := 0; i < len(words); i++ {
+		w := words[i].String()
+		switch w {
+		case "|", "|&":
+			endStage(w == "|&")
+			continue
+		case "2>@1":
+			p.errToOut = true
+			continue
+		}
+		op := ""
+		for _, r := range redirections {
+			if strings.HasPrefix(w, r) {
+				op = r
 				break
 			}
-			if err != nil {
-				log.Panicf("Cannot read stdout of command %q: %v", command, err)
-			}
-			if cc == 0 {
+		}
+		if op == "" {
+			stage = append(stage, w)
+			continue
+		}
+		target := w[len(op):]
+		if target == "" {
+			i++
+			if i >= len(words) {
+				panic(Sprintf(`can't specify %q as last word in "exec" command`, op))
 			}
-			outbuf.Write(b[:cc])
+			target = words[i].String()
+		}
+		p.redirect(fr, op, target)
+	}
+	if len(stage) == 0 {
+		panic(`didn't specify command to execute`)
+	}
+	p.stages = append(p.stages, stage)
+	p.errToPipe = append(p.errToPipe, false)
+	return p
+}
+
+// ExitCode returns the exit status of a finished program, and the
+// name of the signal that killed it, if any.
+func ExitCode(state *os.ProcessState) (int, string) {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return -1, ws.Signal().String()
+	}
+	return state.ExitCode(), ""
+}
+
+// childError makes the error for a program that failed, setting errorCode.
+func childError(fr *Frame, pid int, state *os.ProcessState) string {
+	code, signal := ExitCode(state)
+	if signal != "" {
+		(&fr.G.Fr).SetVar("errorCode", MkStringList([]string{"CHILDKILLED", Sprint(pid), signal}))
+		return Sprintf("child killed: %s", signal)
+	}
+	(&fr.G.Fr).SetVar("errorCode", MkStringList([]string{"CHILDSTATUS", Sprint(pid), Sprint(code)}))
+	return "child process exited abnormally"
+}
+
+// startError makes the error for a program that could not start.
+func startError(fr *Frame, name string, err error) string {
+	msg, code := err.Error(), "EIO"
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, exec.ErrNotFound) {
+		msg, code = "no such file or directory", "ENOENT"
+	}
+	(&fr.G.Fr).SetVar("errorCode", MkStringList([]string{"POSIX", code, msg}))
+	return Sprintf(`couldn't execute %q: %s`, name, msg)
+}
+
+// commands makes the exec.Cmds of the pipeline, connected by pipes.
+// The final stdout and the captured stderr are connected by run.
+func (p *execPipeline) commands(fr *Frame) ([]*exec.Cmd, []io.Closer) {
+	var cmds []*exec.Cmd
+	var pipeEnds []io.Closer
+	for _, stage := range p.stages {
+		cmd := exec.Command(stage[0], stage[1:]...)
+		cmds = append(cmds, cmd)
+	}
+	cmds[0].Stdin = p.stdin
+	if p.stdin == nil {
+		cmds[0].Stdin = os.Stdin
+	}
+	for i := 0; i+1 < len(cmds); i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			panic(Sprintf(`couldn't create pipe: %s`, err.Error()))
+		}
+		cmds[i].Stdout = w
+		if p.errToPipe[i] {
+			cmds[i].Stderr = w
+		}
+		cmds[i+1].Stdin = r
+		pipeEnds = append(pipeEnds, r, w)
+	}
+	return cmds, pipeEnds
+}
+
+func (p *execPipeline) run(fr *Frame) T {
+	defer func() {
+		for _, c := range p.closers {
+			c.Close()
+		}
+	}()
+
+	cmds, pipeEnds := p.commands(fr)
+	last := cmds[len(cmds)-1]
+
+	var outBuf, errBuf lockedBuffer
+	stdout, stderr := p.stdout, p.stderr
+	if stdout == nil {
+		if p.background {
+			stdout = os.Stdout
+		} else {
+			stdout = &outBuf
+		}
+	}
+	if stderr == nil {
+		if p.ignoreStderr || p.background {
+			stderr = os.Stderr
+		} else {
+			stderr = &errBuf
+		}
+	}
+	if p.errToOut {
+		stderr = stdout
+	}
+	last.Stdout = stdout
+	for _, cmd := range cmds {
+		if cmd.Stderr == nil {
+			cmd.Stderr = stderr
 		}
+	}
 
-		if err := cmd.Wait(); err != nil {
-			log.Panicf("Bad Wait of command %q: %v", command, err)
+	var started []*exec.Cmd
+	var startErr string
+	for i, cmd := range cmds {
+		if err := cmd.Start(); err != nil {
+			startErr = startError(fr, p.stages[i][0], err)
+			break
 		}
-	*/
+		started = append(started, cmd)
+	}
+	// Close our copies of the pipes, so programs see EOF.
+	for _, c := range pipeEnds {
+		c.Close()
+	}
+
+	if p.background && startErr == "" {
+		var pids []T
+		for _, cmd := range started {
+			pids = append(pids, MkInt(int64(cmd.Process.Pid)))
+			go cmd.Wait()
+		}
+		return MkList(pids)
+	}
+
+	var failures []string
+	for _, cmd := range started {
+		cmd.Wait()
+		if !cmd.ProcessState.Success() {
+			failures = append(failures, childError(fr, cmd.Process.Pid, cmd.ProcessState))
+		}
+	}
+	if startErr != "" {
+		panic(startErr)
+	}
+
+	result := outBuf.String()
+	if !p.keepNewline {
+		result = strings.TrimSuffix(result, "\n")
+	}
+	errText := errBuf.String()
+	if len(failures) > 0 || errText != "" {
+		msg := result
+		if errText != "" {
+			msg = strings.TrimSuffix(msg+"\n"+errText, "\n")
+		} else {
+			msg = strings.TrimPrefix(msg+"\n"+strings.Join(failures, "\n"), "\n")
+		}
+		if len(failures) == 0 {
+			(&fr.G.Fr).SetVar("errorCode", MkString("NONE"))
+		}
+		panic(strings.TrimPrefix(msg, "\n"))
+	}
+	return MkString(result)
+}
+
+func cmdExec(fr *Frame, argv []T) T {
+	if len(argv) < 2 {
+		panic(`usage: exec ?-ignorestderr? ?-keepnewline? ?--? arg ?arg ...?`)
+	}
+	return parseExec(fr, argv).run(fr)
 }
 
 func init() {
@@ -65,4 +371,5 @@ func init() {
 	}
 
 	Unsafes["exec"] = cmdExec
+	RequireCaps("exec", "exec")
 }
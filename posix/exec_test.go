@@ -0,0 +1,140 @@
+package posix
+
+import (
+	. "github.com/strickyak/tcl67/tcl"
+	"os"
+	"testing"
+)
+
+var execTests = `
+  set dir "[file tempdir][file separator]tmp.posix_test.go.exec"
+  exec rm -rf $dir
+  exec mkdir -p $dir
+
+  must hello [exec echo hello]
+  must "hello\n" [exec -keepnewline echo hello]
+  must HELLO [exec echo hello | tr a-z A-Z]
+  must 3 [exec printf "a\nb\nc\n" | wc -l | tr -d " "]
+  must cba [exec rev << abc]
+  must cba [exec rev <<abc]
+
+  exec echo one > $dir/out
+  exec echo two >>$dir/out
+  must "one\ntwo" [exec cat < $dir/out]
+  must "one\ntwo" [exec cat $dir/out]
+
+  mustfail {exec sh -c "echo oops >&2"}
+  catch {exec sh -c "echo out; echo oops >&2"} msg
+  must 1 [string match "out\noops*" $msg]
+  must "" [exec -ignorestderr sh -c "echo ignored >&2"]
+  must "out\nerr" [exec sh -c "echo out; echo err >&2" 2>@1]
+  exec sh -c "echo err >&2" 2> $dir/err
+  must err [exec cat $dir/err]
+  exec sh -c "echo both; echo err >&2" >& $dir/both
+  must "both\nerr" [exec sort $dir/both]
+  must "x\ny" [exec sh -c "echo x; echo y >&2" |& cat]
+
+  mustfail {exec false}
+  catch {exec sh -c "exit 3"}
+  must CHILDSTATUS [lindex $errorCode 0]
+  must 3 [lindex $errorCode 2]
+  mustfail {exec no-such-program-anywhere}
+  must ENOENT [lindex $errorCode 1]
+
+  set f [open $dir/chan w]
+  puts $f first
+  exec echo second >@ $f
+  close $f
+  must "first\nsecond" [exec cat $dir/chan]
+  set f [open $dir/chan r]
+  must "FIRST\nSECOND" [exec tr a-z A-Z <@ $f]
+  close $f
+
+  set pids [exec sleep 0 &]
+  must 1 [llength $pids]
+  mustfail {exec}
+  mustfail {exec echo |}
+  mustfail {exec echo >}
+  mustfail {exec -bogus echo}
+  must -x [exec -- printf %s -x]
+
+  exec rm -rf $dir
+`
+
+func TestExec(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(execTests))
+}
+
+var pipeTests = `
+  set p [open "|cat" r+]
+  must 1 [llength [pid $p]]
+  puts $p hello
+  flush $p
+  must hello [gets $p]
+  puts $p world
+  flush $p
+  must world [gets $p]
+  close $p
+
+  set p [open "|printf {a\nb\n} | sort -r"]
+  must b [gets $p]
+  must a [gets $p]
+  must -1 [gets $p x]
+  must 1 [eof $p]
+  must 2 [llength [pid $p]]
+  close $p
+
+  set dir "[file tempdir][file separator]tmp.posix_test.go.pipe"
+  exec mkdir -p $dir
+  set p [open "|cat > $dir/out" w]
+  puts $p "written through a pipe"
+  close $p
+  must "written through a pipe" [exec cat $dir/out]
+  exec rm -rf $dir
+
+  set p [open "|sh -c {exit 4}"]
+  mustfail {close $p}
+  must {CHILDSTATUS 4} [list [lindex $errorCode 0] [lindex $errorCode 2]]
+  mustfail {open "|no-such-program-anywhere"}
+  mustfail {open "|sleep 1 &"}
+  mustfail {open "|cat < /dev/null" w}
+  mustfail {withcred {file} {open "|true"}}
+  must 1 [expr [pid] > 0]
+  set f [open /dev/null]
+  must {} [pid $f]
+  close $f
+
+  set env(TCL67_PIPE_TEST) piped
+  must piped [exec sh -c {echo $TCL67_PIPE_TEST}]
+  set p [open "|sh -c {echo \$TCL67_PIPE_TEST}"]
+  must piped [gets $p]
+  close $p
+  unset env(TCL67_PIPE_TEST)
+  must {} [exec sh -c {echo $TCL67_PIPE_TEST}]
+`
+
+func TestPipe(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(pipeTests))
+}
+
+func TestPipeFailureCloses(a *testing.T) {
+	openFiles := func() int {
+		ents, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			a.Skip("cannot count open files")
+		}
+		return len(ents)
+	}
+	fr := NewInterpreter()
+	failing := MkString(`catch {open "|no-such-program-anywhere" r+} msg`)
+	fr.Eval(failing)
+	before := openFiles()
+	for i := 0; i < 10; i++ {
+		fr.Eval(failing)
+	}
+	if after := openFiles(); after > before {
+		a.Errorf("failed pipelines left files open: %d before, %d after", before, after)
+	}
+}
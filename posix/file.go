@@ -0,0 +1,885 @@
+package posix
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	. "fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	R "reflect"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/strickyak/tcl67/tcl"
+)
+
+// The file, glob, cd and pwd commands.  Names use "/" as the separator,
+// and everything that touches files goes through the interpreter's
+// FileSystem.  link, readable, writable, executable, type, atime and
+// setting mtime need a FileSystem that implements LinkFS or AttrFS,
+// as OSFS does.
+
+var fileEnsemble = []EnsembleItem{
+	EnsembleItem{Name: "atime", Cmd: cmdFileATime, Doc: "name"},
+	EnsembleItem{Name: "copy", Cmd: cmdFileCopy, Doc: "?-force? ?--? source ?source ...? target"},
+	EnsembleItem{Name: "delete", Cmd: cmdFileDelete, Doc: "?-force? ?--? ?name ...?"},
+	EnsembleItem{Name: "dirname", Cmd: cmdFileDirname, Doc: "name"},
+	EnsembleItem{Name: "executable", Cmd: cmdFileExecutable, Doc: "name"},
+	EnsembleItem{Name: "exists", Cmd: cmdFileExists, Doc: "name"},
+	EnsembleItem{Name: "extension", Cmd: cmdFileExtension, Doc: "name"},
+	EnsembleItem{Name: "isdirectory", Cmd: cmdFileIsDirectory, Doc: "name"},
+	EnsembleItem{Name: "isfile", Cmd: cmdFileIsFile, Doc: "name"},
+	EnsembleItem{Name: "join", Cmd: cmdFileJoin, Doc: "name ?name ...?"},
+	EnsembleItem{Name: "link", Cmd: cmdFileLink, Doc: "?-symbolic|-hard? linkName ?target?"},
+	EnsembleItem{Name: "lstat", Cmd: cmdFileLStat, Doc: "name varName"},
+	EnsembleItem{Name: "mkdir", Cmd: cmdFileMkdir, Doc: "?dir ...?"},
+	EnsembleItem{Name: "mtime", Cmd: cmdFileMTime, Doc: "name ?time?"},
+	EnsembleItem{Name: "nativename", Cmd: cmdFileNativeName, Doc: "name"},
+	EnsembleItem{Name: "normalize", Cmd: cmdFileNormalize, Doc: "name"},
+	EnsembleItem{Name: "pathtype", Cmd: cmdFilePathType, Doc: "name"},
+	EnsembleItem{Name: "readable", Cmd: cmdFileReadable, Doc: "name"},
+	EnsembleItem{Name: "readlink", Cmd: cmdFileReadLink, Doc: "name"},
+	EnsembleItem{Name: "rename", Cmd: cmdFileRename, Doc: "?-force? ?--? source ?source ...? target"},
+	EnsembleItem{Name: "rootname", Cmd: cmdFileRootname, Doc: "name"},
+	EnsembleItem{Name: "separator", Cmd: cmdFileSeparator},
+	EnsembleItem{Name: "size", Cmd: cmdFileSize, Doc: "name"},
+	EnsembleItem{Name: "split", Cmd: cmdFileSplit, Doc: "name"},
+	EnsembleItem{Name: "stat", Cmd: cmdFileStat, Doc: "name varName"},
+	EnsembleItem{Name: "tail", Cmd: cmdFileTail, Doc: "name"},
+	EnsembleItem{Name: "tempdir", Cmd: cmdFileTempdir},
+	EnsembleItem{Name: "tempfile", Cmd: cmdFileTempFile, Doc: "?varName? ?template?"},
+	EnsembleItem{Name: "type", Cmd: cmdFileType, Doc: "name"},
+	EnsembleItem{Name: "writable", Cmd: cmdFileWritable, Doc: "name"},
+}
+
+// fileError panics with a Tcl-like message for a failed file operation.
+func fileError(op, name string, err error) {
+	msg := err.Error()
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		msg = pe.Err.Error()
+	}
+	panic(Sprintf("error %s %q: %s", op, name, msg))
+}
+
+func fileStat(fr *Frame, argv []T) (os.FileInfo, bool) {
+	name := Arg1(argv)
+	info, err := fr.FileSystem().Stat(name.String())
+	return info, err == nil
+}
+
+// mustStat returns the FileInfo, or panics like Tcl.
+func mustStat(fr *Frame, name string) os.FileInfo {
+	info, err := fr.FileSystem().Stat(name)
+	if err != nil {
+		fileError("reading", name, err)
+	}
+	return info
+}
+
+// lstat returns the FileInfo of a link itself, if the FileSystem has links.
+func lstat(fsys FileSystem, name string) (os.FileInfo, error) {
+	if l, ok := fsys.(LinkFS); ok {
+		return l.Lstat(name)
+	}
+	return fsys.Stat(name)
+}
+
+func cmdFileExists(fr *Frame, argv []T) T {
+	_, ok := fileStat(fr, argv)
+	return MkBool(ok)
+}
+
+func cmdFileIsFile(fr *Frame, argv []T) T {
+	info, ok := fileStat(fr, argv)
+	return MkBool(ok && info.Mode().IsRegular())
+}
+
+func cmdFileIsDirectory(fr *Frame, argv []T) T {
+	info, ok := fileStat(fr, argv)
+	return MkBool(ok && info.IsDir())
+}
+
+// access checks permission, using AttrFS if possible, or else the
+// owner's permission bits.
+func access(fr *Frame, argv []T, mode uint32) T {
+	name := Arg1(argv).String()
+	fsys := fr.FileSystem()
+	if a, ok := fsys.(AttrFS); ok {
+		return MkBool(a.Access(name, mode) == nil)
+	}
+	info, err := fsys.Stat(name)
+	if err != nil {
+		return False
+	}
+	return MkBool(uint32(info.Mode().Perm()>>6)&mode == mode)
+}
+
+func cmdFileReadable(fr *Frame, argv []T) T   { return access(fr, argv, 4) }
+func cmdFileWritable(fr *Frame, argv []T) T   { return access(fr, argv, 2) }
+func cmdFileExecutable(fr *Frame, argv []T) T { return access(fr, argv, 1) }
+
+func cmdFileSize(fr *Frame, argv []T) T {
+	name := Arg1(argv).String()
+	return MkInt(mustStat(fr, name).Size())
+}
+
+func cmdFileMTime(fr *Frame, argv []T) T {
+	name, optionalTime := Arg1v(argv)
+	if len(optionalTime) > 1 {
+		panic("usage: file mtime name ?time?")
+	}
+	if len(optionalTime) == 1 {
+		a, ok := fr.FileSystem().(AttrFS)
+		if !ok {
+			panic("file mtime: cannot set times in this file system")
+		}
+		t := time.Unix(optionalTime[0].Int(), 0)
+		atime := sysTime(mustStat(fr, name.String()), "Atim", "Atimespec")
+		if err := a.Chtimes(name.String(), atime, t); err != nil {
+			fileError("setting time of", name.String(), err)
+		}
+		return optionalTime[0]
+	}
+	return MkInt(mustStat(fr, name.String()).ModTime().Unix())
+}
+
+func cmdFileATime(fr *Frame, argv []T) T {
+	name := Arg1(argv).String()
+	return MkInt(sysTime(mustStat(fr, name), "Atim", "Atimespec").Unix())
+}
+
+// sysField returns a field of the system-specific stat structure,
+// which differs between operating systems, or an invalid Value.
+func sysField(info os.FileInfo, names ...string) R.Value {
+	sys := R.ValueOf(info.Sys())
+	if sys.Kind() == R.Ptr {
+		sys = sys.Elem()
+	}
+	if sys.Kind() != R.Struct {
+		return InvalidValue
+	}
+	for _, n := range names {
+		if f := sys.FieldByName(n); f.IsValid() {
+			return f
+		}
+	}
+	return InvalidValue
+}
+
+func sysInt(info os.FileInfo, names ...string) int64 {
+	f := sysField(info, names...)
+	switch f.Kind() {
+	case R.Int, R.Int8, R.Int16, R.Int32, R.Int64:
+		return f.Int()
+	case R.Uint, R.Uint8, R.Uint16, R.Uint32, R.Uint64:
+		return int64(f.Uint())
+	}
+	return -1
+}
+
+// sysTime returns a time from the stat structure, or the ModTime.
+func sysTime(info os.FileInfo, names ...string) time.Time {
+	f := sysField(info, names...)
+	if f.Kind() == R.Struct {
+		sec, nsec := f.FieldByName("Sec"), f.FieldByName("Nsec")
+		if sec.IsValid() && nsec.IsValid() {
+			return time.Unix(sec.Int(), nsec.Int())
+		}
+	}
+	return info.ModTime()
+}
+
+func fileType(mode os.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "directory"
+	case mode&os.ModeSymlink != 0:
+		return "link"
+	case mode&os.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeCharDevice != 0:
+		return "characterSpecial"
+	case mode&os.ModeDevice != 0:
+		return "blockSpecial"
+	}
+	return "file"
+}
+
+func cmdFileType(fr *Frame, argv []T) T {
+	name := Arg1(argv).String()
+	info, err := lstat(fr.FileSystem(), name)
+	if err != nil {
+		fileError("reading", name, err)
+	}
+	return MkString(fileType(info.Mode()))
+}
+
+// setStat fills the array with the fields of Tcl's file stat.
+func setStat(fr *Frame, varName string, info os.FileInfo) {
+	h := Hash{
+		"atime": MkInt(sysTime(info, "Atim", "Atimespec").Unix()),
+		"ctime": MkInt(sysTime(info, "Ctim", "Ctimespec").Unix()),
+		"mtime": MkInt(info.ModTime().Unix()),
+		"dev":   MkInt(sysInt(info, "Dev")),
+		"ino":   MkInt(sysInt(info, "Ino")),
+		"nlink": MkInt(sysInt(info, "Nlink")),
+		"uid":   MkInt(sysInt(info, "Uid")),
+		"gid":   MkInt(sysInt(info, "Gid")),
+		"mode":  MkInt(int64(info.Mode().Perm())),
+		"size":  MkInt(info.Size()),
+		"type":  MkString(fileType(info.Mode())),
+	}
+	fr.SetVar(varName, MkHash(h))
+}
+
+func cmdFileStat(fr *Frame, argv []T) T {
+	name, varName := Arg2(argv)
+	setStat(fr, varName.String(), mustStat(fr, name.String()))
+	return Empty
+}
+
+func cmdFileLStat(fr *Frame, argv []T) T {
+	name, varName := Arg2(argv)
+	info, err := lstat(fr.FileSystem(), name.String())
+	if err != nil {
+		fileError("reading", name.String(), err)
+	}
+	setStat(fr, varName.String(), info)
+	return Empty
+}
+
+func cmdFileSeparator(fr *Frame, argv []T) T {
+	Arg0(argv)
+	return MkString(string(os.PathSeparator))
+}
+
+func cmdFileTempdir(fr *Frame, argv []T) T {
+	Arg0(argv)
+	return MkString(os.TempDir())
+}
+
+// JoinPath joins names as Tcl does: an absolute name discards what
+// came before it.
+func JoinPath(names ...string) string {
+	z := ""
+	for _, n := range names {
+		switch {
+		case n == "":
+		case strings.HasPrefix(n, "/") || z == "":
+			z = n
+		default:
+			z = strings.TrimSuffix(z, "/") + "/" + n
+		}
+	}
+	if len(z) > 1 {
+		z = strings.TrimSuffix(z, "/")
+	}
+	return z
+}
+
+// SplitPath splits a name into its elements; an absolute name's
+// first element is "/".
+func SplitPath(name string) []string {
+	var z []string
+	if strings.HasPrefix(name, "/") {
+		z = append(z, "/")
+	}
+	for _, e := range strings.Split(name, "/") {
+		if e != "" {
+			z = append(z, e)
+		}
+	}
+	return z
+}
+
+func cmdFileJoin(fr *Frame, argv []T) T {
+	if len(argv) < 2 {
+		panic("usage: file join name ?name ...?")
+	}
+	var names []string
+	for _, a := range argv[1:] {
+		names = append(names, a.String())
+	}
+	return MkString(JoinPath(names...))
+}
+
+func cmdFileSplit(fr *Frame, argv []T) T {
+	name := Arg1(argv)
+	return MkStringList(SplitPath(name.String()))
+}
+
+func cmdFileDirname(fr *Frame, argv []T) T {
+	name := Arg1(argv).String()
+	parts := SplitPath(name)
+	switch {
+	case len(parts) == 0:
+		return MkString(".")
+	case len(parts) == 1 && parts[0] == "/":
+		return MkString("/")
+	case len(parts) == 1:
+		return MkString(".")
+	}
+	return MkString(JoinPath(parts[:len(parts)-1]...))
+}
+
+func cmdFileTail(fr *Frame, argv []T) T {
+	name := Arg1(argv).String()
+	parts := SplitPath(name)
+	if len(parts) == 0 || parts[len(parts)-1] == "/" {
+		return Empty
+	}
+	return MkString(parts[len(parts)-1])
+}
+
+// extensionIndex returns the index of the extension in name, or -1.
+func extensionIndex(name string) int {
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 || strings.IndexByte(name[i:], '/') >= 0 {
+		return -1
+	}
+	return i
+}
+
+func cmdFileExtension(fr *Frame, argv []T) T {
+	name := Arg1(argv).String()
+	if i := extensionIndex(name); i >= 0 {
+		return MkString(name[i:])
+	}
+	return Empty
+}
+
+func cmdFileRootname(fr *Frame, argv []T) T {
+	name := Arg1(argv).String()
+	if i := extensionIndex(name); i >= 0 {
+		return MkString(name[:i])
+	}
+	return MkString(name)
+}
+
+func cmdFileNormalize(fr *Frame, argv []T) T {
+	name := Arg1(argv).String()
+	if !strings.HasPrefix(name, "/") {
+		name = workingDir(fr) + "/" + name
+	}
+	return MkString(path.Clean(name))
+}
+
+func cmdFileNativeName(fr *Frame, argv []T) T {
+	name := Arg1(argv).String()
+	return MkString(filepath.FromSlash(name))
+}
+
+func cmdFilePathType(fr *Frame, argv []T) T {
+	name := Arg1(argv).String()
+	if strings.HasPrefix(name, "/") {
+		return MkString("absolute")
+	}
+	return MkString("relative")
+}
+
+// forceArgs strips ?-force? ?--? from the args.
+func forceArgs(args []T) (bool, []T) {
+	force := false
+	for len(args) > 0 && strings.HasPrefix(args[0].String(), "-") {
+		opt := args[0].String()
+		args = args[1:]
+		if opt == "--" {
+			break
+		}
+		if opt != "-force" {
+			panic(Sprintf("bad option %q: should be -force or --", opt))
+		}
+		force = true
+	}
+	return force, args
+}
+
+// removeAll removes the name and anything under it.
+func removeAll(fsys FileSystem, name string) error {
+	info, err := lstat(fsys, name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		entries, err := fsys.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := removeAll(fsys, name+"/"+e.Name()); err != nil {
+				return err
+			}
+		}
+	}
+	return fsys.Remove(name)
+}
+
+func cmdFileDelete(fr *Frame, argv []T) T {
+	force, names := forceArgs(argv[1:])
+	fsys := fr.FileSystem()
+	for _, n := range names {
+		name := n.String()
+		info, err := lstat(fsys, name)
+		if err != nil {
+			continue // Deleting what does not exist is not an error.
+		}
+		if info.IsDir() && force {
+			err = removeAll(fsys, name)
+		} else {
+			err = fsys.Remove(name)
+			if err != nil && info.IsDir() {
+				panic(Sprintf("error deleting %q: directory not empty", name))
+			}
+		}
+		if err != nil {
+			fileError("deleting", name, err)
+		}
+	}
+	return Empty
+}
+
+// mkdirAll makes the directory and any missing parents.
+func mkdirAll(fsys FileSystem, name string) error {
+	info, err := fsys.Stat(name)
+	if err == nil {
+		if info.IsDir() {
+			return nil
+		}
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if parent := path.Dir(name); parent != name && parent != "." && parent != "/" {
+		if err := mkdirAll(fsys, parent); err != nil {
+			return err
+		}
+	}
+	return fsys.Mkdir(name, 0777)
+}
+
+func cmdFileMkdir(fr *Frame, argv []T) T {
+	fsys := fr.FileSystem()
+	for _, n := range argv[1:] {
+		if err := mkdirAll(fsys, n.String()); err != nil {
+			fileError("creating directory", n.String(), err)
+		}
+	}
+	return Empty
+}
+
+// copyFile copies a file, or a directory and its contents.
+func copyFile(fsys FileSystem, src, dst string) error {
+	info, err := fsys.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		if err := mkdirAll(fsys, dst); err != nil {
+			return err
+		}
+		entries, err := fsys.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := copyFile(fsys, src+"/"+e.Name(), dst+"/"+e.Name()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	in, err := fsys.OpenFile(src, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := fsys.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// copyOrRename implements file copy and file rename, which take
+// ?-force? ?--? source ?source ...? target.  With several sources,
+// or a target that is a directory, the sources go into the target.
+func copyOrRename(fr *Frame, argv []T, op string, fn func(fsys FileSystem, src, dst string) error) T {
+	force, names := forceArgs(argv[1:])
+	if len(names) < 2 {
+		panic(Sprintf("usage: file %s ?-force? ?--? source ?source ...? target", op))
+	}
+	fsys := fr.FileSystem()
+	target := names[len(names)-1].String()
+	sources := names[:len(names)-1]
+	tinfo, terr := fsys.Stat(target)
+	intoDir := terr == nil && tinfo.IsDir()
+	if len(sources) > 1 && !intoDir {
+		panic(Sprintf("error %s: target %q is not a directory", op, target))
+	}
+	wd := workingDir(fr)
+	for _, s := range sources {
+		src := s.String()
+		dst := target
+		if intoDir {
+			dst = JoinPath(target, path.Base(src))
+		}
+		// Check before removing anything, so the target cannot be the source
+		// or lie within it.
+		cleanSrc, cleanDst := path.Clean(JoinPath(wd, src)), path.Clean(JoinPath(wd, dst))
+		sinfo, serr := lstat(fsys, src)
+		dinfo, derr := lstat(fsys, dst)
+		if cleanSrc == cleanDst || serr == nil && derr == nil && os.SameFile(sinfo, dinfo) {
+			panic(Sprintf("error %s %q to %q: source and target are the same", op, src, dst))
+		}
+		if strings.HasPrefix(cleanDst, strings.TrimSuffix(cleanSrc, "/")+"/") {
+			panic(Sprintf("error %s %q to %q: target is inside the source", op, src, dst))
+		}
+		if derr == nil {
+			if !force {
+				panic(Sprintf("error %s %q to %q: file already exists", op, src, dst))
+			}
+			if err := removeAll(fsys, dst); err != nil {
+				fileError(op, dst, err)
+			}
+		}
+		if err := fn(fsys, src, dst); err != nil {
+			fileError(op, src, err)
+		}
+	}
+	return Empty
+}
+
+func cmdFileCopy(fr *Frame, argv []T) T {
+	return copyOrRename(fr, argv, "copying", copyFile)
+}
+
+func cmdFileRename(fr *Frame, argv []T) T {
+	return copyOrRename(fr, argv, "renaming", func(fsys FileSystem, src, dst string) error {
+		return fsys.Rename(src, dst)
+	})
+}
+
+func linkFS(fr *Frame) LinkFS {
+	l, ok := fr.FileSystem().(LinkFS)
+	if !ok {
+		panic("links are not supported in this file system")
+	}
+	return l
+}
+
+func cmdFileLink(fr *Frame, argv []T) T {
+	args := argv[1:]
+	kind := ""
+	if len(args) > 0 && (args[0].String() == "-symbolic" || args[0].String() == "-hard") {
+		kind = args[0].String()
+		args = args[1:]
+	}
+	switch len(args) {
+	case 1:
+		if kind != "" {
+			panic("usage: file link ?-symbolic|-hard? linkName ?target?")
+		}
+		return cmdFileReadLink(fr, []T{argv[0], args[0]})
+	case 2:
+		l := linkFS(fr)
+		name, target := args[0].String(), args[1].String()
+		var err error
+		if kind == "-hard" {
+			err = l.Link(target, name)
+		} else {
+			err = l.Symlink(target, name)
+		}
+		if err != nil {
+			fileError("creating link", name, err)
+		}
+		return MkString(target)
+	}
+	panic("usage: file link ?-symbolic|-hard? linkName ?target?")
+}
+
+func cmdFileReadLink(fr *Frame, argv []T) T {
+	name := Arg1(argv).String()
+	target, err := linkFS(fr).Readlink(name)
+	if err != nil {
+		fileError("reading link", name, err)
+	}
+	return MkString(filepath.ToSlash(target))
+}
+
+// cmdFileTempFile opens a new temporary file for writing, returning the
+// channel, and setting varName to its name.  The template is a name
+// prefix, which may include a directory.
+func cmdFileTempFile(fr *Frame, argv []T) T {
+	if len(argv) > 3 {
+		panic("usage: file tempfile ?varName? ?template?")
+	}
+	prefix := JoinPath(filepath.ToSlash(os.TempDir()), "tcl")
+	if len(argv) == 3 {
+		prefix = argv[2].String()
+		if !strings.Contains(prefix, "/") {
+			prefix = JoinPath(filepath.ToSlash(os.TempDir()), prefix)
+		}
+	}
+	fsys := fr.FileSystem()
+	for tries := 0; ; tries++ {
+		bb := make([]byte, 6)
+		rand.Read(bb)
+		name := prefix + "_" + hex.EncodeToString(bb)
+		f, err := fsys.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			if errors.Is(err, fs.ErrExist) && tries < 100 {
+				continue
+			}
+			fileError("creating temporary file", name, err)
+		}
+		if len(argv) >= 2 {
+			fr.SetVar(argv[1].String(), MkString(name))
+		}
+		z := MkFile(f)
+		fr.RegisterChannel(z)
+		return z
+	}
+}
+
+// expandBraces expands {a,b} alternatives in a glob pattern.
+func expandBraces(pattern string) []string {
+	open := strings.IndexByte(pattern, '{')
+	if open < 0 {
+		return []string{pattern}
+	}
+	depth := 0
+	var alts []string
+	start := open + 1
+	for i := open; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '{':
+			depth++
+		case ',':
+			if depth == 1 {
+				alts = append(alts, pattern[start:i])
+				start = i + 1
+			}
+		case '}':
+			depth--
+			if depth == 0 {
+				alts = append(alts, pattern[start:i])
+				var z []string
+				for _, alt := range alts {
+					z = append(z, expandBraces(pattern[:open]+alt+pattern[i+1:])...)
+				}
+				return z
+			}
+		}
+	}
+	panic(Sprintf("unmatched open-brace in file name %q", pattern))
+}
+
+// globType tells if a FileInfo matches a type letter of glob -types.
+func globType(fr *Frame, name string, info os.FileInfo, t string) bool {
+	switch t {
+	case "d":
+		return info.IsDir()
+	case "f":
+		return info.Mode().IsRegular()
+	case "l":
+		return info.Mode()&os.ModeSymlink != 0
+	case "p":
+		return info.Mode()&os.ModeNamedPipe != 0
+	case "s":
+		return info.Mode()&os.ModeSocket != 0
+	case "c":
+		return info.Mode()&os.ModeCharDevice != 0
+	case "b":
+		return info.Mode()&os.ModeDevice != 0 && info.Mode()&os.ModeCharDevice == 0
+	case "r":
+		return access(fr, []T{Empty, MkString(name)}, 4).Bool()
+	case "w":
+		return access(fr, []T{Empty, MkString(name)}, 2).Bool()
+	case "x":
+		return access(fr, []T{Empty, MkString(name)}, 1).Bool()
+	}
+	panic(Sprintf(`bad argument to "-types": %q`, t))
+}
+
+// cmdGlob implements
+//
+//	glob ?-directory dir? ?-types typeList? ?-nocomplain? ?-tails? ?-join? ?--? pattern ?pattern ...?
+//
+// Type letters d, f, l, p, s, b and c match any of those kinds;
+// r, w and x must all hold.
+func cmdGlob(fr *Frame, argv []T) T {
+	var dir string
+	var types []string
+	nocomplain, tails, join := false, false, false
+	args := argv[1:]
+	for len(args) > 0 && strings.HasPrefix(args[0].String(), "-") {
+		opt := args[0].String()
+		args = args[1:]
+		if opt == "--" {
+			break
+		}
+		need := func() string {
+			if len(args) == 0 {
+				panic(Sprintf("missing argument to %q", opt))
+			}
+			z := args[0].String()
+			args = args[1:]
+			return z
+		}
+		switch opt {
+		case "-directory":
+			dir = need()
+		case "-path":
+			dir = need()
+		case "-types":
+			for _, t := range MkString(need()).List() {
+				types = append(types, t.String())
+			}
+		case "-nocomplain":
+			nocomplain = true
+		case "-tails":
+			tails = true
+		case "-join":
+			join = true
+		default:
+			panic(Sprintf("bad option %q: should be -directory, -join, -nocomplain, -path, -tails, -types, or --", opt))
+		}
+	}
+	if len(args) == 0 {
+		panic("usage: glob ?switches? pattern ?pattern ...?")
+	}
+	if tails && dir == "" {
+		panic(`"-tails" must be used with "-directory"`)
+	}
+	patterns := args
+	if join {
+		var parts []string
+		for _, a := range args {
+			parts = append(parts, a.String())
+		}
+		patterns = []T{MkString(JoinPath(parts...))}
+	}
+
+	fsys := fr.FileSystem()
+	seen := make(map[string]bool)
+	var z []string
+	for _, p := range patterns {
+		for _, pat := range expandBraces(p.String()) {
+			full := pat
+			if dir != "" {
+				full = JoinPath(dir, pat)
+			}
+			names, err := Glob(fsys, full)
+			if err != nil {
+				panic(Sprintf("glob: %s", err.Error()))
+			}
+			for _, name := range names {
+				if len(types) > 0 && !matchTypes(fr, fsys, name, types) {
+					continue
+				}
+				if tails {
+					name = strings.TrimPrefix(strings.TrimPrefix(name, dir), "/")
+				}
+				if !seen[name] {
+					seen[name] = true
+					z = append(z, name)
+				}
+			}
+		}
+	}
+	if len(z) == 0 && !nocomplain {
+		var pp []string
+		for _, p := range patterns {
+			pp = append(pp, p.String())
+		}
+		panic(Sprintf("no files matched glob pattern %q", strings.Join(pp, " ")))
+	}
+	sort.Strings(z)
+	return MkStringList(z)
+}
+
+func matchTypes(fr *Frame, fsys FileSystem, name string, types []string) bool {
+	info, err := lstat(fsys, name)
+	if err != nil {
+		return false
+	}
+	kinds, anyKind := false, false
+	for _, t := range types {
+		switch t {
+		case "r", "w", "x":
+			if !globType(fr, name, info, t) {
+				return false
+			}
+		default:
+			kinds = true
+			anyKind = anyKind || globType(fr, name, info, t)
+		}
+	}
+	return !kinds || anyKind
+}
+
+// cmdCd changes the working directory of the process, to HOME by default.
+func cmdCd(fr *Frame, argv []T) T {
+	var dir string
+	switch len(argv) {
+	case 1:
+		dir = os.Getenv("HOME")
+	case 2:
+		dir = argv[1].String()
+	default:
+		panic("usage: cd ?dirName?")
+	}
+	if o, ok := fr.FileSystem().(OSFS); !ok || o.Root != "" {
+		panic("cd: not supported in this file system")
+	}
+	if err := os.Chdir(filepath.FromSlash(dir)); err != nil {
+		fileError("changing working directory to", dir, err)
+	}
+	return Empty
+}
+
+// workingDir returns the directory that relative names are in.  It is
+// "/" unless the file system is the whole OSFS, since other file systems
+// (and an OSFS with a Root) resolve relative names from their root, whose
+// real name is not shown.
+func workingDir(fr *Frame) string {
+	if o, ok := fr.FileSystem().(OSFS); !ok || o.Root != "" {
+		return "/"
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		panic(Sprintf("error getting working directory: %s", err.Error()))
+	}
+	return filepath.ToSlash(wd)
+}
+
+func cmdPwd(fr *Frame, argv []T) T {
+	Arg0(argv)
+	return MkString(workingDir(fr))
+}
+
+func init() {
+	if Unsafes == nil {
+		Unsafes = make(map[string]Command, 333)
+	}
+
+	Unsafes["file"] = MkEnsemble(fileEnsemble)
+	Unsafes["glob"] = cmdGlob
+	Unsafes["cd"] = cmdCd
+	Unsafes["pwd"] = cmdPwd
+
+	RequireCaps("file", "file")
+	RequireCaps("glob", "file")
+	RequireCaps("cd", "file")
+	RequireCaps("pwd", "file")
+}
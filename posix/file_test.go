@@ -0,0 +1,163 @@
+package posix
+
+import (
+	. "github.com/strickyak/tcl67/tcl"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var fileTests = `
+  must a/b/c [file join a b c]
+  must /b/c [file join a /b c]
+  must a/b [file join a/ b/]
+  must {/ usr lib} [file split /usr/lib]
+  must {a b} [file split a//b/]
+  must /usr [file dirname /usr/lib]
+  must . [file dirname lib]
+  must / [file dirname /]
+  must lib.so [file tail /usr/lib.so]
+  must .so [file extension /usr/lib.so]
+  must {} [file extension /usr.d/lib]
+  must /usr/lib [file rootname /usr/lib.so]
+  must /a/c [file normalize /a/b/../c/.]
+  must absolute [file pathtype /a]
+  must relative [file pathtype a]
+
+  set dir "[file tempdir]/tmp.file_test.go.dir"
+  file delete -force $dir
+  must 0 [file exists $dir]
+  file mkdir $dir/sub/deeper
+  must 1 [file isdirectory $dir/sub/deeper]
+  must directory [file type $dir/sub]
+
+  set f [open $dir/one.txt w]
+  puts -nonewline $f hello
+  close $f
+  must 1 [file isfile $dir/one.txt]
+  must 0 [file isfile $dir/sub]
+  must 5 [file size $dir/one.txt]
+  must 1 [file readable $dir/one.txt]
+  must 1 [file writable $dir/one.txt]
+  must 0 [file executable $dir/one.txt]
+  must 1 [file executable $dir/sub]
+
+  file mtime $dir/one.txt 1000000000
+  must 1000000000 [file mtime $dir/one.txt]
+  file stat $dir/one.txt st
+  must 5 $st(size)
+  must file $st(type)
+  must 1000000000 $st(mtime)
+
+  file copy $dir/one.txt $dir/two.txt
+  must 5 [file size $dir/two.txt]
+  mustfail {file copy $dir/one.txt $dir/two.txt}
+  file copy -force $dir/one.txt $dir/two.txt
+  file rename $dir/two.txt $dir/sub
+  must 1 [file isfile $dir/sub/two.txt]
+  file copy $dir/sub $dir/sub2
+  must 1 [file isfile $dir/sub2/two.txt]
+  must 1 [file isdirectory $dir/sub2/deeper]
+  mustfail {file copy -force $dir/one.txt $dir/one.txt}
+  mustfail {file copy -force $dir/one.txt $dir/sub/../one.txt}
+  must 5 [file size $dir/one.txt]
+  mustfail {file copy $dir/sub2 $dir/sub2/deeper}
+  mustfail {file rename -force $dir/sub2 $dir/sub2/deeper}
+  must 1 [file isdirectory $dir/sub2/deeper]
+
+  file link -symbolic $dir/ln $dir/one.txt
+  must link [file type $dir/ln]
+  must $dir/one.txt [file readlink $dir/ln]
+  must file [file type [file link $dir/ln]]
+  file lstat $dir/ln st
+  must link $st(type)
+
+  set g [file tempfile name $dir/tmp]
+  puts $g temporary
+  close $g
+  must 1 [string match $dir/tmp_* $name]
+  must 10 [file size $name]
+  file delete $name
+
+  mustfail {file delete $dir/sub}
+  file delete $dir/sub2/two.txt $dir/no.such.file
+  must 0 [file exists $dir/sub2/two.txt]
+  file delete -force $dir
+  must 0 [file exists $dir]
+
+  mustfail {withcred {} {file exists /}}
+`
+
+func TestFile(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(fileTests))
+}
+
+var globTests = `
+  set dir "[file tempdir]/tmp.file_test.go.glob"
+  file delete -force $dir
+  file mkdir $dir/d
+  close [open $dir/a.txt w]
+  close [open $dir/b.txt w]
+  close [open $dir/c.go w]
+  close [open $dir/.hidden w]
+
+  must "$dir/a.txt $dir/b.txt" [glob $dir/*.txt]
+  must {a.txt b.txt c.go d} [glob -directory $dir -tails *]
+  must {a.txt c.go} [glob -directory $dir -tails "{a*,*.go}"]
+  must {d} [glob -directory $dir -tails -types d *]
+  must {a.txt b.txt c.go} [glob -directory $dir -tails -types f *]
+  must {.hidden} [glob -directory $dir -tails .h*]
+  must {} [glob -nocomplain $dir/*.none]
+  mustfail {glob $dir/*.none}
+  set e [catch {glob $dir/*.none} msg]
+  must 1 [string match "no files matched glob pattern*" $msg]
+  file delete -force $dir
+
+  set here [pwd]
+  cd [file tempdir]
+  must [file normalize [file tempdir]] [pwd]
+  cd $here
+  must $here [pwd]
+`
+
+func TestGlob(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(globTests))
+}
+
+var rootedTests = `
+  must / [pwd]
+  must /a/c [file normalize a/b/../c]
+  mustfail {cd /}
+
+  set f [open /one.txt w]
+  puts -nonewline $f hello
+  close $f
+  file mkdir /d
+  file link -symbolic /d/ln ../one.txt
+  must 5 [file size /d/ln]
+  mustfail {file link -symbolic /d/up ../../one.txt}
+  mustfail {file link -symbolic /d/abs /one.txt}
+
+  # Links made outside stay within the root.
+  must 5 [file size /escape/one.txt]
+  must 5 [file size /dotdot/one.txt]
+  must 0 [file exists /loop]
+`
+
+func TestRooted(a *testing.T) {
+	root := a.TempDir()
+	for name, target := range map[string]string{
+		"escape": "/",
+		"dotdot": "../../../..",
+		"loop":   "loop",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			a.Fatal(err)
+		}
+	}
+	fr := NewInterpreter()
+	fr.G.FS = OSFS{Root: root}
+	fr.Eval(MkString(rootedTests))
+}
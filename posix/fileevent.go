@@ -0,0 +1,128 @@
+package posix
+
+import (
+	. "fmt"
+
+	. "github.com/strickyak/tcl67/tcl"
+)
+
+// File events.
+//
+//	fileevent channel readable|writable ?script?
+//	chan event channel readable|writable ?script?
+//
+// set the script the event loop evaluates, with the caller's Cred,
+// when the channel is readable (it has data, or is at end of file) or
+// writable; an empty script removes it.  Without a script, they return
+// the current one.  As in Tcl, the script is called again while the
+// channel stays readable, so it should read the data, or close the
+// channel at end of file, and a script that fails is removed.
+//
+// A goroutine watches for each script.  For readable, it waits for
+// data in the channel's buffer, so reads share a lock with it.  Writes
+// never wait for buffer space, so a channel is writable at once, or for
+// an -async socket, when it has connected.  Reflected channels are
+// readable at once, since their reads run Tcl commands.
+
+// fileHandler is a script set by fileevent.
+type fileHandler struct {
+	script T
+	cred   Hash
+}
+
+func cmdFileEvent(fr *Frame, argv []T) T {
+	if len(argv) != 3 && len(argv) != 4 {
+		panic(Sprintf("usage: %s channel readable|writable ?script?", argv[0].String()))
+	}
+	tf := fileArg(fr, argv[1])
+	kind := argv[2].String()
+	if kind != "readable" && kind != "writable" {
+		panic(Sprintf("bad event name %q: should be readable or writable", kind))
+	}
+	if len(argv) == 3 {
+		if h := tf.handlers[kind]; h != nil {
+			return h.script
+		}
+		return Empty
+	}
+	if argv[3].IsEmpty() {
+		delete(tf.handlers, kind)
+		return Empty
+	}
+	if tf.c == nil {
+		panic(Sprintf("channel %q is closed", tf.name))
+	}
+	if tf.handlers == nil {
+		tf.handlers = make(map[string]*fileHandler)
+	}
+	tf.handlers[kind] = &fileHandler{script: argv[3], cred: fr.Cred}
+	if !tf.watching[kind] {
+		tf.watch(fr.G.Events, kind)
+	}
+	return Empty
+}
+
+// watch starts a goroutine that posts an event to run the handler,
+// when the channel is ready.
+func (tf *terpFile) watch(el *EventLoop, kind string) {
+	if tf.watching == nil {
+		tf.watching = make(map[string]bool)
+	}
+	tf.watching[kind] = true
+	var ready func() // Waits until the channel is ready, if it may not be.
+	_, reflected := tf.c.(*reflectedChannel)
+	ac, async := tf.c.(*asyncConn)
+	switch {
+	case kind == "readable" && !reflected:
+		r := tf.reader()
+		ready = func() {
+			tf.rmu.Lock()
+			defer tf.rmu.Unlock()
+			r.Peek(1) // Any error is for the script to read.
+		}
+	case kind == "writable" && async:
+		ready = func() { ac.wait() }
+	}
+
+	el.Hold()
+	go func() {
+		defer el.Release()
+		if ready != nil {
+			ready()
+		}
+		el.Post(func(fr *Frame) {
+			tf.fire(fr, kind)
+		})
+	}()
+}
+
+// fire runs the handler, if it is still set, and watches again.
+func (tf *terpFile) fire(fr *Frame, kind string) {
+	tf.watching[kind] = false
+	h := tf.handlers[kind]
+	if h == nil || tf.c == nil {
+		return
+	}
+	ok := false
+	defer func() {
+		if !ok && tf.handlers[kind] == h {
+			delete(tf.handlers, kind)
+		}
+	}()
+	fr.WithCred(h.cred, func() T {
+		return fr.Eval(h.script)
+	})
+	ok = true
+	if tf.c != nil && tf.handlers[kind] != nil && !tf.watching[kind] {
+		tf.watch(fr.G.Events, kind)
+	}
+}
+
+func init() {
+	if Unsafes == nil {
+		Unsafes = make(map[string]Command, 333)
+	}
+
+	Unsafes["fileevent"] = cmdFileEvent
+	ChannelEvent = cmdFileEvent
+}
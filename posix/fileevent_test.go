@@ -0,0 +1,70 @@
+package posix
+
+import (
+	. "github.com/strickyak/tcl67/tcl"
+	"testing"
+)
+
+var fileEventTests = `
+  proc Accept {ch host port} {
+    fileevent $ch readable [list Echo $ch]
+  }
+  proc Echo {ch} {
+    if {[gets $ch line] < 0} {
+      close $ch
+      set Done 1
+      return
+    }
+    lappend Lines $line
+  }
+  set Lines {}
+  set srv [socket -server Accept -myaddr 127.0.0.1 0]
+  set port [lindex [fconfigure $srv -sockname] 2]
+
+  set c [socket -async 127.0.0.1 $port]
+  must {} [fileevent $c writable]
+  fileevent $c writable {set Writable 1}
+  must {set Writable 1} [fileevent $c writable]
+  vwait Writable
+  fileevent $c writable {}
+  must {} [fileevent $c writable]
+
+  puts $c one
+  puts $c two
+  close $c
+  vwait Done
+  must {one two} $Lines
+  close $srv
+
+  set path "[file tempdir]/tmp.fileevent_test.go"
+  set f [open $path w]
+  puts $f data
+  close $f
+
+  set f [open $path]
+  chan event $f readable {set R [catch {exec true} m]}
+  vwait R
+  must 0 $R
+  chan event $f readable {}
+  withcred {} { chan event $f readable {set R [catch {exec true} m]} }
+  vwait R
+  must 1 $R
+  fileevent $f readable {}
+
+  proc bgerror {msg} { set BgError $msg }
+  fileevent $f readable {error oops}
+  vwait BgError
+  must 1 [string match oops* $BgError]
+  must {} [fileevent $f readable]
+  close $f
+  file delete $path
+
+  mustfail {fileevent $f readable {}}
+  mustfail {fileevent stdin bogus {}}
+  mustfail {fileevent stdin}
+`
+
+func TestFileEvent(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(fileEventTests))
+}
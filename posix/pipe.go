@@ -0,0 +1,197 @@
+package posix
+
+import (
+	"errors"
+	. "fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	. "github.com/strickyak/tcl67/tcl"
+)
+
+// Command pipelines.  open "|command arg ..." access runs a pipeline,
+// written as for exec, as a channel: reading from the channel reads the
+// stdout of the last program, and writing to it writes the stdin of the
+// first.  Unless redirected, other input and output go to the
+// application's stdin, stdout and stderr.  Closing the channel waits for
+// the programs, and raises an error if any failed, as exec does.
+
+type pipeChannel struct {
+	fr      *Frame
+	cmds    []*exec.Cmd
+	in      *os.File // To the first program, or nil.
+	out     *os.File // From the last program, or nil.
+	closers []io.Closer
+}
+
+func (pc *pipeChannel) Read(p []byte) (int, error) {
+	if pc.out == nil {
+		return 0, errors.New("channel was not opened for reading")
+	}
+	return pc.out.Read(p)
+}
+
+func (pc *pipeChannel) Write(p []byte) (int, error) {
+	if pc.in == nil {
+		return 0, errors.New("channel was not opened for writing")
+	}
+	return pc.in.Write(p)
+}
+
+// Close closes the stdin of the pipeline, reads what is left of its
+// output, and waits for the programs.
+func (pc *pipeChannel) Close() error {
+	if pc.in != nil {
+		pc.in.Close()
+	}
+	if pc.out != nil {
+		io.Copy(io.Discard, pc.out)
+		pc.out.Close()
+	}
+	var failures []string
+	for _, cmd := range pc.cmds {
+		cmd.Wait()
+		if !cmd.ProcessState.Success() {
+			failures = append(failures, childError(pc.fr, cmd.Process.Pid, cmd.ProcessState))
+		}
+	}
+	for _, c := range pc.closers {
+		c.Close()
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "\n"))
+	}
+	return nil
+}
+
+// Pids returns the process ids of the programs.
+func (pc *pipeChannel) Pids() []int {
+	var z []int
+	for _, cmd := range pc.cmds {
+		z = append(z, cmd.Process.Pid)
+	}
+	return z
+}
+
+// OpenPipeline starts the command pipeline for open "|command" access.
+func OpenPipeline(fr *Frame, command string, access string) T {
+	fr.CheckCaps("exec")
+	flag, binary := parseAccess(access)
+	rw := flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR)
+	readable := rw == os.O_RDONLY || rw == os.O_RDWR
+	writable := rw == os.O_WRONLY || rw == os.O_RDWR
+
+	argv := append([]T{MkString("open")}, MkString(command).List()...)
+	p := parseExec(fr, argv)
+	if p.background {
+		panic(`can't use "&" in a command pipeline`)
+	}
+	cmds, pipeEnds := p.commands(fr)
+	first, last := cmds[0], cmds[len(cmds)-1]
+	pc := &pipeChannel{fr: &fr.G.Fr, cmds: cmds, closers: p.closers}
+	var childEnds []io.Closer // Our copies of the programs' ends of pipes.
+	fail := func(msg string) {
+		for _, c := range append(append(pipeEnds, childEnds...), p.closers...) {
+			c.Close()
+		}
+		if pc.in != nil {
+			pc.in.Close()
+		}
+		if pc.out != nil {
+			pc.out.Close()
+		}
+		panic(msg)
+	}
+
+	if writable {
+		if p.stdin != nil {
+			fail(`can't redirect input of a pipeline opened for writing`)
+		}
+		r, w, err := os.Pipe()
+		if err != nil {
+			fail(Sprintf(`couldn't create pipe: %s`, err.Error()))
+		}
+		first.Stdin, pc.in = r, w
+		childEnds = append(childEnds, r)
+	}
+
+	stdout := p.stdout
+	if readable {
+		if p.stdout != nil {
+			fail(`can't redirect output of a pipeline opened for reading`)
+		}
+		r, w, err := os.Pipe()
+		if err != nil {
+			fail(Sprintf(`couldn't create pipe: %s`, err.Error()))
+		}
+		stdout, pc.out = w, r
+		childEnds = append(childEnds, w)
+	} else if stdout == nil {
+		stdout = os.Stdout
+	}
+	last.Stdout = stdout
+
+	stderr := p.stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	if p.errToOut {
+		stderr = stdout
+	}
+	for _, cmd := range cmds {
+		if cmd.Stderr == nil {
+			cmd.Stderr = stderr
+		}
+	}
+
+	var started []*exec.Cmd
+	for i, cmd := range cmds {
+		if err := cmd.Start(); err != nil {
+			msg := startError(fr, p.stages[i][0], err)
+			for _, c := range started {
+				c.Process.Kill()
+				c.Wait()
+			}
+			fail(msg)
+		}
+		started = append(started, cmd)
+	}
+	for _, c := range append(pipeEnds, childEnds...) {
+		c.Close()
+	}
+
+	tf := newTerpFile(nextFileName(), pc)
+	if binary {
+		tf.transIn, tf.transOut, tf.encoding = "binary", "binary", "binary"
+	}
+	return tf
+}
+
+// cmdPid returns the process id of this process, or the process ids of
+// the programs of a command pipeline channel.
+func cmdPid(fr *Frame, argv []T) T {
+	switch len(argv) {
+	case 1:
+		return MkInt(int64(os.Getpid()))
+	case 2:
+		tf := fileArg(fr, argv[1])
+		var zz []T
+		if pc, ok := tf.c.(*pipeChannel); ok {
+			for _, pid := range pc.Pids() {
+				zz = append(zz, MkInt(int64(pid)))
+			}
+		}
+		return MkList(zz)
+	}
+	panic("usage: pid ?channel?")
+}
+
+func init() {
+	if Unsafes == nil {
+		Unsafes = make(map[string]Command, 333)
+	}
+
+	Unsafes["pid"] = cmdPid
+}
@@ -4,25 +4,82 @@ import (
 	"bufio"
 	. "fmt"
 	. "github.com/strickyak/tcl67/tcl"
-	"io"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unicode/utf8"
 )
 
+// terpFile is a Tcl channel: a named Channel with buffers and options.
 type terpFile struct {
-	f *os.File
-	r *bufio.Reader
-	w *bufio.Writer
+	name string
+	c    Channel // nil when closed.
+	r    *bufio.Reader
+	w    *bufio.Writer
+	eof  bool // The last read reached the end of file.
+
+	// Options set by fconfigure; see chanio.go.
+	buffering string // "full", "line", or "none".
+	transIn   string // "auto", "lf", "crlf", "cr", or "binary".
+	transOut  string
+	encoding  string // An encoding name, or "binary".
+	blocking  bool
+
+	// File events; see fileevent.go.
+	rmu      sync.Mutex              // Held while reading r, which a watcher may do.
+	handlers map[string]*fileHandler // By "readable" or "writable".
+	watching map[string]bool         // Whether a watcher or its event is pending.
+}
+
+var fileCounter int64
+
+func MkFile(f File) *terpFile {
+	return newTerpFile(nextFileName(), f)
 }
 
-func MkFile(f *os.File) *terpFile {
-	return &terpFile{f: f}
+func nextFileName() string {
+	n := atomic.AddInt64(&fileCounter, 1)
+	return Sprintf("file%d", n)
+}
+
+func newTerpFile(name string, c Channel) *terpFile {
+	return &terpFile{
+		name:      name,
+		c:         c,
+		buffering: "full",
+		transIn:   "auto",
+		transOut:  "lf",
+		encoding:  SystemEncoding,
+		blocking:  true,
+	}
+}
+
+// newStdChannel makes the standard channels of an interpreter.
+func newStdChannel(name string) T {
+	var tf *terpFile
+	switch name {
+	case "stdin":
+		tf = newTerpFile(name, os.Stdin)
+		tf.buffering = "line"
+	case "stdout":
+		tf = newTerpFile(name, os.Stdout)
+		tf.buffering = "line"
+	case "stderr":
+		tf = newTerpFile(name, os.Stderr)
+		tf.buffering = "none"
+	default:
+		return nil
+	}
+	return tf
 }
 
 // *terpFile implements T
 
 func (t *terpFile) String() string {
-	return Sprintf("file%d", t.f.Fd())
+	return t.name
 }
 func (t *terpFile) Float() float64 {
 	panic("not implemented on terpFile (Float)")
@@ -71,9 +128,9 @@ func (t *terpFile) GetAt(key T) T {
 func (t *terpFile) PutAt(value T, key T) {
 	panic("a terpFile cannot PutAt")
 }
-func (t terpFile) EvalSeq(fr *Frame) T         { return Parse2EvalSeqStr(fr, t.String()) }
-func (t terpFile) EvalExpr(fr *Frame) T        { return Parse2EvalExprStr(fr, t.String()) }
-func (t terpFile) Apply(fr *Frame, args []T) T { panic("Cannot apply terpFile as command") }
+func (t *terpFile) EvalSeq(fr *Frame) T         { return Parse2EvalSeqStr(fr, t.String()) }
+func (t *terpFile) EvalExpr(fr *Frame) T        { return Parse2EvalExprStr(fr, t.String()) }
+func (t *terpFile) Apply(fr *Frame, args []T) T { panic("Cannot apply terpFile as command") }
 
 func cmdOpen(fr *Frame, argv []T) T {
 	nameT, args := Arg1v(argv)
@@ -84,68 +141,169 @@ func cmdOpen(fr *Frame, argv []T) T {
 	if len(args) > 0 {
 		access = args[0].String()
 	}
+	perm := os.FileMode(0666)
+	switch len(args) {
+	case 0, 1:
+	case 2:
+		p, err := strconv.ParseUint(args[1].String(), 0, 32)
+		if err != nil {
+			panic(Sprintf(`Bad permissions in "open" command: %q`, args[1].String()))
+		}
+		perm = os.FileMode(p)
+	default:
+		panic("usage: open fileName ?access? ?permissions?")
+	}
 
-	return Open(name, access)
+	var z T
+	if strings.HasPrefix(name, "|") {
+		z = OpenPipeline(fr, name[1:], access)
+	} else {
+		z = OpenPerm(fr.FileSystem(), name, access, perm)
+	}
+	fr.RegisterChannel(z)
+	return z
 }
 
+// fileArg returns the file named by a channel name, which must be
+// registered in this interpreter.
+func fileArg(fr *Frame, t T) *terpFile {
+	tf, ok := fr.LookupChannel(t).(*terpFile)
+	if !ok {
+		panic(Sprintf("channel %q is not a file", t.String()))
+	}
+	return tf
+}
+
+// Open opens a file in the real filesystem.
 func Open(name string, access string) T {
-	var f *os.File
-	var err error
-	switch access {
+	return OpenIn(OSFS{}, name, access)
+}
+
+// OpenIn opens a file in the FileSystem.
+func OpenIn(fsys FileSystem, name string, access string) T {
+	return OpenPerm(fsys, name, access, 0666)
+}
+
+// accessFlags are the flags of a Tcl access list, like {WRONLY CREAT}.
+var accessFlags = map[string]int{
+	"RDONLY":   os.O_RDONLY,
+	"WRONLY":   os.O_WRONLY,
+	"RDWR":     os.O_RDWR,
+	"APPEND":   os.O_APPEND,
+	"CREAT":    os.O_CREATE,
+	"EXCL":     os.O_EXCL,
+	"NOCTTY":   syscall.O_NOCTTY,
+	"NONBLOCK": syscall.O_NONBLOCK,
+	"TRUNC":    os.O_TRUNC,
+	"BINARY":   0,
+}
+
+// parseAccess returns the open flags for an access mode, like "r+" or "wb",
+// or an access list, like {RDWR CREAT}, and whether it asks for binary.
+func parseAccess(access string) (flag int, binary bool) {
+	mode := access
+	if len(mode) > 1 && strings.Contains(mode[1:], "b") {
+		binary = true
+		mode = mode[:1] + strings.Replace(mode[1:], "b", "", 1)
+	}
+	switch mode {
 	case "r":
-		f, err = os.OpenFile(name, os.O_RDONLY, 0666)
+		return os.O_RDONLY, binary
 	case "r+":
-		f, err = os.OpenFile(name, os.O_RDWR, 0666)
+		return os.O_RDWR, binary
 	case "w":
-		f, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+		return os.O_WRONLY | os.O_CREATE | os.O_TRUNC, binary
 	case "w+":
-		f, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+		return os.O_RDWR | os.O_CREATE | os.O_TRUNC, binary
 	case "a":
-		f, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		return os.O_WRONLY | os.O_CREATE | os.O_APPEND, binary
 	case "a+":
-		f, err = os.OpenFile(name, os.O_RDWR|os.O_APPEND, 0666)
-	default:
+		return os.O_RDWR | os.O_CREATE | os.O_APPEND, binary
+	}
+
+	words := strings.Fields(access)
+	if len(words) == 0 || words[0] != strings.ToUpper(words[0]) {
 		panic(Sprintf(`Unknown access mode in "open" command: %q`, access))
 	}
+	binary = false
+	for _, w := range words {
+		f, ok := accessFlags[w]
+		if !ok {
+			panic(Sprintf(`Unknown access flag in "open" command: %q`, w))
+		}
+		flag |= f
+		binary = binary || w == "BINARY"
+	}
+	return flag, binary
+}
+
+// OpenPerm opens a file in the FileSystem, creating it with perm if needed.
+func OpenPerm(fsys FileSystem, name string, access string, perm os.FileMode) T {
+	flag, binary := parseAccess(access)
+	f, err := fsys.OpenFile(name, flag, perm)
 
 	if err != nil {
 		panic(Sprintf(`Cannot "open" file %q because %q`, name, err.Error()))
 	}
 
-	return &terpFile{f: f}
+	tf := MkFile(f)
+	if binary {
+		tf.transIn, tf.transOut, tf.encoding = "binary", "binary", "binary"
+	}
+	return tf
 }
 
 func cmdFlush(fr *Frame, argv []T) T {
 	fileT := Arg1(argv)
-	tf := fileT.(*terpFile)
+	tf := fileArg(fr, fileT)
 	Flush(tf)
 	return Empty
 }
 
 func Flush(tf *terpFile) {
 	if tf.w != nil {
-		tf.w.Flush()
+		if err := tf.w.Flush(); err != nil {
+			panic(Sprintf(`Error during "flush": %s`, err.Error()))
+		}
+	}
+}
+
+// FlushChannels flushes every file channel of the interpreter,
+// as on exit.
+func FlushChannels(fr *Frame) {
+	for _, ch := range fr.G.Channels {
+		if tf, ok := ch.(*terpFile); ok && tf.w != nil {
+			tf.w.Flush()
+		}
 	}
 }
 
 func cmdClose(fr *Frame, argv []T) T {
 	fileT := Arg1(argv)
-	tf := fileT.(*terpFile)
+	tf := fileArg(fr, fileT)
+	fr.UnregisterChannel(tf)
 	Close(tf)
 	return Empty
 }
 
 func Close(tf *terpFile) {
+	var err error
 	if tf.w != nil {
-		tf.w.Flush()
+		err = tf.w.Flush()
 	}
-	if tf.f != nil {
-		tf.f.Close()
+	if tf.c != nil {
+		if err2 := tf.c.Close(); err == nil {
+			err = err2
+		}
 	}
 
-	tf.f = nil
+	tf.c = nil
 	tf.r = nil
 	tf.w = nil
+	tf.handlers = nil
+	if err != nil {
+		panic(Sprintf(`Error during "close" of %q: %s`, tf.name, err.Error()))
+	}
 }
 
 func cmdGets(fr *Frame, argv []T) T {
@@ -157,26 +315,17 @@ func cmdGets(fr *Frame, argv []T) T {
 	if len(args) > 0 {
 		varName = args[0].String()
 	}
-	f := fileT.(*terpFile)
-
-	if f.r == nil {
-		f.r = bufio.NewReader(f.f)
-	}
+	f := fileArg(fr, fileT)
 
-	data, err := f.r.ReadString('\n')
-	if err != nil && err != io.EOF {
-		panic(Sprintf(`Error duing "gets": %s`, err.Error()))
-	}
-	if len(data) > 0 {
-		if data[len(data)-1] == '\n' {
-			data = data[:len(data)-1]
-		}
-	}
+	data, ok := f.readLine()
 	dataT := MkString(data)
 
 	if len(varName) > 0 {
 		fr.SetVar(varName, dataT)
-		return MkInt(int64(len(data)))
+		if !ok {
+			return MkInt(-1)
+		}
+		return MkInt(int64(utf8.RuneCountInString(data)))
 	}
 	// else:
 	return dataT
@@ -196,13 +345,10 @@ func cmdPuts(fr *Frame, argv []T) T {
 	var data string
 	switch len(argv) {
 	case i + 1:
+		t = fileArg(fr, MkString("stdout"))
 		data = argv[i].String()
 	case i + 2:
-		var ok bool
-		t, ok = argv[i].(*terpFile)
-		if !ok {
-			panic(Sprintf(`Bad args to "puts". Expected file as arg %d.`, i))
-		}
+		t = fileArg(fr, argv[i])
 		data = argv[i+1].String()
 	default:
 		panic(`Bad args to "puts"`)
@@ -212,51 +358,23 @@ func cmdPuts(fr *Frame, argv []T) T {
 	return Empty
 }
 
+// Puts writes the data and a newline to the file, or to os.Stdout if t is nil.
 func Puts(noNewLine bool, t *terpFile, data string) {
-	var err error
+	if !noNewLine {
+		data += "\n"
+	}
 	if t == nil {
-		if noNewLine {
-			_, err = Print(data)
-		} else {
-			_, err = Println(data)
-		}
-	} else {
-		if t.w == nil {
-			t.w = bufio.NewWriter(t.f)
-		}
-		if noNewLine {
-			_, err = Fprint(t.w, data)
-		} else {
-			_, err = Fprintln(t.w, data)
+		if _, err := Print(data); err != nil {
+			panic(Sprintf(`Error during "puts": %s`, err.Error()))
 		}
+		return
 	}
-	if err != nil {
-		panic(Sprintf(`Error during "puts": %s`, err.Error()))
-	}
-}
-
-var fileEnsemble = []EnsembleItem{
-	EnsembleItem{Name: "separator", Cmd: cmdFileSeparator},
-	EnsembleItem{Name: "tempdir", Cmd: cmdFileTempdir},
-	EnsembleItem{Name: "join", Cmd: cmdFileJoin},
-}
-
-func cmdFileSeparator(fr *Frame, argv []T) T {
-	Arg0(argv)
-	return MkString(string(os.PathSeparator))
-}
-
-func cmdFileTempdir(fr *Frame, argv []T) T {
-	Arg0(argv)
-	return MkString(os.TempDir())
-}
-
-func cmdFileJoin(fr *Frame, argv []T) T {
-	panic("TODO")
+	t.write(data)
 }
 
 func cmdExit(fr *Frame, argv []T) T {
 	statusT := Arg1(argv)
+	FlushChannels(fr)
 	os.Exit(int(statusT.Int()))
 	return Empty
 }
@@ -268,9 +386,13 @@ func init() {
 
 	Unsafes["open"] = cmdOpen
 	Unsafes["close"] = cmdClose
-	Unsafes["file"] = MkEnsemble(fileEnsemble)
 	Unsafes["gets"] = cmdGets
 	Unsafes["puts"] = cmdPuts
 	Unsafes["flush"] = cmdFlush
 	Unsafes["exit"] = cmdExit
+
+	NewStdChannel = newStdChannel
+
+	RequireCaps("open", "file")
+	RequireCaps("exit", "exit")
 }
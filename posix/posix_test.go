@@ -27,9 +27,137 @@ var tests = `
   must Two [gets $f x ; set x]
   must ThreeAndAHalf [gets $f]
   close $f
+
+  mustfail {withcred {} {open $path r}}
+  mustfail {withcred {} {exec true}}
+  close [withcred {file} {open $path r}]
 `
 
 func TestHt(a *testing.T) {
 	fr := NewInterpreter()
 	fr.Eval(MkString(tests))
 }
+
+var shareTests = `
+  set path "[file tempdir][file separator]tmp.posix_test.go.share"
+  set f [open $path w]
+  interp create kid
+  mustfail {interp eval kid "puts $f hello"}
+  interp share {} $f kid
+  interp eval kid "puts $f hello"
+  interp transfer kid $f {}
+  interp transfer {} $f kid
+  mustfail {puts $f more}
+  interp eval kid "close $f"
+`
+
+func TestShare(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(shareTests))
+}
+
+var chanIOTests = `
+  set path "[file tempdir][file separator]tmp.posix_test.go.chanio"
+  set f [open $path {WRONLY CREAT TRUNC} 0600]
+  puts $f "line one"
+  puts -nonewline $f "line two"
+  must 17 [tell $f]
+  close $f
+
+  set f [open $path]
+  must 8 [gets $f x]
+  must "line one" $x
+  must 0 [eof $f]
+  must 9 [tell $f]
+  must "line" [read $f 4]
+  must " two" [read $f]
+  must 1 [eof $f]
+  must -1 [gets $f x]
+  must "" $x
+  seek $f 5
+  must 0 [eof $f]
+  must "one" [read $f 3]
+  seek $f -3 end
+  must "two" [read $f]
+  seek $f 0
+  seek $f 5 current
+  must "one\nline two" [read $f]
+  mustfail {seek $f 0 middle}
+  close $f
+
+  set f [open $path w+]
+  fconfigure $f -translation crlf
+  must crlf [fconfigure $f -translation]
+  puts $f a
+  puts $f b
+  seek $f 0
+  fconfigure $f -translation binary
+  must "a\r\nb\r\n" [read $f]
+  seek $f 0
+  fconfigure $f -translation auto
+  must "a\nb" [read -nonewline $f]
+  close $f
+
+  set f [open $path wb]
+  must binary [fconfigure $f -encoding]
+  fconfigure $f -encoding iso8859-1 -translation lf -buffering none
+  must {-blocking 1 -buffering none -encoding iso8859-1 -translation lf} [fconfigure $f]
+  puts -nonewline $f "\xE9t\xE9"
+  close $f
+  set f [open $path rb]
+  must [encoding convertto iso8859-1 "\xE9t\xE9"] [read $f]
+  seek $f 0
+  fconfigure $f -encoding iso8859-1
+  must "\xE9t\xE9" [read $f]
+  close $f
+  mustfail {fconfigure stdout -bogus}
+  mustfail {fconfigure stdout -buffering sometimes}
+  mustfail {fconfigure stdout -encoding klingon}
+  must line [fconfigure stdout -buffering]
+
+  set copy "$path.copy"
+  set in [open $path r]
+  set out [open $copy w]
+  must 5 [fcopy $in $out]
+  close $in
+  close $out
+  set in [open $copy r]
+  must "\xE9t\xE9" [read $in]
+  close $in
+  set f [open $copy w]
+  puts -nonewline $f "\u00e9t\u00e9"
+  close $f
+  set in [open $copy r]
+  set out [open $path w]
+  must 3 [fcopy $in $out -size 3]
+  must 2 [fcopy $in $out]
+  close $in
+  close $out
+  set in [open $path r]
+  must "\u00e9t\u00e9" [read $in]
+  close $in
+
+  set in [open $path r]
+  set out [open $copy w]
+  fcopy $in $out -size 1 -command {set Copied}
+  vwait Copied
+  must 2 $Copied
+  proc copied {n} { set Copied [catch {exec true} m] }
+  withcred {} { fcopy $in $out -size 1 -command copied }
+  vwait Copied
+  must 1 $Copied
+  close $in
+  close $out
+
+  mustfail {open $path {WRONLY BOGUS}}
+  mustfail {open $path q}
+  mustfail {open $path r rw-}
+  mustfail {open $path {WRONLY CREAT EXCL}}
+  puts -nonewline stderr ""
+  flush stdout
+`
+
+func TestChanIO(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(chanIOTests))
+}
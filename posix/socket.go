@@ -0,0 +1,252 @@
+package posix
+
+import (
+	"errors"
+	. "fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	. "github.com/strickyak/tcl67/tcl"
+)
+
+// TCP sockets.
+//
+//	socket ?-async? ?-myaddr addr? ?-myport port? host port
+//
+// connects to the host, returning a channel for gets, puts, read and
+// the rest.  With -async, it returns at once, and reads and writes wait
+// for the connection.
+//
+//	socket -server callback ?-myaddr addr? port
+//
+// listens on the port (0 picks a free one), returning a server channel.
+// For each connection, the event loop calls the callback, with the Cred
+// of the caller, passing the new channel, and the address and port of
+// the client.  Closing the server
+// channel stops listening.  fconfigure -sockname and -peername return
+// {address host port}.
+
+var sockCounter int64
+
+func nextSockName() string {
+	return Sprintf("sock%d", atomic.AddInt64(&sockCounter, 1))
+}
+
+// asyncConn is a connection made by socket -async, which may not be
+// connected yet.
+type asyncConn struct {
+	ready chan struct{} // Closed when conn or err is set.
+	conn  net.Conn
+	err   error
+}
+
+func (ac *asyncConn) wait() (net.Conn, error) {
+	<-ac.ready
+	return ac.conn, ac.err
+}
+
+func (ac *asyncConn) Read(p []byte) (int, error) {
+	conn, err := ac.wait()
+	if err != nil {
+		return 0, err
+	}
+	return conn.Read(p)
+}
+
+func (ac *asyncConn) Write(p []byte) (int, error) {
+	conn, err := ac.wait()
+	if err != nil {
+		return 0, err
+	}
+	return conn.Write(p)
+}
+
+func (ac *asyncConn) Close() error {
+	conn, _ := ac.wait()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+// serverChannel is the channel of socket -server.  It cannot be read
+// or written; closing it closes the listener.
+type serverChannel struct {
+	ln net.Listener
+}
+
+func (sc *serverChannel) Read([]byte) (int, error) {
+	return 0, errors.New("cannot read from a server socket")
+}
+
+func (sc *serverChannel) Write([]byte) (int, error) {
+	return 0, errors.New("cannot write to a server socket")
+}
+
+func (sc *serverChannel) Close() error {
+	return sc.ln.Close()
+}
+
+// addrList makes the {address host port} list of fconfigure -sockname.
+func addrList(addr net.Addr) T {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return MkString(addr.String())
+	}
+	return MkStringList([]string{host, host, port})
+}
+
+// socketAddr returns the local or remote address of a socket channel,
+// and false if the channel is not a socket.
+func (tf *terpFile) socketAddr(remote bool) (net.Addr, bool) {
+	switch c := tf.c.(type) {
+	case net.Conn:
+		if remote {
+			return c.RemoteAddr(), true
+		}
+		return c.LocalAddr(), true
+	case *asyncConn:
+		conn, err := c.wait()
+		if err != nil {
+			panic(Sprintf("can't get address of %q: %s", tf.name, err.Error()))
+		}
+		if remote {
+			return conn.RemoteAddr(), true
+		}
+		return conn.LocalAddr(), true
+	case *serverChannel:
+		if remote {
+			panic(Sprintf("can't get -peername of server socket %q", tf.name))
+		}
+		return c.ln.Addr(), true
+	}
+	return nil, false
+}
+
+// isSocket tells if the channel has the -sockname and -peername options.
+func (tf *terpFile) isSocket() bool {
+	switch tf.c.(type) {
+	case net.Conn, *asyncConn:
+		return true
+	}
+	return false
+}
+
+func hostPort(host string, port T) string {
+	p, err := strconv.ParseUint(port.String(), 10, 16)
+	if err != nil {
+		panic(Sprintf("expected port number but got %q", port.String()))
+	}
+	return net.JoinHostPort(host, strconv.FormatUint(p, 10))
+}
+
+func cmdSocket(fr *Frame, argv []T) T {
+	var server T
+	async := false
+	myaddr, myport := "", ""
+	args := argv[1:]
+	for len(args) > 0 && strings.HasPrefix(args[0].String(), "-") {
+		opt := args[0].String()
+		args = args[1:]
+		if opt == "-async" {
+			async = true
+			continue
+		}
+		if len(args) == 0 {
+			panic(Sprintf("no argument given for %q option", opt))
+		}
+		switch opt {
+		case "-server":
+			server = args[0]
+		case "-myaddr":
+			myaddr = args[0].String()
+		case "-myport":
+			myport = args[0].String()
+		default:
+			panic(Sprintf("bad option %q: should be -async, -myaddr, -myport, or -server", opt))
+		}
+		args = args[1:]
+	}
+
+	if server != nil {
+		if len(args) != 1 || async || myport != "" {
+			panic("usage: socket -server callback ?-myaddr addr? port")
+		}
+		return listen(fr, server, hostPort(myaddr, args[0]))
+	}
+
+	if len(args) != 2 {
+		panic("usage: socket ?-async? ?-myaddr addr? ?-myport port? host port")
+	}
+	dialer := &net.Dialer{}
+	if myaddr != "" || myport != "" {
+		if myport == "" {
+			myport = "0"
+		}
+		local, err := net.ResolveTCPAddr("tcp", hostPort(myaddr, MkString(myport)))
+		if err != nil {
+			panic(Sprintf("couldn't open socket: %s", err.Error()))
+		}
+		dialer.LocalAddr = local
+	}
+	addr := hostPort(args[0].String(), args[1])
+	var c Channel
+	if async {
+		ac := &asyncConn{ready: make(chan struct{})}
+		go func() {
+			ac.conn, ac.err = dialer.Dial("tcp", addr)
+			close(ac.ready)
+		}()
+		c = ac
+	} else {
+		conn, err := dialer.Dial("tcp", addr)
+		if err != nil {
+			panic(Sprintf("couldn't open socket: %s", err.Error()))
+		}
+		c = conn
+	}
+	z := newTerpFile(nextSockName(), c)
+	fr.RegisterChannel(z)
+	return z
+}
+
+// listen makes the server channel, and a goroutine that accepts
+// connections and posts the callbacks to the event loop.
+func listen(fr *Frame, callback T, addr string) T {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		panic(Sprintf("couldn't open socket: %s", err.Error()))
+	}
+	z := newTerpFile(nextSockName(), &serverChannel{ln: ln})
+	fr.RegisterChannel(z)
+
+	events, cred := fr.G.Events, fr.Cred
+	events.Hold()
+	go func() {
+		defer events.Release()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return // Closed.
+			}
+			events.PostWithCred(cred, func(fr *Frame) {
+				ch := newTerpFile(nextSockName(), conn)
+				fr.RegisterChannel(ch)
+				host, port, _ := net.SplitHostPort(conn.RemoteAddr().String())
+				EvalOrApplyLists(fr, []T{callback, MkStringList([]string{ch.String(), host, port})})
+			})
+		}
+	}()
+	return z
+}
+
+func init() {
+	if Unsafes == nil {
+		Unsafes = make(map[string]Command, 333)
+	}
+
+	Unsafes["socket"] = cmdSocket
+	RequireCaps("socket", "net")
+}
@@ -0,0 +1,60 @@
+package posix
+
+import (
+	. "github.com/strickyak/tcl67/tcl"
+	"testing"
+)
+
+var socketTests = `
+  proc Accept {ch host port} {
+    fconfigure $ch -buffering line
+    set line [gets $ch]
+    puts $ch "echo $line"
+    close $ch
+    set Served $host
+  }
+  set srv [socket -server Accept -myaddr 127.0.0.1 0]
+  set port [lindex [fconfigure $srv -sockname] 2]
+  must 1 [expr $port > 0]
+
+  set c [socket 127.0.0.1 $port]
+  must 127.0.0.1 [lindex [fconfigure $c -peername] 0]
+  must $port [lindex [fconfigure $c -peername] 2]
+  puts $c hello
+  flush $c
+  vwait Served
+  must 127.0.0.1 $Served
+  must "echo hello" [gets $c]
+  must -1 [gets $c x]
+  must 1 [eof $c]
+  close $c
+
+  set c [socket -async localhost $port]
+  puts $c again
+  flush $c
+  vwait Served
+  must "echo again" [gets $c]
+  close $c
+
+  close $srv
+  mustfail {socket 127.0.0.1 $port}
+
+  proc Limited {ch host port} {
+    close $ch
+    set Served [catch {exec true} m]
+  }
+  withcred {net} { set srv [socket -server Limited -myaddr 127.0.0.1 0] }
+  set c [socket 127.0.0.1 [lindex [fconfigure $srv -sockname] 2]]
+  vwait Served
+  must 1 $Served
+  close $c
+  close $srv
+
+  mustfail {socket 127.0.0.1 notaport}
+  mustfail {withcred {file} {socket 127.0.0.1 $port}}
+`
+
+func TestSocket(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(socketTests))
+}
@@ -0,0 +1,123 @@
+package posix
+
+import (
+	. "fmt"
+	"path"
+
+	. "github.com/strickyak/tcl67/tcl"
+)
+
+// SourceFile evaluates the file in the frame, with "info script" naming
+// the file during evaluation.  A "return" in the file ends it early.
+func SourceFile(fr *Frame, filename, encoding string) (result T) {
+	bb, err := ReadFile(fr.FileSystem(), filename)
+	if err != nil {
+		panic(Sprintf("couldn't read file %q: %v", filename, err))
+	}
+	contents := string(bb)
+	if encoding != "" {
+		contents = ConvertFrom(encoding, contents)
+	}
+
+	saved := fr.G.Script
+	fr.G.Script = filename
+	defer func() {
+		fr.G.Script = saved
+		if r := recover(); r != nil {
+			if j, ok := r.(Jump); ok && j.Status == RETURN {
+				result = j.Result
+				return
+			}
+			panic(r)
+		}
+	}()
+	return fr.Eval(MkString(contents))
+}
+
+func cmdSource(fr *Frame, argv []T) T {
+	var encoding string
+	args := argv[1:]
+	if len(args) == 3 && args[0].String() == "-encoding" {
+		encoding = args[1].String()
+		args = args[2:]
+	}
+	if len(args) != 1 {
+		panic("Usage: source ?-encoding name? fileName")
+	}
+	return SourceFile(fr, args[0].String(), encoding)
+}
+
+// AutoPath returns the directories in the global "auto_path" list.
+func AutoPath(fr *Frame) []string {
+	g := &fr.G.Fr
+	if !g.HasVar("auto_path") {
+		return nil
+	}
+	var z []string
+	for _, e := range g.GetVar("auto_path").List() {
+		z = append(z, e.String())
+	}
+	return z
+}
+
+// sourceIndex evaluates an index file in a new frame with "dir" set to
+// its directory and "auto_index" global, as pkgIndex.tcl and tclIndex
+// files expect.
+func sourceIndex(fr *Frame, filename string) {
+	if _, err := fr.FileSystem().Stat(filename); err != nil {
+		return
+	}
+	fr2 := fr.G.Fr.NewFrame()
+	fr2.DefineUpVar("auto_index", &fr.G.Fr, "auto_index")
+	fr2.SetVar("dir", MkString(path.Dir(filename)))
+	SourceFile(fr2, filename, "")
+}
+
+// cmdTclPkgUnknown is the default "package unknown" handler.  It sources
+// pkgIndex.tcl in each directory on the auto_path and their subdirectories.
+func cmdTclPkgUnknown(fr *Frame, argv []T) T {
+	Arg1v(argv) // name ?version ...?
+	for _, dir := range AutoPath(fr) {
+		sourceIndex(fr, path.Join(dir, "pkgIndex.tcl"))
+		subs, _ := Glob(fr.FileSystem(), path.Join(dir, "*", "pkgIndex.tcl"))
+		for _, sub := range subs {
+			sourceIndex(fr, sub)
+		}
+	}
+	return Empty
+}
+
+// cmdAutoLoad tries to define a command using the global "auto_index" array,
+// which is filled by sourcing the tclIndex file in each directory on the
+// auto_path.  It returns 1 if the command is then defined.
+func cmdAutoLoad(fr *Frame, argv []T) T {
+	nameT := Arg1(argv)
+	name := nameT.String()
+	g := &fr.G.Fr
+	element := Sprintf("auto_index(%s)", name)
+
+	if !g.HasVar(element) {
+		for _, dir := range AutoPath(fr) {
+			sourceIndex(fr, path.Join(dir, "tclIndex"))
+		}
+	}
+	if !g.HasVar(element) {
+		return False
+	}
+	g.Eval(g.GetVar(element))
+	return MkBool(fr.FindCommand(nameT, false) != nil)
+}
+
+func init() {
+	if Unsafes == nil {
+		Unsafes = make(map[string]Command, 333)
+	}
+
+	Unsafes["source"] = cmdSource
+	Unsafes["auto_load"] = cmdAutoLoad
+	Unsafes[DefaultPackageUnknown] = cmdTclPkgUnknown
+
+	RequireCaps("source", "file")
+	RequireCaps("auto_load", "file")
+	RequireCaps(DefaultPackageUnknown, "file")
+}
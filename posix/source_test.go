@@ -0,0 +1,99 @@
+package posix
+
+import (
+	. "github.com/strickyak/tcl67/tcl"
+	"testing"
+)
+
+var sourceTests = `
+  set sep [file separator]
+  set dir "[file tempdir]${sep}source_test.go.tmp"
+  catch {exec mkdir -p "$dir${sep}hello"}
+
+  proc writeFile {path text} {
+    set f [open $path w]
+    puts -nonewline $f $text
+    close $f
+  }
+
+  writeFile "$dir${sep}one.tcl" {
+    set Seen [info script]
+    return [expr {6 * 7}]
+    set Seen never
+  }
+  must 42 [source "$dir${sep}one.tcl"]
+  must "$dir${sep}one.tcl" $Seen
+  must "" [info script]
+  mustfail {source "$dir${sep}no-such-file.tcl"}
+
+  set f [open "$dir${sep}latin.tcl" w]
+  fconfigure $f -encoding iso8859-1
+  puts -nonewline $f "set Latin \xE9t\xE9"
+  close $f
+  source -encoding iso8859-1 "$dir${sep}latin.tcl"
+  must "été" $Latin
+
+  writeFile "$dir${sep}hello${sep}pkgIndex.tcl" {
+    package ifneeded hello 1.0 [list source "$dir/hello1.tcl"]
+    package ifneeded hello 1.2 [list source "$dir/hello12.tcl"]
+    package ifneeded hello 2.0 [list source "$dir/hello2.tcl"]
+  }
+  writeFile "$dir${sep}hello${sep}hello1.tcl" {package provide hello 1.0}
+  writeFile "$dir${sep}hello${sep}hello12.tcl" {package provide hello 1.2; proc Hello {} {return hi}}
+  writeFile "$dir${sep}hello${sep}hello2.tcl" {package provide hello 2.0}
+
+  mustfail {package require hello}
+  set auto_path [list $dir]
+  must 1.2 [package require hello 1]
+  must hi [Hello]
+  must 1.2 [package require hello]
+  must 1.2 [package present hello]
+  mustfail {package require hello 2}
+  must {1.0 1.2 2.0} [package versions hello]
+
+  writeFile "$dir${sep}tclIndex" {
+    set auto_index(lazy) [list source "$dir/lazy.tcl"]
+  }
+  writeFile "$dir${sep}lazy.tcl" {proc lazy {x} {return "lazy $x"}}
+  must "lazy 1" [lazy 1]
+  must 0 [auto_load nothing]
+  mustfail {nothing}
+`
+
+func TestSource(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(sourceTests))
+}
+
+var memFSTests = `
+  must 1 [file exists /data/in.txt]
+  must 1 [file isdirectory /data]
+  must 0 [file isfile /data]
+  must 0 [file exists /etc/passwd]
+  set f [open /data/in.txt]
+  must hello [gets $f]
+  close $f
+  set f [open /data/out.txt w]
+  puts $f written
+  close $f
+  must 1 [file isfile /data/out.txt]
+  must 42 [source /lib/answer.tcl]
+  set auto_path /lib
+  must 0.1 [package require memo]
+  must yes [memo]
+`
+
+func TestMemFS(a *testing.T) {
+	fr := NewInterpreter()
+	m := NewMemFS(map[string]string{
+		"/data/in.txt":           "hello\n",
+		"/lib/answer.tcl":        "expr 6 * 7",
+		"/lib/memo/pkgIndex.tcl": "package ifneeded memo 0.1 [list source $dir/memo.tcl]",
+		"/lib/memo/memo.tcl":     "package provide memo 0.1; proc memo {} {return yes}",
+	})
+	fr.G.FS = m
+	fr.Eval(MkString(memFSTests))
+	if bb, _ := ReadFile(m, "/data/out.txt"); string(bb) != "written\n" {
+		a.Errorf("out.txt: %q", bb)
+	}
+}
@@ -0,0 +1,579 @@
+package tcl
+
+import (
+	. "fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DefaultClockFormat is Tcl's default for "clock format".
+const DefaultClockFormat = "%a %b %d %H:%M:%S %Z %Y"
+
+var clockEnsemble = []EnsembleItem{
+	EnsembleItem{Name: "seconds", Cmd: cmdClockSeconds},
+	EnsembleItem{Name: "milliseconds", Cmd: cmdClockMilliseconds},
+	EnsembleItem{Name: "microseconds", Cmd: cmdClockMicroseconds},
+	EnsembleItem{Name: "clicks", Cmd: cmdClockClicks, Doc: "?-milliseconds|-microseconds?"},
+	EnsembleItem{Name: "format", Cmd: cmdClockFormat, Doc: "seconds ?-format tclFormat? ?-layout goLayout? ?-gmt bool? ?-timezone zone?"},
+	EnsembleItem{Name: "scan", Cmd: cmdClockScan, Doc: "string ?-format tclFormat? ?-layout goLayout? ?-base seconds? ?-gmt bool? ?-timezone zone?"},
+	EnsembleItem{Name: "add", Cmd: cmdClockAdd, Doc: "seconds ?count unit ...? ?-gmt bool? ?-timezone zone?"},
+}
+
+func cmdClockSeconds(fr *Frame, argv []T) T {
+	Arg0(argv)
+	u := time.Now().Unix()
+	return MkInt(int64(u))
+}
+
+func cmdClockMilliseconds(fr *Frame, argv []T) T {
+	Arg0(argv)
+	u := time.Now().UnixNano()
+	return MkInt(int64(u / 1000000))
+}
+
+func cmdClockMicroseconds(fr *Frame, argv []T) T {
+	Arg0(argv)
+	u := time.Now().UnixNano()
+	return MkInt(int64(u / 1000))
+}
+
+// cmdClockClicks returns nanoseconds, unless asked for coarser clicks.
+func cmdClockClicks(fr *Frame, argv []T) T {
+	u := time.Now().UnixNano()
+	switch len(argv) {
+	case 1:
+		return MkInt(u)
+	case 2:
+		switch argv[1].String() {
+		case "-milliseconds":
+			return MkInt(u / 1000000)
+		case "-microseconds":
+			return MkInt(u / 1000)
+		}
+	}
+	panic("Usage: clock clicks ?-milliseconds|-microseconds?")
+}
+
+// clockOptions holds the dash options shared by the clock commands.
+type clockOptions struct {
+	format   string // Tcl specifiers
+	layout   string // Go layout; overrides format
+	location *time.Location
+	base     *time.Time
+}
+
+func parseClockOptions(cmd string, args []T, allowed string) (*clockOptions, []T) {
+	z := &clockOptions{location: time.Local}
+	var rest []T
+	for len(args) > 0 {
+		flag := args[0].String()
+		if !strings.HasPrefix(flag, "-") || !strings.Contains(allowed, flag+" ") {
+			rest = append(rest, args[0])
+			args = args[1:]
+			continue
+		}
+		if len(args) < 2 {
+			panic(Sprintf("clock %s: missing value for %s", cmd, flag))
+		}
+		val := args[1]
+		args = args[2:]
+		switch flag {
+		case "-format":
+			z.format = val.String()
+		case "-layout":
+			z.layout = val.String()
+		case "-gmt":
+			if val.Bool() {
+				z.location = time.UTC
+			}
+		case "-timezone":
+			z.location = ClockLocation(val.String())
+		case "-base":
+			t := time.Unix(val.Int(), 0)
+			z.base = &t
+		}
+	}
+	return z, rest
+}
+
+// ClockLocation finds a time zone given as an IANA name (optionally with
+// a leading colon, as Tcl writes them), as :localtime, or as an offset like +0530.
+func ClockLocation(name string) *time.Location {
+	s := strings.TrimPrefix(name, ":")
+	switch s {
+	case "", "localtime":
+		return time.Local
+	case "UTC", "GMT", "Z":
+		return time.UTC
+	}
+	if off, ok := parseZoneOffset(s); ok {
+		return time.FixedZone(s, off)
+	}
+	loc, err := time.LoadLocation(s)
+	if err != nil {
+		panic(Sprintf("time zone %q not found", name))
+	}
+	return loc
+}
+
+// parseZoneOffset parses +hh, +hhmm or +hh:mm into seconds east of UTC.
+func parseZoneOffset(s string) (int, bool) {
+	if len(s) < 3 || (s[0] != '+' && s[0] != '-') {
+		return 0, false
+	}
+	digits := strings.Replace(s[1:], ":", "", 1)
+	if len(digits) != 2 && len(digits) != 4 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(digits)
+	if err != nil {
+		return 0, false
+	}
+	if len(digits) == 2 {
+		n *= 100
+	}
+	z := (n/100)*3600 + (n%100)*60
+	if s[0] == '-' {
+		z = -z
+	}
+	return z, true
+}
+
+func cmdClockFormat(fr *Frame, argv []T) T {
+	secsT, restT := Arg1v(argv)
+	opts, extra := parseClockOptions("format", restT, "-format -layout -gmt -timezone ")
+	if len(extra) > 0 {
+		panic(Sprintf("Unknown flag to {clock format}: %s", Showv(extra)))
+	}
+	t := time.Unix(0, int64(1000000000*secsT.Float())).In(opts.location)
+	if opts.layout != "" {
+		return MkString(t.Format(opts.layout))
+	}
+	f := opts.format
+	if f == "" {
+		f = DefaultClockFormat
+	}
+	return MkString(ClockFormat(t, f))
+}
+
+var clockDayNames = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+var clockMonthNames = []string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"}
+
+// ClockFormat formats t using Tcl's clock format specifiers.
+func ClockFormat(t time.Time, f string) string {
+	var buf strings.Builder
+	for i := 0; i < len(f); i++ {
+		c := f[i]
+		if c != '%' || i+1 >= len(f) {
+			buf.WriteByte(c)
+			continue
+		}
+		i++
+		c = f[i]
+		if (c == 'E' || c == 'O') && i+1 < len(f) {
+			i++ // Locale modifiers are ignored.
+			c = f[i]
+		}
+		hour12 := t.Hour() % 12
+		if hour12 == 0 {
+			hour12 = 12
+		}
+		switch c {
+		case 'a':
+			buf.WriteString(clockDayNames[t.Weekday()][:3])
+		case 'A':
+			buf.WriteString(clockDayNames[t.Weekday()])
+		case 'b', 'h':
+			buf.WriteString(clockMonthNames[t.Month()-1][:3])
+		case 'B':
+			buf.WriteString(clockMonthNames[t.Month()-1])
+		case 'c':
+			buf.WriteString(ClockFormat(t, "%a %b %e %H:%M:%S %Y"))
+		case 'C':
+			Fprintf(&buf, "%02d", t.Year()/100)
+		case 'd':
+			Fprintf(&buf, "%02d", t.Day())
+		case 'D', 'x':
+			buf.WriteString(ClockFormat(t, "%m/%d/%Y"))
+		case 'e':
+			Fprintf(&buf, "%2d", t.Day())
+		case 'g':
+			y, _ := t.ISOWeek()
+			Fprintf(&buf, "%02d", y%100)
+		case 'G':
+			y, _ := t.ISOWeek()
+			Fprintf(&buf, "%04d", y)
+		case 'H':
+			Fprintf(&buf, "%02d", t.Hour())
+		case 'I':
+			Fprintf(&buf, "%02d", hour12)
+		case 'j':
+			Fprintf(&buf, "%03d", t.YearDay())
+		case 'J':
+			Fprintf(&buf, "%d", julianDay(t))
+		case 'k':
+			Fprintf(&buf, "%2d", t.Hour())
+		case 'l':
+			Fprintf(&buf, "%2d", hour12)
+		case 'm':
+			Fprintf(&buf, "%02d", int(t.Month()))
+		case 'M':
+			Fprintf(&buf, "%02d", t.Minute())
+		case 'n':
+			buf.WriteByte('\n')
+		case 'N':
+			Fprintf(&buf, "%2d", int(t.Month()))
+		case 'p':
+			buf.WriteString(t.Format("PM"))
+		case 'P':
+			buf.WriteString(t.Format("pm"))
+		case 'r':
+			buf.WriteString(ClockFormat(t, "%I:%M:%S %p"))
+		case 'R':
+			buf.WriteString(ClockFormat(t, "%H:%M"))
+		case 's':
+			Fprintf(&buf, "%d", t.Unix())
+		case 'S':
+			Fprintf(&buf, "%02d", t.Second())
+		case 't':
+			buf.WriteByte('\t')
+		case 'T', 'X':
+			buf.WriteString(ClockFormat(t, "%H:%M:%S"))
+		case 'u':
+			w := int(t.Weekday())
+			if w == 0 {
+				w = 7
+			}
+			Fprintf(&buf, "%d", w)
+		case 'U':
+			Fprintf(&buf, "%02d", (t.YearDay()+6-int(t.Weekday()))/7)
+		case 'V':
+			_, w := t.ISOWeek()
+			Fprintf(&buf, "%02d", w)
+		case 'w':
+			Fprintf(&buf, "%d", int(t.Weekday()))
+		case 'W':
+			Fprintf(&buf, "%02d", (t.YearDay()+6-(int(t.Weekday())+6)%7)/7)
+		case 'y':
+			Fprintf(&buf, "%02d", t.Year()%100)
+		case 'Y':
+			Fprintf(&buf, "%04d", t.Year())
+		case 'z':
+			buf.WriteString(t.Format("-0700"))
+		case 'Z':
+			buf.WriteString(t.Format("MST"))
+		case '%':
+			buf.WriteByte('%')
+		default:
+			panic(Sprintf("bad format specifier %%%c in clock format", c))
+		}
+	}
+	return buf.String()
+}
+
+// julianDay is the astronomical Julian Day Number of t's date.
+func julianDay(t time.Time) int64 {
+	d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return d.Unix()/86400 + 2440588
+}
+
+// clockScanLayouts are tried in order by "clock scan" when no format is given.
+var clockScanLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"20060102T150405",
+	"20060102",
+	"01/02/2006 15:04:05",
+	"01/02/2006",
+	time.UnixDate,
+	time.RubyDate,
+	time.ANSIC,
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC850,
+	time.RFC822Z,
+	time.RFC822,
+	"Jan 2 2006 15:04:05",
+	"Jan 2 2006",
+	"2 Jan 2006",
+}
+
+func cmdClockScan(fr *Frame, argv []T) T {
+	strT, restT := Arg1v(argv)
+	opts, extra := parseClockOptions("scan", restT, "-format -layout -gmt -timezone -base ")
+	if len(extra) > 0 {
+		panic(Sprintf("Unknown flag to {clock scan}: %s", Showv(extra)))
+	}
+	s := strings.TrimSpace(strT.String())
+	base := time.Now()
+	if opts.base != nil {
+		base = *opts.base
+	}
+	base = base.In(opts.location)
+
+	if opts.layout != "" {
+		t, err := time.ParseInLocation(opts.layout, s, opts.location)
+		if err != nil {
+			panic(Sprintf("clock scan: %v", err))
+		}
+		return MkInt(t.Unix())
+	}
+	if opts.format != "" {
+		return MkInt(ClockScan(s, opts.format, base).Unix())
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && len(s) > 8 {
+		return MkInt(n) // Already in seconds.
+	}
+	for _, layout := range clockScanLayouts {
+		if t, err := time.ParseInLocation(layout, s, opts.location); err == nil {
+			return MkInt(t.Unix())
+		}
+	}
+	panic(Sprintf("unable to convert date-time string %q", s))
+}
+
+// ClockScan parses s using Tcl's clock format specifiers.
+// Date fields that are not given come from base (if no date is given at all)
+// or default to January 1 of base's year; missing time fields are zero.
+func ClockScan(s, f string, base time.Time) time.Time {
+	year, month, day := base.Date()
+	hour, min, sec := 0, 0, 0
+	yday := 0
+	gotDate, gotYear, gotMonth, gotDay := false, false, false, false
+	pm, gotAmPm := false, false
+	loc := base.Location()
+	var epoch *int64
+
+	mismatch := func() {
+		panic(Sprintf("input string %q does not match supplied format %q", s, f))
+	}
+	i := 0 // index into s
+	number := func(maxDigits int) int {
+		j := i
+		if j < len(s) && (s[j] == '+' || s[j] == '-') && maxDigits > 4 {
+			j++
+		}
+		for j < len(s) && j-i < maxDigits && isDigitByte(s[j]) {
+			j++
+		}
+		n, err := strconv.Atoi(s[i:j])
+		if err != nil {
+			mismatch()
+		}
+		i = j
+		return n
+	}
+	// name matches a full or 3-letter abbreviated name from the list.
+	name := func(names []string) int {
+		for k, nm := range names {
+			for _, cand := range []string{nm, nm[:3]} {
+				if len(s)-i >= len(cand) && strings.EqualFold(s[i:i+len(cand)], cand) {
+					i += len(cand)
+					return k
+				}
+			}
+		}
+		mismatch()
+		return 0
+	}
+	skipSpace := func() {
+		for i < len(s) && unicode.IsSpace(rune(s[i])) {
+			i++
+		}
+	}
+
+	// Expand the composite specifiers first.
+	f = strings.NewReplacer("%D", "%m/%d/%Y", "%x", "%m/%d/%Y", "%T", "%H:%M:%S", "%X", "%H:%M:%S",
+		"%R", "%H:%M", "%r", "%I:%M:%S %p", "%c", "%a %b %e %H:%M:%S %Y").Replace(f)
+
+	for k := 0; k < len(f); k++ {
+		c := f[k]
+		if unicode.IsSpace(rune(c)) {
+			skipSpace()
+			continue
+		}
+		if c != '%' || k+1 >= len(f) {
+			if i >= len(s) || s[i] != c {
+				mismatch()
+			}
+			i++
+			continue
+		}
+		k++
+		c = f[k]
+		if (c == 'E' || c == 'O') && k+1 < len(f) {
+			k++
+			c = f[k]
+		}
+		switch c {
+		case 'Y':
+			year, gotYear, gotDate = number(4), true, true
+		case 'y':
+			year, gotYear, gotDate = number(2), true, true
+			if year < 38 {
+				year += 2000
+			} else {
+				year += 1900
+			}
+		case 'm', 'N':
+			skipSpace()
+			month, gotMonth, gotDate = time.Month(number(2)), true, true
+		case 'b', 'B', 'h':
+			month, gotMonth, gotDate = time.Month(name(clockMonthNames)+1), true, true
+		case 'd', 'e':
+			skipSpace()
+			day, gotDay, gotDate = number(2), true, true
+		case 'j':
+			yday, gotDate = number(3), true
+		case 'a', 'A':
+			name(clockDayNames) // The weekday is checked for form only.
+		case 'H', 'k':
+			skipSpace()
+			hour = number(2)
+		case 'I', 'l':
+			skipSpace()
+			hour = number(2) % 12
+		case 'M':
+			min = number(2)
+		case 'S':
+			sec = number(2)
+		case 'p', 'P':
+			switch {
+			case len(s)-i >= 2 && strings.EqualFold(s[i:i+2], "AM"):
+			case len(s)-i >= 2 && strings.EqualFold(s[i:i+2], "PM"):
+				pm = true
+			default:
+				mismatch()
+			}
+			gotAmPm = true
+			i += 2
+		case 's':
+			e := int64(number(20))
+			epoch = &e
+		case 'z':
+			j := i + 1
+			for j < len(s) && (isDigitByte(s[j]) || s[j] == ':') {
+				j++
+			}
+			off, ok := parseZoneOffset(s[i:j])
+			if !ok {
+				mismatch()
+			}
+			loc = time.FixedZone(s[i:j], off)
+			i = j
+		case 'Z':
+			j := i
+			for j < len(s) && !unicode.IsSpace(rune(s[j])) {
+				j++
+			}
+			loc = ClockLocation(s[i:j])
+			i = j
+		case 'n', 't':
+			skipSpace()
+		case '%':
+			if i >= len(s) || s[i] != '%' {
+				mismatch()
+			}
+			i++
+		default:
+			panic(Sprintf("bad format specifier %%%c in clock scan", c))
+		}
+	}
+	skipSpace()
+	if i != len(s) {
+		mismatch()
+	}
+
+	if epoch != nil {
+		return time.Unix(*epoch, 0)
+	}
+	if gotAmPm && pm {
+		hour += 12
+	}
+	if gotDate {
+		if !gotYear {
+			year = base.Year()
+		}
+		if !gotMonth {
+			month = time.January
+		}
+		if !gotDay {
+			day = 1
+		}
+	}
+	if yday > 0 {
+		return time.Date(year, time.January, yday, hour, min, sec, 0, loc)
+	}
+	return time.Date(year, month, day, hour, min, sec, 0, loc)
+}
+
+func cmdClockAdd(fr *Frame, argv []T) T {
+	secsT, restT := Arg1v(argv)
+	opts, pairs := parseClockOptions("add", restT, "-gmt -timezone ")
+	if len(pairs)%2 != 0 {
+		panic("Usage: clock add seconds ?count unit ...? ?-gmt bool? ?-timezone zone?")
+	}
+	t := time.Unix(secsT.Int(), 0).In(opts.location)
+	for k := 0; k < len(pairs); k += 2 {
+		t = ClockAdd(t, int(pairs[k].Int()), pairs[k+1].String())
+	}
+	return MkInt(t.Unix())
+}
+
+// ClockAdd adds count units to t.  Days and larger units follow the calendar
+// in t's location.  Adding months or years keeps the day of the month,
+// but not past the end of the new month (Jan 31 plus 1 month is Feb 28 or 29).
+func ClockAdd(t time.Time, count int, unit string) time.Time {
+	switch strings.TrimSuffix(unit, "s") {
+	case "second":
+		return t.Add(time.Duration(count) * time.Second)
+	case "minute":
+		return t.Add(time.Duration(count) * time.Minute)
+	case "hour":
+		return t.Add(time.Duration(count) * time.Hour)
+	case "day":
+		return t.AddDate(0, 0, count)
+	case "week":
+		return t.AddDate(0, 0, 7*count)
+	case "weekday":
+		step := 1
+		if count < 0 {
+			step, count = -1, -count
+		}
+		for count > 0 {
+			t = t.AddDate(0, 0, step)
+			if t.Weekday() != time.Saturday && t.Weekday() != time.Sunday {
+				count--
+			}
+		}
+		return t
+	case "month":
+		return addMonths(t, count)
+	case "year":
+		return addMonths(t, 12*count)
+	}
+	panic(Sprintf("clock add: unknown unit %q", unit))
+}
+
+func addMonths(t time.Time, n int) time.Time {
+	y, m, d := t.Date()
+	first := time.Date(y, m+time.Month(n), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	if d > last {
+		d = last
+	}
+	return first.AddDate(0, 0, d-1)
+}
+
+func init() {
+	if Safes == nil {
+		Safes = make(map[string]Command, 333)
+	}
+
+	Safes["clock"] = MkEnsemble(clockEnsemble)
+}
@@ -0,0 +1,43 @@
+package tcl
+
+import (
+	"testing"
+)
+
+var clockTests = `
+	set t 1700000000 ;# Tue Nov 14 22:13:20 UTC 2023
+	must "2023-11-14 22:13:20" [clock format $t -format "%Y-%m-%d %H:%M:%S" -gmt 1]
+	must "Tue Nov 14 22:13:20 UTC 2023" [clock format $t -gmt 1]
+	must "Tuesday November 14 10:13:20 PM" [clock format $t -format "%A %B %e %r" -timezone :UTC]
+	must "318 46 2 +0000 %" [clock format $t -format "%j %V %u %z %%" -gmt 1]
+	must "2023-11-14 17:13:20 EST" [clock format $t -format "%Y-%m-%d %T %Z" -timezone America/New_York]
+	must "2023-11-15 03:43" [clock format $t -format "%Y-%m-%d %R" -timezone +0530]
+	must "14 Nov 23 22:13 +0000" [clock format $t -layout "02 Jan 06 15:04 -0700" -gmt 1]
+
+	must $t [clock scan "2023-11-14 22:13:20" -format "%Y-%m-%d %H:%M:%S" -gmt 1]
+	must $t [clock scan "11/14/2023 10:13:20 PM" -format "%D %r" -gmt 1]
+	must $t [clock scan "Nov 14 2023 17:13:20" -format "%b %d %Y %T" -timezone :America/New_York]
+	must $t [clock scan "2023-11-14 22:13:20 +0000" -format "%Y-%m-%d %T %z"]
+	must $t [clock scan "$t" -format "%s"]
+	must $t [clock scan "2023-11-14T22:13:20Z"]
+	must $t [clock scan "2023-11-14 22:13:20" -gmt 1]
+	must $t [clock scan "14.11.2023 22:13:20" -layout "02.01.2006 15:04:05" -gmt 1]
+	must 1699920000 [clock scan "2023-11-14" -format "%Y-%m-%d" -gmt 1]
+	must 1699920000 [clock scan "00:00" -format "%H:%M" -base $t -gmt 1]
+	mustfail {clock scan "2023-11-14" -format "%H:%M"}
+
+	must 1700086400 [clock add $t 1 day -gmt 1]
+	must 1700601200 [clock add $t 1 week -1 hour -gmt 1]
+	must "2024-02-29" [clock format [clock add [clock scan 2024-01-31 -format %Y-%m-%d -gmt 1] 1 month -gmt 1] -format %Y-%m-%d -gmt 1]
+	must "2023-11-17" [clock format [clock add $t 3 weekdays -gmt 1] -format %Y-%m-%d -gmt 1]
+	must "2025-11-14" [clock format [clock add $t 2 years -gmt 1] -format %Y-%m-%d -gmt 1]
+	mustfail {clock add $t 1 fortnight}
+
+	must 1 [expr {[clock clicks] > 0}]
+	must 1 [expr {[clock clicks -milliseconds] <= [clock milliseconds]}]
+`
+
+func TestClock(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(clockTests))
+}
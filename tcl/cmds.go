@@ -6,12 +6,12 @@ import (
 	"log"
 	// "net/http"
 	"os"
-	R "reflect"
 	//"runtime/debug"
-	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 // Safes are builtin commands that safe subinterps can call.
@@ -121,6 +121,26 @@ func ArgDash2vUsage(argv []T, usage string) ([]string, T, T, []T) {
 	return ArgDash2v(argv)
 }
 
+// MatchOption returns the option that opt names, exactly or as a unique prefix.
+func MatchOption(what string, opt string, options []string) string {
+	found := ""
+	for _, o := range options {
+		if o == opt {
+			return o
+		}
+		if strings.HasPrefix(o, opt) && len(opt) > 1 {
+			if found != "" {
+				panic(Sprintf("%s: ambiguous option %q: must be %s", what, opt, strings.Join(options, ", ")))
+			}
+			found = o
+		}
+	}
+	if found == "" {
+		panic(Sprintf("%s: bad option %q: must be %s", what, opt, strings.Join(options, ", ")))
+	}
+	return found
+}
+
 func Arg3(argv []T) (T, T, T) {
 	if len(argv) != 3+1 {
 		panic(Sprintf("Expected 3 arguments, but got argv=%s", Showv(argv)))
@@ -324,138 +344,6 @@ func cmdCase(fr *Frame, argv []T) T {
 	return fr.Eval(dflt)
 }
 
-func nextFormatLetter(s string) (z string, c byte, t R.Kind) {
-	// Advance to %
-	var i int = 0
-	for i < len(s) {
-		if s[i] == '%' {
-			// Advance to main letter.
-			i++
-			for i < len(s) {
-				switch s[i] {
-				case 'v', 'T':
-					return s[i+1:], s[i], R.Struct
-				case '%':
-					return s[i+1:], '%', R.Uint8
-				case 't':
-					return s[i+1:], s[i], R.Bool
-				case 'b', 'c', 'd', 'o', 'x', 'X', 'U':
-					return s[i+1:], s[i], R.Int
-				case 'e', 'E', 'f', 'F', 'g', 'G': // not 'b'
-					return s[i+1:], s[i], R.Float64
-				case 's', 'q': // not 'x', 'X'
-					return s[i+1:], s[i], R.String
-				case 'p':
-					return s[i+1:], s[i], R.Ptr
-				}
-				i++
-			}
-			return "", 0, R.Invalid
-		} else {
-			i++
-		}
-	}
-	return "", 0, R.Invalid
-}
-
-func cmdFormat(fr *Frame, argv []T) T {
-	f, args := Arg1v(argv)
-	s := f.String()
-	var vals []interface{}
-	var i int = 0
-	for {
-		var c byte
-		var k R.Kind
-		s, c, k = nextFormatLetter(s)
-		if c != '%' {
-			if k == R.Invalid {
-				break
-			}
-			if i >= len(args) {
-				panic("format: Not enough args")
-			}
-			switch k {
-			case R.Bool:
-				vals = append(vals, args[i].Bool())
-			case R.Int:
-				vals = append(vals, args[i].Int())
-			case R.Float64:
-				vals = append(vals, args[i].Float())
-			case R.String:
-				vals = append(vals, args[i].String())
-			default:
-				log.Panicf("cmdFormat: bad case: %v", k)
-			}
-			i++
-		}
-	}
-	if i != len(args) {
-		panic("format: Too many args")
-	}
-	return MkString(Sprintf(f.String(), vals...))
-}
-func cmdScan(fr *Frame, argv []T) T {
-	sT, fT, args := Arg2v(argv)
-	f := fT.String()
-	var ptrs []interface{}
-	var i int = 0
-	for {
-		var c byte
-		var k R.Kind
-		f, c, k = nextFormatLetter(f)
-		if c != '%' {
-			if k == R.Invalid {
-				break
-			}
-			if i >= len(args) {
-				panic("format: Not enough args")
-			}
-			switch k {
-			case R.Bool:
-				ptrs = append(ptrs, new(bool))
-			case R.Int:
-				ptrs = append(ptrs, new(int64))
-			case R.Float64:
-				ptrs = append(ptrs, new(float64))
-			case R.String:
-				ptrs = append(ptrs, new(string))
-			default:
-				panic("scan: Cannot handle a format")
-				//case R.Ptr:
-				//case R.Struct:
-			}
-			i++
-		}
-	}
-	if i != len(args) {
-		panic("format: Too many args")
-	}
-	n, err := Sscanf(sT.String(), fT.String(), ptrs...)
-	if err != nil {
-		panic(Sprintf("error in Sscanf: %v", err))
-	}
-	for j, ej := range ptrs {
-		if j == n {
-			break
-		}
-		var thing T
-		switch t := ej.(type) {
-		case *bool:
-			thing = MkBool(*t)
-		case *int64:
-			thing = MkInt(*t)
-		case *float64:
-			thing = MkFloat(*t)
-		case *string:
-			thing = MkString(*t)
-		default:
-			log.Panicf("Bad case in cmdScan: (%T) %#v", ej, ej)
-		}
-		fr.SetVar(args[j].String(), thing)
-	}
-	return MkInt(int64(n))
-}
-
 func cmdEcho(fr *Frame, argv []T) T {
 	args := Arg0v(argv)
 	buf := bytes.NewBuffer(nil)
@@ -523,6 +411,42 @@ func cmdYProc(fr *Frame, argv []T) T {
 func purifiedProc(fr *Frame, argv []T) T {
 	name, aa, body := Arg3(argv)
 	nameStr := name.String()
+	cmd := compileProc(fr, argv, nameStr, aa, body)
+
+	builtin := Safes[nameStr]
+	if builtin != nil {
+		panic(Sprintf("cannot redefine a builtin: %q", nameStr))
+	}
+
+	existingNode := fr.G.Cmds[nameStr]
+
+	if existingNode != nil {
+		panic(Sprintf("Name already defined at base level; cannot redefine: %q", nameStr))
+	}
+
+	// Install base command.
+	node := &CmdNode{
+		Fn:   cmd,
+		Next: nil,
+	}
+	fr.G.Cmds[nameStr] = node
+	if nameStr == "unknown" {
+		fr.G.UnknownCred = fr.Cred
+	}
+
+	// Remember the definition, so "go" can define it again in a new interpreter.
+	if fr.G.ProcDefs == nil {
+		fr.G.ProcDefs = make(map[string][]string)
+	}
+	fr.G.ProcDefs[nameStr] = []string{aa.String(), body.String()}
+
+	return Empty
+}
+
+// compileProc compiles the body and returns a Command that binds the formal
+// parameters aa (with defaults, and varargs if the last is "args").
+// The argv of the defining command is used for messages.
+func compileProc(fr *Frame, argv []T, nameStr string, aa T, body T) Command {
 	alist := aa.List()
 	astrs := make([]string, len(alist))
 	dflts := make([]T, len(alist))
@@ -547,6 +471,18 @@ func purifiedProc(fr *Frame, argv []T) T {
 
 	compiled := CompileSequence(fr, body.String())
 
+	// The formal parameters take the first slots, in order.  Destructuring
+	// parameters, like "a,b", have no slot (-1), and are bound by SetVar.
+	layout := NewVarLayout()
+	slots := make([]int, len(astrs))
+	for i, a := range astrs {
+		slots[i] = -1
+		if IsSlottable(a) {
+			slots[i] = layout.Add(a)
+		}
+	}
+	compiled.ResolveSlots(layout)
+
 	cmd := func(fr2 *Frame, argv2 []T) (result T) {
 		// If generating, not enough happens in this func (as opposed to
 		// in the goroutine) to encounter errors.  So this defer/recover is only
@@ -575,7 +511,7 @@ func purifiedProc(fr *Frame, argv []T) T {
 						rs = rs + Sprintf("\n\t\targ:%d = %q", ai, as)
 					}
 					// TODO: Require debug level for the locals.
-					for vk, vv := range fr2.Vars {
+					for vk, vv := range fr2.LocalLocs() {
 						vs := vv.Get().String()
 						if len(vs) > 80 {
 							vs = vs[:80] + "..."
@@ -594,71 +530,147 @@ func purifiedProc(fr *Frame, argv []T) T {
 		}
 
 		var varargs bool = false
+		fixed := n // Number of formal parameters before any "args".
 		if len(astrs) > 0 && astrs[len(astrs)-1] == "args" {
-			// TODO: Support dflts with varargs.
 			varargs = true
-			if len(argv2) < n {
-				panic(Sprintf("%s %q expects arguments %#v but got %d", argv[0], nameStr, aa, len(argv2)))
-			}
-		} else {
-			// Handle dflts with non-varargs.
-			for p := len(argv2); p < n+1; p++ {
+			fixed = n - 1
+		}
+
+		// Handle dflts for missing fixed parameters.
+		if len(argv2) < fixed+1 {
+			argv2 = append([]T(nil), argv2...) // Don't append into the caller's argv.
+			for p := len(argv2); p < fixed+1; p++ {
 				if dflts[p-1] != nil {
 					argv2 = append(argv2, dflts[p-1])
 				} else {
 					break
 				}
 			}
+		}
 
-			if len(argv2) != n+1 {
-				panic(Sprintf("%s %q expects arguments %#v but got %d", argv[0], nameStr, aa, len(argv2)))
-			}
+		if len(argv2) < fixed+1 || !varargs && len(argv2) != n+1 {
+			panic(Sprintf("%s %q expects arguments %#v but got %d", argv[0], nameStr, aa, len(argv2)))
 		}
 
-		fr3 := fr2.NewFrame()
+		fr3 := fr2.NewProcFrame(layout)
 		fr3.DebugName = nameStr
 
+		bind := func(i int, x T) {
+			if slots[i] < 0 {
+				fr3.SetVar(astrs[i], x)
+			} else {
+				fr3.Slots[slots[i]].Elem = x
+			}
+		}
 		if varargs {
-			for i, arg := range astrs[:len(astrs)-1] {
-				fr3.SetVar(arg, argv2[i+1])
+			for i := range astrs[:len(astrs)-1] {
+				bind(i, argv2[i+1])
 			}
 
-			fr3.SetVar("args", MkList(argv2[len(astrs):]))
+			bind(len(astrs)-1, MkList(argv2[len(astrs):]))
 		} else {
-			for i, arg := range astrs {
-				fr3.SetVar(arg, argv2[i+1])
+			for i := range astrs {
+				bind(i, argv2[i+1])
 			}
 		}
 
 		return compiled.Eval(fr3)
 	}
+	return cmd
+}
 
-	builtin := Safes[nameStr]
-	if builtin != nil {
-		panic(Sprintf("cannot redefine a builtin: %q", nameStr))
-	}
-
-	existingNode := fr.G.Cmds[nameStr]
+// MaxLambdas bounds the cache of compiled lambdas; it is cleared when full.
+var MaxLambdas = 1000
 
-	if existingNode != nil {
-		panic(Sprintf("Name already defined at base level; cannot redefine: %q", nameStr))
-	}
+// cmdApply calls an anonymous proc, {args body ?namespace?},
+// compiling it once per distinct lambda string.
+func cmdApply(fr *Frame, argv []T) T {
+	lambda, args := Arg1v(argv)
+	key := lambda.String()
 
-	// Install base command.
-	node := &CmdNode{
-		Fn:   cmd,
-		Next: nil,
+	cmd, ok := fr.G.Lambdas[key]
+	if !ok {
+		parts := lambda.List()
+		if len(parts) < 2 || len(parts) > 3 {
+			panic(Sprintf("can't interpret %q as a lambda expression", key))
+		}
+		if len(parts) == 3 {
+			if ns := parts[2].String(); ns != "" && ns != "::" {
+				panic(Sprintf("apply: namespaces are not supported: %q", ns))
+			}
+		}
+		cmd = compileProc(fr, argv, "lambda", parts[0], parts[1])
+		if fr.G.Lambdas == nil || len(fr.G.Lambdas) >= MaxLambdas {
+			fr.G.Lambdas = make(map[string]Command)
+		}
+		fr.G.Lambdas[key] = cmd
 	}
-	fr.G.Cmds[nameStr] = node
 
-	return Empty
+	// The lambda sees its arguments as argv2[1:], with itself as argv2[0].
+	argv2 := make([]T, 1+len(args))
+	argv2[0] = lambda
+	copy(argv2[1:], args)
+	return cmd(fr, argv2)
 }
 
 func cmdSLen(fr *Frame, argv []T) T {
+	a := Arg1(argv)
+	return MkInt(int64(CharLen(a.String())))
+}
+
+func cmdSByteLen(fr *Frame, argv []T) T {
 	a := Arg1(argv)
 	return MkInt(int64(len(a.String())))
 }
 
+// IsASCII tells if s has only 7-bit characters,
+// in which case byte indices are character indices.
+func IsASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// CharLen is the number of characters (not bytes) in s.
+func CharLen(s string) int {
+	if IsASCII(s) {
+		return len(s)
+	}
+	return utf8.RuneCountInString(s)
+}
+
+// CharOffset is the byte offset of character i in s,
+// or len(s) if s has i or fewer characters.
+func CharOffset(s string, i int) int {
+	if IsASCII(s) {
+		if i > len(s) {
+			return len(s)
+		}
+		return i
+	}
+	for off := range s {
+		if i == 0 {
+			return off
+		}
+		i--
+	}
+	return len(s)
+}
+
+// CharSlice returns characters low (inclusive) to high (exclusive) of s,
+// where 0 <= low <= high <= CharLen(s).
+func CharSlice(s string, low, high int) string {
+	if IsASCII(s) {
+		return s[low:high]
+	}
+	a := CharOffset(s, low)
+	b := a + CharOffset(s[a:], high-low)
+	return s[a:b]
+}
+
 func cmdLLen(fr *Frame, argv []T) T {
 	a := Arg1(argv)
 	return MkInt(int64(len(a.List())))
@@ -668,21 +680,42 @@ func cmdList(fr *Frame, argv []T) T {
 	return MkList(argv[1:])
 }
 
+// ParseIndex converts a Tcl index for a list or string of length n.
+// Besides an integer, it may be "end", "end-N", "end+N", "M+N" or "M-N".
+// The result is not checked against n.
+func ParseIndex(t T, n int) int {
+	if t.IsQuickInt() {
+		return int(t.Int())
+	}
+	s := strings.TrimSpace(t.String())
+	if strings.HasPrefix(s, "end") {
+		s = s[3:]
+		if s == "" {
+			return n - 1
+		}
+		if s[0] == '+' || s[0] == '-' {
+			if z, err := strconv.ParseInt(s, 0, 64); err == nil {
+				return n - 1 + int(z)
+			}
+		}
+	} else if z, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return int(z)
+	} else if k := strings.LastIndexAny(s, "+-"); k > 0 {
+		a, errA := strconv.ParseInt(s[:k], 0, 64)
+		b, errB := strconv.ParseInt(s[k:], 0, 64)
+		if errA == nil && errB == nil {
+			return int(a + b)
+		}
+	}
+	panic(Sprintf("bad index %q: must be integer?[+-]integer? or end?[+-]integer?", t.String()))
+}
+
 func cmdLIndex(fr *Frame, argv []T) T {
 	tlist, ti := Arg2(argv)
 	list := tlist.List()
-	istr := ti.String()
+	i := int64(ParseIndex(ti, len(list)))
 
-	var i int64
-	if istr == "end" {
-		i = (int64)(len(list) - 1)
-	} else if len(istr) > 4 && istr[:3] == "end-" {
-		panic("unimplemented: lrange ends with 'end-N'")
-	} else {
-		i = ti.Int()
-	}
-
-	if i < 0 || i > int64(len(list)) {
+	if i < 0 || i >= int64(len(list)) {
 		panic(Sprintf("lindex: bad index: len(list)=%d but i=%d", len(list), i))
 	}
 	return list[i]
@@ -691,17 +724,8 @@ func cmdLIndex(fr *Frame, argv []T) T {
 func cmdLRange(fr *Frame, argv []T) T {
 	tlist, tbegin, tend := Arg3(argv)
 	list := tlist.List()
-	begin := tbegin.Int()
-	endStr := tend.String()
-
-	var end int64
-	if endStr == "end" {
-		end = (int64)(len(list) - 1)
-	} else if len(endStr) > 4 && endStr[:3] == "end-" {
-		panic("unimplemented: lrange ends with 'end-N'")
-	} else {
-		end = tend.Int()
-	}
+	begin := int64(ParseIndex(tbegin, len(list)))
+	end := int64(ParseIndex(tend, len(list)))
 
 	// Now convert to C++ style end, which points to slot after the last one.
 	end++
@@ -723,98 +747,6 @@ func cmdLRange(fr *Frame, argv []T) T {
 	return MkList(z)
 }
 
-type lsorter struct {
-	asInt      bool
-	asReal     bool
-	descending bool
-	index      int64
-	vec        []T
-}
-
-func (o *lsorter) Len() int {
-	return len(o.vec)
-}
-func xor(a, b bool) bool {
-	if a {
-		return !b
-	}
-	return b
-}
-func ith(t T, index int64) T {
-	if index < 0 {
-		return t
-	}
-	return t.List()[index]
-}
-func (o *lsorter) Less(i, j int) bool {
-	// Would be more efficient to extract the ith at creation.
-	if o.asInt {
-		a := ith(o.vec[i], o.index).Int()
-		b := ith(o.vec[j], o.index).Int()
-		return xor(a < b, o.descending)
-	} else if o.asReal {
-		a := ith(o.vec[i], o.index).Float()
-		b := ith(o.vec[j], o.index).Float()
-		return xor(a < b, o.descending)
-	} else {
-		a := ith(o.vec[i], o.index).String()
-		b := ith(o.vec[j], o.index).String()
-		return xor(a < b, o.descending)
-	}
-}
-func (o *lsorter) Swap(i, j int) {
-	o.vec[i], o.vec[j] = o.vec[j], o.vec[i]
-}
-
-func cmdLSort(fr *Frame, argv []T) T {
-	// lsort ?options? list
-	if len(argv) < 2 {
-		panic("lsort needs a list arg")
-	}
-
-	vecT := argv[len(argv)-1]
-	vec := vecT.List()
-	n := len(vec)
-	if n <= 1 {
-		// Consider a list with 1 or less elements already sorted.
-		return vecT
-	}
-
-	c := &lsorter{
-		asInt:      false,
-		asReal:     false,
-		descending: false,
-		index:      -1,
-		vec:        vec,
-	}
-
-	opts := argv[1 : len(argv)-1] // Remove cmd name (first) and the list (final).
-	for len(opts) > 0 {
-		opt := opts[0].String()
-		if strings.HasPrefix(opt, "-int") {
-			c.asInt = true
-			opts = opts[1:]
-		} else if strings.HasPrefix(opt, "-r") {
-			c.asReal = true
-			opts = opts[1:]
-		} else if strings.HasPrefix(opt, "-d") {
-			c.descending = true
-			opts = opts[1:]
-		} else if strings.HasPrefix(opt, "-ind") && len(opts) > 1 {
-			c.index = opts[1].Int()
-			opts = opts[2:]
-		} else {
-			panic("lsort: bad option")
-		}
-	}
-
-	// Sort our strings.
-	sort.Sort(c)
-
-	// Return the sorted list.
-	return MkList(c.vec)
-}
-
 func cmdLReverse(fr *Frame, argv []T) T {
 	tt := Arg1(argv)
 	v := tt.List()
@@ -827,8 +759,8 @@ func cmdLReverse(fr *Frame, argv []T) T {
 
 func cmdSAt(fr *Frame, argv []T) T {
 	s, j := Arg2(argv)
-	i := j.Int()
-	return MkString(s.String()[i : i+1])
+	i := int(j.Int())
+	return MkString(CharSlice(s.String(), i, i+1))
 }
 
 func cmdForEach(fr *Frame, argv []T) T {
@@ -840,6 +772,7 @@ func cmdForEach(fr *Frame, argv []T) T {
 
 Outer:
 	for {
+		fr.G.CheckLimits() // Each iteration counts as a command.
 		var hd T
 		var tl T
 		for _, varT := range varL {
@@ -891,6 +824,7 @@ func cmdWhile(fr *Frame, argv []T) T {
 	toContinue := false
 
 	for {
+		fr.G.CheckLimits() // Each iteration counts as a command.
 		c := fr.EvalExpr(cond)
 		if !c.Bool() {
 			break
@@ -939,6 +873,10 @@ func cmdCatch(fr *Frame, argv []T) (status T) {
 
 	defer func() {
 		if r := recover(); r != nil {
+			// A limit of this interpreter (or a parent) cannot be caught within it.
+			if e, ok := r.(LimitExceeded); ok && e.stops(fr.G) {
+				panic(r)
+			}
 
 			// println(Sprintf("\n\n%%%%%%%%%%%%%% catch: CAUGHT EXCEPTION: %T: %v", r, r))
 			// println("%%%%%%%%%%%%%% catch: CAUGHT EXCEPTION PrintStack {")
@@ -966,55 +904,6 @@ func cmdCatch(fr *Frame, argv []T) (status T) {
 	return False
 }
 
-var clockEnsemble = []EnsembleItem{
-	EnsembleItem{Name: "seconds", Cmd: cmdClockSeconds},
-	EnsembleItem{Name: "milliseconds", Cmd: cmdClockMilliseconds},
-	EnsembleItem{Name: "microseconds", Cmd: cmdClockMicroseconds},
-	EnsembleItem{Name: "format", Cmd: cmdClockFormat},
-}
-
-func cmdClockSeconds(fr *Frame, argv []T) T {
-	Arg0(argv)
-	u := time.Now().Unix()
-	return MkInt(int64(u))
-}
-
-func cmdClockMilliseconds(fr *Frame, argv []T) T {
-	Arg0(argv)
-	u := time.Now().UnixNano()
-	return MkInt(int64(u / 1000000))
-}
-
-func cmdClockMicroseconds(fr *Frame, argv []T) T {
-	Arg0(argv)
-	u := time.Now().UnixNano()
-	return MkInt(int64(u / 1000))
-}
-
-func cmdClockFormat(fr *Frame, argv []T) T {
-	secsT, restT := Arg1v(argv)
-	f := time.UnixDate
-	location := time.Local
-	for len(restT) >= 2 {
-		flagT, valT, moreT := restT[0], restT[1], restT[2:]
-		switch flagT.String() {
-		case "-format":
-			f = valT.String()
-		case "-gmt":
-			if valT.Bool() {
-				location = time.UTC
-			}
-		default:
-			panic("Unknown flag to {clock format}")
-		}
-		restT = moreT
-	}
-	if len(restT) > 0 {
-		panic("Odd number of args after {clock format}")
-	}
-	return MkString(time.Unix(0, int64(1000000000*secsT.Float())).In(location).Format(f))
-}
-
 func cmdTime(fr *Frame, argv []T) T {
 	cmd, rest := Arg1v(argv)
 	var n int64
@@ -1040,30 +929,29 @@ func cmdEval(fr *Frame, argv []T) T {
 	return EvalOrApplyLists(fr, argv[1:])
 }
 
-func cmdGo(fr *Frame, argv []T) T {
-	go EvalOrApplyLists(fr, argv[1:])
-	return Empty
-}
-
 // uplevel requres first arg specifying what level.
 // Valid are "#0" (global) or a positive integer (relative).
 func cmdUpLevel(fr *Frame, argv []T) T {
 	specArg, rest := Arg1v(argv)
 	spec := specArg.String()
 
-	// Special case for #0 meaning global.
+	// The target frame gets no more capabilities than the caller.
+	target := fr
 	if spec == "#0" {
-		return EvalOrApplyLists(&fr.G.Fr, rest)
-	}
-
-	// Count back number of frames specified.
-	level := specArg.Int()
-	for i := int64(0); i < level; i++ {
-		if fr.Prev != nil {
-			fr = fr.Prev
+		// Special case for #0 meaning global.
+		target = &fr.G.Fr
+	} else {
+		// Count back number of frames specified.
+		level := specArg.Int()
+		for i := int64(0); i < level; i++ {
+			if target.Prev != nil {
+				target = target.Prev
+			}
 		}
 	}
-	return EvalOrApplyLists(fr, rest)
+	return target.WithCred(fr.Cred, func() T {
+		return EvalOrApplyLists(target, rest)
+	})
 }
 
 func EvalOrApplyLists(fr *Frame, lists []T) T {
@@ -1174,33 +1062,7 @@ func cmdSet(fr *Frame, argv []T) T {
 	if len(targ) == 0 {
 		panic("command 'set' target is empty")
 	}
-	n := len(targ)
-	if targ[n-1] == ')' {
-		// Case Subscript:
-		i := strings.Index(targ, "(")
-		if i < 0 {
-			panic("command 'set' target ends with ')' but has no '('")
-		}
-		if i < 1 {
-			panic("command 'set' target is empty before '('")
-		}
-
-		name := targ[:i]
-		key := targ[i+1 : n-1]
-		if len(argv) == 2 {
-			h := fr.GetVar(name)
-			return h.GetAt(MkString(key))
-		}
-		if !fr.HasVar(name) {
-			fr.SetVar(name, MkHash(nil))
-		}
-		_, x := Arg2(argv)
-		h := fr.GetVar(name)
-		h.PutAt(x, MkString(key))
-		return x
-	}
-
-	// Case No Subscript:
+	// GetVar and SetVar handle array elements, like "set a(k) v".
 	if len(argv) == 2 {
 		// Retrieve value of variable, if 2nd arg is missing.
 		name := Arg1(argv)
@@ -1265,17 +1127,21 @@ func cmdHGet(fr *Frame, argv []T) T {
 
 func cmdHSet(fr *Frame, argv []T) T {
 	hash, key, value := Arg3(argv)
-	h := hash.Hash()
-	k := key.String()
-	h[k] = value
+	if th, ok := hash.(*terpHash); ok {
+		th.put(fr, key.String(), value)
+	} else {
+		hash.PutAt(value, key)
+	}
 	return value
 }
 
 func cmdHDel(fr *Frame, argv []T) T {
 	hash, key := Arg2(argv)
-	h := hash.Hash()
-	k := key.String()
-	delete(h, k)
+	if th, ok := hash.(*terpHash); ok {
+		th.del(fr, key.String())
+	} else {
+		delete(hash.Hash(), key.String())
+	}
 	return Empty
 }
 
@@ -1385,6 +1251,7 @@ func cmdThrow(fr *Frame, argv []T) T {
 
 var stringEnsemble = []EnsembleItem{
 	EnsembleItem{Name: "length", Cmd: cmdSLen},
+	EnsembleItem{Name: "bytelength", Cmd: cmdSByteLen},
 	EnsembleItem{Name: "range", Cmd: cmdStringRange},
 	EnsembleItem{Name: "slice", Cmd: cmdStringSlice},
 	EnsembleItem{Name: "first", Cmd: cmdStringFirst},
@@ -1398,7 +1265,7 @@ func cmdStringRange(fr *Frame, argv []T) T {
 	str, first, last := Arg3(argv)
 
 	strS := str.String()
-	n := len(strS)
+	n := CharLen(strS)
 	firstI := int(first.Int()) // The index of the first character to include.
 
 	keep := 1     // Tcl's string range includes the character indexed by last
@@ -1414,7 +1281,7 @@ func cmdStringRange(fr *Frame, argv []T) T {
 		return Empty
 	}
 
-	return MkString(strS[low:high])
+	return MkString(CharSlice(strS, low, high))
 }
 
 // Follows golang's slice spec.
@@ -1422,7 +1289,7 @@ func cmdStringSlice(fr *Frame, argv []T) T {
 	str, first, last := Arg3(argv)
 
 	strS := str.String()
-	n := len(strS)
+	n := CharLen(strS)
 	firstI := int(first.Int()) // The index of the first character to include.
 
 	var lastI int // The number characters to include.
@@ -1437,7 +1304,7 @@ func cmdStringSlice(fr *Frame, argv []T) T {
 		return Empty
 	}
 
-	return MkString(strS[low:high])
+	return MkString(CharSlice(strS, low, high))
 }
 
 // Slicer will find the low and high values for slicing a golang slice.
@@ -1490,9 +1357,13 @@ func slicer(length int, first, last int, keep int) (int, int, bool) {
 func cmdStringFirst(fr *Frame, argv []T) T {
 	needle, haystack := Arg2(argv)
 
-	i := strings.Index(haystack.String(), needle.String())
+	h := haystack.String()
+	i := strings.Index(h, needle.String())
+	if i > 0 && !IsASCII(h[:i]) {
+		i = utf8.RuneCountInString(h[:i]) // Character index, not byte index.
+	}
 
-	return MkFloat(float64(i))
+	return MkInt(int64(i))
 }
 
 func cmdStringIndex(fr *Frame, argv []T) T {
@@ -1500,14 +1371,13 @@ func cmdStringIndex(fr *Frame, argv []T) T {
 
 	s := str.String()
 	i := int(charIndex.Int())
-	n := len(s)
+	n := CharLen(s)
 
 	if i < 0 || i >= n {
 		return Empty
 	}
 
-	z := string(s[i])
-	return MkString(z)
+	return MkString(CharSlice(s, i, i+1))
 }
 
 func cmdStringTrim(fr *Frame, argv []T) T {
@@ -1536,10 +1406,18 @@ func cmdStringMatch(fr *Frame, argv []T) T {
 	return MkBool(StringMatch(pattern.String(), str.String()))
 }
 
+// StringMatch matches glob-style, by characters.
 func StringMatch(pattern, str string) bool {
+	if IsASCII(pattern) && IsASCII(str) {
+		return matchChars([]byte(pattern), []byte(str))
+	}
+	return matchChars([]rune(pattern), []rune(str))
+}
+
+func matchChars[C byte | rune](pattern, str []C) bool {
 	plen, slen := len(pattern), len(str)
 	pidx, cidx := 0, 0
-	var p, c uint8
+	var p, c C
 
 Loop:
 	for pidx < plen {
@@ -1586,7 +1464,7 @@ Loop:
 					// cidx should be the index of c in str
 				}
 
-				if StringMatch(pattern[pidx:], str[cidx:]) {
+				if matchChars(pattern[pidx:], str[cidx:]) {
 					return true
 				}
 
@@ -1612,7 +1490,7 @@ Loop:
 		}
 
 		if p == '[' {
-			var start, end uint8
+			var start, end C
 
 			// Skip the pidx to point to the next char
 			pidx++
@@ -1696,11 +1574,13 @@ Loop:
 }
 
 var arrayEnsemble = []EnsembleItem{
-	EnsembleItem{Name: "get", Cmd: cmdArrayGet},
-	EnsembleItem{Name: "set", Cmd: cmdArraySet},
-	EnsembleItem{Name: "size", Cmd: cmdArraySize},
-	EnsembleItem{Name: "exists", Cmd: cmdArrayExists},
-	EnsembleItem{Name: "names", Cmd: cmdArrayNames},
+	EnsembleItem{Name: "get", Cmd: cmdArrayGet, Doc: "arrayName ?pattern?"},
+	EnsembleItem{Name: "set", Cmd: cmdArraySet, Doc: "arrayName list"},
+	EnsembleItem{Name: "size", Cmd: cmdArraySize, Doc: "arrayName"},
+	EnsembleItem{Name: "exists", Cmd: cmdArrayExists, Doc: "arrayName"},
+	EnsembleItem{Name: "names", Cmd: cmdArrayNames, Doc: "arrayName ?-exact|-glob|-regexp? ?pattern?"},
+	EnsembleItem{Name: "unset", Cmd: cmdArrayUnset, Doc: "arrayName ?pattern?"},
+	EnsembleItem{Name: "for", Cmd: cmdArrayFor, Doc: "{keyVar valueVar} arrayName body"},
 }
 
 func cmdArraySet(fr *Frame, argv []T) T {
@@ -1719,23 +1599,76 @@ func cmdArraySet(fr *Frame, argv []T) T {
 		h = fr.GetVar(s) // TODO: race
 	}
 	for i := 0; i < n; i += 2 {
-		h.PutAt(v[i+1], v[i])
+		if th, ok := h.(*terpHash); ok {
+			th.put(fr, v[i].String(), v[i+1])
+		} else {
+			h.PutAt(v[i+1], v[i])
+		}
 	}
 	return h
 }
 
+// arrayOrNil returns the hash of the named array,
+// or nil if there is no such variable.
+func arrayOrNil(fr *Frame, name string) Hash {
+	if !fr.HasVar(name) {
+		return nil
+	}
+	h, ok := fr.GetVar(name).(*terpHash)
+	if !ok {
+		panic(Sprintf("%q isn't an array", name))
+	}
+	return h.Hash()
+}
+
+// matchingKeys returns the sorted keys of h that match the pattern.
+// The mode is "-exact", "-glob", or "-regexp".
+func matchingKeys(h Hash, mode string, pattern T) []string {
+	keys := SortedKeysOfHash(h)
+	if pattern == nil {
+		return keys
+	}
+	pat := pattern.String()
+	z := make([]string, 0, len(keys))
+	for _, k := range keys {
+		var ok bool
+		switch mode {
+		case "-exact":
+			ok = k == pat
+		case "-glob":
+			ok = StringMatch(pat, k)
+		case "-regexp":
+			ok = Regexp(pat, false).MatchString(k)
+		default:
+			panic(Sprintf("bad option %q: must be -exact, -glob, or -regexp", mode))
+		}
+		if ok {
+			z = append(z, k)
+		}
+	}
+	return z
+}
+
 func cmdArrayGet(fr *Frame, argv []T) T {
-	varName := Arg1(argv) // TODO: optional glob.
-	t := fr.GetVar(varName.String())
-	h := t.Hash()
+	varName, rest := Arg1v(argv)
+	var pattern T
+	switch len(rest) {
+	case 0:
+	case 1:
+		pattern = rest[0]
+	default:
+		panic("Usage: array get arrayName ?pattern?")
+	}
+	h := arrayOrNil(fr, varName.String())
 
 	var z []T
-	for _, k := range SortedKeysOfHash(h) {
+	for _, k := range matchingKeys(h, "-glob", pattern) {
 		z = append(z, MkString(k))
 		z = append(z, h[k])
 	}
 	return MkList(z)
 }
+
 func cmdArraySize(fr *Frame, argv []T) T {
 	name := Arg1(argv)
 	s := name.String()
@@ -1746,7 +1679,7 @@ func cmdArraySize(fr *Frame, argv []T) T {
 
 	t := fr.GetVar(s)
 	h := t.Hash()
-	n := len(h)
+	n := len(SortedKeysOfHash(h)) // Omit deletions.
 	return MkInt(int64(n))
 }
 
@@ -1763,10 +1696,138 @@ func cmdArrayExists(fr *Frame, argv []T) T {
 }
 
 func cmdArrayNames(fr *Frame, argv []T) T {
-	hashName := Arg1(argv)
-	t := fr.GetVar(hashName.String())
-	h := t.Hash()
-	return MkList(hashKeys(h))
+	hashName, rest := Arg1v(argv)
+	mode := "-glob"
+	var pattern T
+	switch len(rest) {
+	case 0:
+	case 1:
+		pattern = rest[0]
+	case 2:
+		mode = rest[0].String()
+		pattern = rest[1]
+	default:
+		panic("Usage: array names arrayName ?-exact|-glob|-regexp? ?pattern?")
+	}
+	h := arrayOrNil(fr, hashName.String())
+	return MkStringList(matchingKeys(h, mode, pattern))
+}
+
+func cmdArrayUnset(fr *Frame, argv []T) T {
+	hashName, rest := Arg1v(argv)
+	name := hashName.String()
+	switch len(rest) {
+	case 0:
+		arrayOrNil(fr, name) // Insist it is an array, if it exists.
+		fr.UnsetVar(name)
+	case 1:
+		h := arrayOrNil(fr, name)
+		for _, k := range matchingKeys(h, "-glob", rest[0]) {
+			fr.UnsetVar(Sprintf("%s(%s)", name, k))
+		}
+	default:
+		panic("Usage: array unset arrayName ?pattern?")
+	}
+	return Empty
+}
+
+// cmdArrayFor iterates over a snapshot of the keys, in sorted order.
+// Elements unset during the loop are skipped.
+func cmdArrayFor(fr *Frame, argv []T) T {
+	varsT, hashName, body := Arg3(argv)
+	vars := varsT.List()
+	if len(vars) != 2 {
+		panic("array for: must have exactly two variable names")
+	}
+	h := arrayOrNil(fr, hashName.String())
+	if h == nil {
+		panic(Sprintf("%q isn't an array", hashName.String()))
+	}
+
+	for _, k := range SortedKeysOfHash(h) {
+		v := h[k]
+		if v == nil {
+			continue
+		}
+		fr.SetVar(vars[0].String(), MkString(k))
+		fr.SetVar(vars[1].String(), v)
+
+		toBreak := false
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					if j, ok := r.(Jump); ok {
+						switch j.Status {
+						case BREAK:
+							toBreak = true
+							return
+						case CONTINUE:
+							return
+						}
+					}
+					panic(r) // Rethrow errors and unknown Status.
+				}
+			}()
+			fr.Eval(body)
+		}()
+		if toBreak {
+			break
+		}
+	}
+	return Empty
+}
+
+// cmdParray prints the array like Tcl's parray, with the "=" signs aligned.
+func cmdParray(fr *Frame, argv []T) T {
+	hashName, rest := Arg1v(argv)
+	name := hashName.String()
+	var pattern T
+	switch len(rest) {
+	case 0:
+	case 1:
+		pattern = rest[0]
+	default:
+		panic("Usage: parray arrayName ?pattern?")
+	}
+	h := arrayOrNil(fr, name)
+	if h == nil {
+		panic(Sprintf("%q isn't an array", name))
+	}
+	keys := matchingKeys(h, "-glob", pattern)
+	width := 0
+	for _, k := range keys {
+		if n := CharLen(k); n > width {
+			width = n
+		}
+	}
+	// Print with the puts command, so output goes to the stdout channel.
+	if _, ok := fr.G.Cmds["puts"]; !ok {
+		panic(`parray: there is no "puts" command`)
+	}
+	for _, k := range keys {
+		label := name + "(" + k + ")"
+		line := Sprintf("%-*s = %s", width+CharLen(name)+2, label, h[k].String())
+		fr.Apply([]T{MkString("puts"), MkString(line)})
+	}
+	return Empty
+}
+
+func cmdUnset(fr *Frame, argv []T) T {
+	args := Arg0v(argv)
+	complain := true
+	if len(args) > 0 && args[0].String() == "-nocomplain" {
+		complain = false
+		args = args[1:]
+	}
+	if len(args) > 0 && args[0].String() == "--" {
+		args = args[1:]
+	}
+	for _, a := range args {
+		if !fr.UnsetVar(a.String()) && complain {
+			panic(Sprintf("can't unset %q: no such variable", a.String()))
+		}
+	}
+	return Empty
 }
 
 var infoEnsemble = []EnsembleItem{
@@ -1775,6 +1836,8 @@ var infoEnsemble = []EnsembleItem{
 	EnsembleItem{Name: "globals", Cmd: cmdInfoGlobals},
 	EnsembleItem{Name: "locals", Cmd: cmdInfoLocals},
 	EnsembleItem{Name: "exists", Cmd: cmdInfoExists},
+	EnsembleItem{Name: "script", Cmd: cmdInfoScript},
+	EnsembleItem{Name: "cred", Cmd: cmdInfoCred},
 }
 
 func cmdInfoMacros(fr *Frame, argv []T) T {
@@ -1807,7 +1870,7 @@ func cmdInfoGlobals(fr *Frame, argv []T) T {
 func cmdInfoLocals(fr *Frame, argv []T) T {
 	Arg0(argv) // TODO: optional pattern
 	var zz []T
-	for k, _ := range fr.Vars {
+	for k, _ := range fr.LocalLocs() {
 		zz = append(zz, MkString(k))
 	}
 	SortListByString(zz)
@@ -1817,27 +1880,6 @@ func cmdInfoLocals(fr *Frame, argv []T) T {
 func cmdInfoExists(fr *Frame, argv []T) T {
 	name := Arg1(argv)
 	s := name.String()
-	if strings.HasSuffix(s, ")") {
-		p := strings.IndexByte(s, '(')
-		if p < 0 {
-			panic("bad syntax for array variable")
-		}
-		varname := s[:p]
-
-		if !fr.HasVar(varname) {
-			return False
-		}
-		t := fr.GetVar(varname)
-		h, ok := t.(*terpHash)
-		if !ok {
-			return False
-		}
-		key := s[p+1 : len(s)-1]
-
-		_, ok = h.h[key]
-		return MkBool(ok)
-	}
-
 	return MkBool(fr.HasVar(s))
 }
 
@@ -1869,7 +1911,8 @@ func cmdSplit(fr *Frame, argv []T) T {
 			break
 		}
 		z = append(z, MkString(s[:i]))
-		s = s[i+1:]
+		_, size := utf8.DecodeRuneInString(s[i:])
+		s = s[i+size:]
 	}
 	return MkList(z)
 }
@@ -1995,12 +2038,11 @@ func init() {
 	Safes["mustfail"] = cmdMustFail
 	Safes["if"] = cmdIf
 	Safes["case"] = cmdCase
-	Safes["format"] = cmdFormat
-	Safes["scan"] = cmdScan
 	Safes["echo"] = cmdEcho
 	Safes["say"] = cmdSay
 	Safes["macro"] = cmdMacro
 	Safes["proc"] = cmdProc
+	Safes["apply"] = cmdApply
 
 	Safes["list"] = cmdList
 	Safes["lindex"] = cmdLIndex
@@ -2012,7 +2054,6 @@ func init() {
 	Safes["while"] = cmdWhile
 	Safes["catch"] = cmdCatch
 	Safes["eval"] = cmdEval
-	Safes["go"] = cmdGo
 	Safes["uplevel"] = cmdUpLevel
 	Safes["concat"] = cmdConcat
 	Safes["set"] = cmdSet
@@ -2037,11 +2078,12 @@ func init() {
 	Safes["string"] = MkEnsemble(stringEnsemble)
 	Safes["info"] = MkEnsemble(infoEnsemble)
 	Safes["array"] = MkEnsemble(arrayEnsemble)
+	Safes["parray"] = cmdParray
+	Safes["unset"] = cmdUnset
 	Safes["split"] = cmdSplit
 	Safes["join"] = cmdJoin
 	Safes["subst"] = cmdSubst
 	Safes["log"] = cmdLog
 	Safes["usage"] = cmdUsage // TODO?
 	Safes["time"] = cmdTime
-	Safes["clock"] = MkEnsemble(clockEnsemble)
 }
@@ -76,42 +76,6 @@ var cmdTests = `
   must "0" [range 1]
   must "0 1 2 3 4" [range 5]
 
-  yproc yrange n {
-      set i 0
-      while {$i<$n} {
-          yield $i
-          set i [sum $i 1]
-      }
-  }
-  must "" [concat [yrange 0]]
-  must "0" [concat [yrange 1]]
-  must "0 1 2 3 4" [concat [yrange 5]]
-
-  yproc ytriangs nums {
-      foreach n $nums {
-          yield [triang $n]
-      }
-  }
-  must "1 3 6 10 15" [concat [ytriangs "1 2 3 4 5"]]
-
-  yproc naturals {} {
-      set i 0
-      while {[sum 1]} {
-          yield $i
-          set i [sum $i 1]
-      }
-  }
-  yproc ytriangs_lt n {
-    catch {
-  	  foreach i [naturals] {
-		set x [triang $i]
-		if {$x >= $n} {error RETURN}
-		yield $x
-	  }
-	} what
-  }
-  must [list 0 1 3 6 10 15 21 28 36 45 55 66 78 91] [concat [ytriangs_lt 100]]
-
   proc factorial_with_while n {
   	set z 1
 	while {$n > 0} {
@@ -142,26 +106,6 @@ var cmdTests = `
   must 1 [and [list {expr { 2 < 4}} {expr { 4 < 8}} {expr { 8 < 16}}]]
   must 0 [and [list {expr { 2 < 4}} {expr { 4 > 8}} {expr { 8 < 16}}]]
 
-  list -- Test of "break"
-  proc five {   } {
-  	foreach i [naturals] {
-		if {$i == 5} break
-	}
-	return $i
-  }
-  must 5 [five]
-
-  list -- Test of "continue"
-  proc six {
-  } {
-  	foreach i [naturals] {
-		if {$i < 6} continue
-		break
-	}
-	return $i
-  }
-  must 6 [six]
-
   list -- test of "upvar"
   # dumbCompileSequence can compile this:
   proc UpSet {name x} {
@@ -209,35 +153,6 @@ var cmdTests = `
   hdel $h color
   must "pigs" [hkeys $h]
 
-  proc F s {
-  	return "$s 0"
-  }
-  mixin One {
-      # dumbCompileSequence can compile this:
-      proc mix_number {} { return 1 } ; list -- mixin-local proc.
-
-	  proc F s {
-		return "$s [mix_number] [super F $s]"
-	  }
-  }
-  mixin Two {
-      # dumbCompileSequence can compile this:
-      proc mix_number {} { return 2 } ; list -- mixin-local proc.
-
-	  proc F s {
-		return "$s [mix_number] [super F $s]"
-	  }
-  }
-  mixin Three {
-      # dumbCompileSequence can compile this:
-      proc mix_number {} { return 3 } ; list -- mixin-local proc.
-
-	  proc F s {
-		return "$s [mix_number] [super F $s]"
-	  }
-  }
-  must "foo 3 foo 2 foo 1 foo 0" [F "foo"]
-
   # dumbCompileSequence can compile this:
   proc demo1 { a b c d e } { list $a $b $c $d $e }
 
@@ -278,7 +193,6 @@ var cmdTests = `
   must j [string slice abcdefghij 9 10]
 
   must {{} a b {} c {}} [split /a/b//c/ /]
-  must {a b c} [dropnull [split /a/b/c /]]
   must {/a/b/c d e f} [split "/a/b/c d e f"]
   must {a b c} [join {  a   b   c }]
   must {a:=b:=c} [join {  a   b   c } :=]
@@ -314,24 +228,10 @@ var cmdTests = `
 	must foo $arr(o$arr(z)e)
 	must 1 [catch {list $arr(aaa$arr(z)zzz) } what]
 
-	# destructuring list assignment:
-	set nada,uno,dos,tres [yrange 10]
-	must 0/1/2/3 $nada/$uno/$dos/$tres
-
-	set nada,uno,dos,tres [yrange 2]
-	must 0/1// $nada/$uno/$dos/$tres
-
 	foreach 1,2 [list "10 100" "20 200"] {
 		must $2 [expr $1 * 10]
 	}
 
-	# Propagation of error from yproc to consumer.
-	yproc barfer {} {error BARF}
-	must 1 [catch {concat [barfer]} what]
-	must 1 [string match BARF* $what]
-	must 1 [catch {foreach x [barfer] {error NOTREACHED}} what]
-	must 1 [string match BARF* $what]
-
 	set a 111; set B 222
 
 	must {aaa$a foo[expr 3*8]bar$B.zzz\\0105} [
@@ -360,6 +260,122 @@ func TestFoo(a *testing.T) {
 	LogAllCounters()
 }
 
+// yprocTests use yproc, yield, mixin and dropnull, which this interpreter
+// does not have, and the procs defined by cmdTests.
+var yprocTests = `
+  yproc yrange n {
+      set i 0
+      while {$i<$n} {
+          yield $i
+          set i [sum $i 1]
+      }
+  }
+  must "" [concat [yrange 0]]
+  must "0" [concat [yrange 1]]
+  must "0 1 2 3 4" [concat [yrange 5]]
+
+  yproc ytriangs nums {
+      foreach n $nums {
+          yield [triang $n]
+      }
+  }
+  must "1 3 6 10 15" [concat [ytriangs "1 2 3 4 5"]]
+
+  yproc naturals {} {
+      set i 0
+      while {[sum 1]} {
+          yield $i
+          set i [sum $i 1]
+      }
+  }
+  yproc ytriangs_lt n {
+    catch {
+  	  foreach i [naturals] {
+		set x [triang $i]
+		if {$x >= $n} {error RETURN}
+		yield $x
+	  }
+	} what
+  }
+  must [list 0 1 3 6 10 15 21 28 36 45 55 66 78 91] [concat [ytriangs_lt 100]]
+
+  list -- Test of "break"
+  proc five {   } {
+  	foreach i [naturals] {
+		if {$i == 5} break
+	}
+	return $i
+  }
+  must 5 [five]
+
+  list -- Test of "continue"
+  proc six {
+  } {
+  	foreach i [naturals] {
+		if {$i < 6} continue
+		break
+	}
+	return $i
+  }
+  must 6 [six]
+
+  proc F s {
+  	return "$s 0"
+  }
+  mixin One {
+      # dumbCompileSequence can compile this:
+      proc mix_number {} { return 1 } ; list -- mixin-local proc.
+
+	  proc F s {
+		return "$s [mix_number] [super F $s]"
+	  }
+  }
+  mixin Two {
+      # dumbCompileSequence can compile this:
+      proc mix_number {} { return 2 } ; list -- mixin-local proc.
+
+	  proc F s {
+		return "$s [mix_number] [super F $s]"
+	  }
+  }
+  mixin Three {
+      # dumbCompileSequence can compile this:
+      proc mix_number {} { return 3 } ; list -- mixin-local proc.
+
+	  proc F s {
+		return "$s [mix_number] [super F $s]"
+	  }
+  }
+  must "foo 3 foo 2 foo 1 foo 0" [F "foo"]
+
+  must {a b c} [dropnull [split /a/b/c /]]
+
+	# destructuring list assignment:
+	set nada,uno,dos,tres [yrange 10]
+	must 0/1/2/3 $nada/$uno/$dos/$tres
+
+	set nada,uno,dos,tres [yrange 2]
+	must 0/1// $nada/$uno/$dos/$tres
+
+	# Propagation of error from yproc to consumer.
+	yproc barfer {} {error BARF}
+	must 1 [catch {concat [barfer]} what]
+	must 1 [string match BARF* $what]
+	must 1 [catch {foreach x [barfer] {error NOTREACHED}} what]
+	must 1 [string match BARF* $what]
+`
+
+func TestYproc(a *testing.T) {
+	fr := NewInterpreter()
+	for _, name := range []string{"yproc", "yield", "mixin", "dropnull"} {
+		if _, ok := fr.G.Cmds[name]; !ok {
+			a.Skipf("needs %s, which is not implemented", name)
+		}
+	}
+	fr.Eval(MkString(cmdTests))
+	fr.Eval(MkString(yprocTests))
+}
+
 func TestStringMatchExact(t *testing.T) {
 	// Positive pattern matches.
 	ppats, pstrs := make([]string, 0, 4), make([]string, 0, 4)
@@ -476,3 +492,123 @@ func TestStringMatchBracket(t *testing.T) {
 		}
 	}
 }
+
+var applyTests = `
+	must 7 [apply {{a b} {expr {$a + $b}}} 3 4]
+	must 10 [apply {{a {b 9}} {expr {$a + $b}}} 1]
+	must {1 2 {3 4}} [apply {{a {b 2} args} {list $a $b $args}} 1 2 3 4]
+	must {1 2 {}} [apply {{a {b 2} args} {list $a $b $args}} 1]
+	must {1 5 {}} [apply {{a {b 2} args} {list $a $b $args}} 1 5]
+	must 3 [apply {args {llength $args}} x y z]
+	must 5 [apply {x {return [expr {$x + 1}]} ::} 4]
+	mustfail {apply {{a b} {list $a $b}} 1}
+	mustfail {apply {{a} {list $a}} 1 2}
+	mustfail {apply {{a} {list $a} foo} 1}
+	mustfail {apply {just_one_word} 1}
+
+	# The lambda runs in its own frame, one level below the caller.
+	proc caller {} {
+		set v outer
+		apply {{} {upvar 1 v w; set w inner}}
+		return $v
+	}
+	must inner [caller]
+
+	# A lambda can be a command prefix for callbacks.
+	set double {apply {x {expr {2 * $x}}}}
+	must 8 [{*}$double 4]
+	must 8 [eval $double 4]
+`
+
+func TestApply(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(applyTests))
+}
+
+var arrayTests = `
+	array set a {apple 1 banana 2 cherry 3 avocado 4}
+	must {apple avocado banana cherry} [array names a]
+	must {apple avocado} [array names a a*]
+	must {apple avocado} [array names a -glob a*]
+	must {banana} [array names a -exact banana]
+	must {banana cherry} [array names a -regexp {^[bc]}]
+	must {apple 1 avocado 4} [array get a a*]
+	must 4 [array size a]
+
+	array unset a a*
+	must {banana cherry} [array names a]
+	must 2 [array size a]
+
+	set keys {}
+	set sum 0
+	array for {k v} a {
+		lappend keys $k
+		set sum [expr {$sum + $v}]
+	}
+	must {banana cherry} $keys
+	must 5 $sum
+
+	array for {k v} a { set last $k; break }
+	must banana $last
+
+	set Printed {}
+	proc puts line { lappend Printed $line }
+	parray a
+	must {{a(banana) = 2} {a(cherry) = 3}} $Printed
+
+	array unset a
+	must 0 [array exists a]
+	must {} [array names nosuch]
+
+	# Computed keys, like Tcl.
+	set i 1
+	set j 2
+	set m($i,$j) twelve
+	must twelve $m(1,2)
+	must twelve $m($i,$j)
+	must twelve $m([expr {$j - 1}],$j)
+	set n(key) 1,2
+	must twelve $m($n(key))
+	must "<twelve>" "<$m($i,$j)>"
+	set sp(a\ b) spaced
+	must spaced "$sp(a b)"
+	must 1 [info exists m(1,2)]
+	must 0 [info exists m(2,1)]
+
+	# Element names work in any command that takes a variable name.
+	set c(n) 5
+	incr c(n)
+	append c(s) x y
+	lappend c(l) p q
+	must {6 xy {p q}} [list $c(n) $c(s) $c(l)]
+
+	set {odd name} 7
+	must 7 ${odd name}
+	must 6 ${c(n)}
+	must {$ and $} "$ and $"
+	must {cost: $5} "cost: $[expr 5]"
+
+	unset c(n)
+	must 0 [info exists c(n)]
+	unset i
+	must 0 [info exists i]
+	unset -nocomplain i nosuch
+	mustfail {unset nosuch}
+	mustfail {set m(nope)}
+	mustfail {set j(k)}
+	mustfail {set q $nosuch(k)}
+	must 7 ${odd name}
+
+	proc p1 {} {
+		upvar 1 tgt t
+		unset t
+	}
+	set tgt 1
+	p1
+	must 0 [info exists tgt]
+`
+
+func TestArray(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(arrayTests))
+}
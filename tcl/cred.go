@@ -0,0 +1,134 @@
+package tcl
+
+import (
+	. "fmt"
+	"sort"
+)
+
+// Capabilities.  A Frame's Cred holds the capabilities its code may use,
+// as keys; a nil Cred means unrestricted, which is the default.
+// Commands (or "command subcommand" pairs of ensembles) may require
+// capabilities, registered with RequireCaps in init(), like Safes.
+// Calls to them panic unless the Cred holds all they require.
+//
+// "withcred" narrows the Cred for a block; nothing widens it, since the
+// Cred passes to called procs, and also to frames reached by uplevel,
+// child interpreters, and alias targets, for the duration of the call.
+
+// CmdCaps holds the capabilities required by commands, by command name.
+var CmdCaps map[string][]string
+
+// RequireCaps registers capabilities required by a command.
+// The name may be "command subcommand" for an ensemble.
+func RequireCaps(name string, caps ...string) {
+	if CmdCaps == nil {
+		CmdCaps = make(map[string][]string)
+	}
+	CmdCaps[name] = append(CmdCaps[name], caps...)
+}
+
+// IntersectCred returns the capabilities in both a and b,
+// treating nil as unrestricted.
+func IntersectCred(a, b Hash) Hash {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+	z := make(Hash)
+	for k, v := range a {
+		if _, ok := b[k]; ok {
+			z[k] = v
+		}
+	}
+	return z
+}
+
+// MkCred makes a Cred holding the named capabilities.
+func MkCred(caps ...string) Hash {
+	z := make(Hash)
+	for _, c := range caps {
+		z[c] = True
+	}
+	return z
+}
+
+// CheckCred panics if the frame's Cred lacks a capability the command needs.
+func (fr *Frame) CheckCred(argv []T) {
+	if fr.Cred == nil {
+		return
+	}
+	name := argv[0].String()
+	fr.CheckCaps(name)
+}
+
+// CheckCaps panics if the frame's Cred lacks a capability required by the
+// name, which may be "command subcommand" (as checked by MkEnsemble).
+func (fr *Frame) CheckCaps(name string) {
+	if fr.Cred == nil {
+		return
+	}
+	for _, c := range CmdCaps[name] {
+		if _, ok := fr.Cred[c]; !ok {
+			panic(Sprintf("permission denied: %q requires capability %q", name, c))
+		}
+	}
+}
+
+// WithCred calls fn with the target frame's Cred narrowed by cred,
+// restoring it afterwards.
+func (target *Frame) WithCred(cred Hash, fn func() T) T {
+	if cred == nil {
+		return fn()
+	}
+	saved := target.Cred
+	target.Cred = IntersectCred(saved, cred)
+	defer func() { target.Cred = saved }()
+	return fn()
+}
+
+// credNames returns the sorted names in the Cred.
+func credNames(cred Hash) []string {
+	var z []string
+	for k := range cred {
+		z = append(z, k)
+	}
+	sort.Strings(z)
+	return z
+}
+
+// cmdWithCred evaluates the body with only those of the current
+// capabilities that are also in the list.
+func cmdWithCred(fr *Frame, argv []T) T {
+	capsT, body := Arg2(argv)
+	var caps []string
+	for _, c := range capsT.List() {
+		caps = append(caps, c.String())
+	}
+	return fr.WithCred(MkCred(caps...), func() T {
+		return fr.Eval(body)
+	})
+}
+
+// cmdInfoCred returns the current capabilities, or "*" if unrestricted.
+func cmdInfoCred(fr *Frame, argv []T) T {
+	Arg0(argv)
+	if fr.Cred == nil {
+		return MkString("*")
+	}
+	var zz []T
+	for _, c := range credNames(fr.Cred) {
+		zz = append(zz, MkString(c))
+	}
+	return MkList(zz)
+}
+
+func init() {
+	if Safes == nil {
+		Safes = make(map[string]Command, 333)
+	}
+
+	Safes["withcred"] = cmdWithCred
+	RequireCaps("interp", "interp")
+}
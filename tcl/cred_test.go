@@ -0,0 +1,90 @@
+package tcl
+
+import (
+	"testing"
+)
+
+var credTests = `
+  must * [info cred]
+  must {a b} [withcred {a b} {info cred}]
+  must {b} [withcred {a b} {withcred {b c} {info cred}}]
+  must * [info cred]
+
+  proc show {} {info cred}
+  must {x} [withcred {x} {show}]
+  must {x} [withcred {x} {uplevel #0 {info cred}}]
+  must {x} [withcred {x} {apply {{} {info cred}}}]
+
+  must 3 [withcred {} {expr 1 + 2}]
+  mustfail {withcred {} {guarded}}
+  must ok [withcred {guard} {guarded}]
+  mustfail {withcred {guard} {withcred {} {guarded}}}
+  mustfail {withcred {} {ens sub}}
+  mustfail {withcred {} {ens s}}
+  must ok [withcred {} {ens other}]
+  must ok [withcred {guard} {ens sub}]
+
+  set Ran {}
+  withcred {} { after 0 {lappend Ran [catch guarded m]} }
+  withcred {} { after idle {lappend Ran [catch guarded m]} }
+  withcred {guard} { after 0 {lappend Ran [catch guarded m]} }
+  update
+  must {1 0 1} $Ran
+  proc ran {t} { lappend Ran [catch guarded m] }
+  set Ran {}
+  withcred {} { go -command ran list }
+  vwait Ran
+  must {1} $Ran
+  after 0 {set Ran [catch guarded m]}
+  update
+  must 0 $Ran
+
+  interp create kid
+  mustfail {withcred {} {interp eval kid {set x 1}}}
+  mustfail {withcred {} {kid eval {set x 1}}}
+  must {x} [withcred {x interp} {kid eval {withcred {x y} {info cred}}}]
+  must * [kid eval {info cred}]
+`
+
+func TestCred(a *testing.T) {
+	RequireCaps("guarded", "guard")
+	RequireCaps("ens sub", "guard")
+	defer delete(CmdCaps, "guarded")
+	defer delete(CmdCaps, "ens sub")
+
+	fr := NewInterpreter()
+	fr.G.Cmds["guarded"] = &CmdNode{Fn: func(fr *Frame, argv []T) T { return MkString("ok") }}
+	fr.G.Cmds["ens"] = &CmdNode{Fn: MkEnsemble([]EnsembleItem{
+		{Name: "sub", Cmd: func(fr *Frame, argv []T) T { return MkString("ok") }},
+		{Name: "other", Cmd: func(fr *Frame, argv []T) T { return MkString("ok") }},
+	})}
+	fr.Eval(MkString(credTests))
+}
+
+var hookCredTests = `
+  withcred {} { proc unknown {args} { return [catch guarded m] } }
+  must 1 [nosuch]
+
+  withcred {} { package ifneeded hooked 1.0 {set Hooked [catch guarded m]; package provide hooked 1.0} }
+  package require hooked
+  must 1 $Hooked
+  package ifneeded open 1.0 {set Opened [catch guarded m]; package provide open 1.0}
+  withcred {} { package require open }
+  must 1 $Opened
+
+  proc asked {args} { set Asked [catch guarded m] }
+  withcred {} { package unknown asked }
+  catch {package require nosuchpkg} m
+  must 1 $Asked
+`
+
+// TestHookCred checks that hooks run with no more capabilities than the
+// frame that registered them.
+func TestHookCred(a *testing.T) {
+	RequireCaps("guarded", "guard")
+	defer delete(CmdCaps, "guarded")
+
+	fr := NewInterpreter()
+	fr.G.Cmds["guarded"] = &CmdNode{Fn: func(fr *Frame, argv []T) T { return MkString("ok") }}
+	fr.Eval(MkString(hookCredTests))
+}
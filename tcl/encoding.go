@@ -0,0 +1,215 @@
+package tcl
+
+import (
+	. "fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Strings in this interpreter are Go strings, normally holding UTF-8.
+// "encoding convertto" produces a string of raw bytes in the named encoding
+// (as used by "binary" and by file channels), and "encoding convertfrom"
+// turns such bytes back into characters.
+
+type encoder func(s string) string
+type decoder func(data string) string
+
+type encodingPair struct {
+	to   encoder
+	from decoder
+}
+
+var encodings = map[string]encodingPair{
+	"utf-8":     {toUtf8, fromUtf8},
+	"iso8859-1": {toIso8859_1, fromIso8859_1},
+	"ascii":     {toAscii, fromAscii},
+	"utf-16":    {toUtf16LE, fromUtf16},
+	"unicode":   {toUtf16LE, fromUtf16},
+	"utf-16le":  {toUtf16LE, fromUtf16LE},
+	"utf-16be":  {toUtf16BE, fromUtf16BE},
+}
+
+// SystemEncoding is the encoding used when none is given.
+const SystemEncoding = "utf-8"
+
+func findEncoding(name string) encodingPair {
+	e, ok := encodings[strings.ToLower(name)]
+	if !ok {
+		panic(Sprintf("unknown encoding %q", name))
+	}
+	return e
+}
+
+// ConvertTo encodes the characters of s as raw bytes in the named encoding.
+func ConvertTo(enc, s string) string {
+	return findEncoding(enc).to(s)
+}
+
+// ConvertFrom decodes raw bytes in the named encoding into characters.
+func ConvertFrom(enc, data string) string {
+	return findEncoding(enc).from(data)
+}
+
+func toUtf8(s string) string {
+	return strings.ToValidUTF8(s, "\uFFFD")
+}
+
+// fromUtf8 keeps valid sequences, and treats each invalid byte as iso8859-1.
+func fromUtf8(data string) string {
+	if utf8.ValidString(data) {
+		return data
+	}
+	var buf strings.Builder
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRuneInString(data[i:])
+		if r == utf8.RuneError && size == 1 {
+			r = rune(data[i])
+		}
+		buf.WriteRune(r)
+		i += size
+	}
+	return buf.String()
+}
+
+func toSingleBytes(s string, max rune) string {
+	buf := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > max {
+			r = '?'
+		}
+		buf = append(buf, byte(r))
+	}
+	return string(buf)
+}
+
+func fromSingleBytes(data string, max byte) string {
+	if IsASCII(data) {
+		return data
+	}
+	var buf strings.Builder
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if c > max {
+			buf.WriteRune(utf8.RuneError)
+		} else {
+			buf.WriteRune(rune(c))
+		}
+	}
+	return buf.String()
+}
+
+func toIso8859_1(s string) string   { return toSingleBytes(s, 0xFF) }
+func fromIso8859_1(d string) string { return fromSingleBytes(d, 0xFF) }
+func toAscii(s string) string       { return toSingleBytes(s, 0x7F) }
+func fromAscii(d string) string     { return fromSingleBytes(d, 0x7F) }
+
+func toUtf16(s string, bigEndian bool) string {
+	buf := make([]byte, 0, 2*len(s))
+	put := func(u rune) {
+		if bigEndian {
+			buf = append(buf, byte(u>>8), byte(u))
+		} else {
+			buf = append(buf, byte(u), byte(u>>8))
+		}
+	}
+	for _, r := range s {
+		if r >= 0x10000 {
+			r -= 0x10000
+			put(0xD800 + (r>>10)&0x3FF)
+			put(0xDC00 + r&0x3FF)
+		} else {
+			put(r)
+		}
+	}
+	return string(buf)
+}
+
+func fromUtf16Endian(data string, bigEndian bool) string {
+	var buf strings.Builder
+	n := len(data) &^ 1 // An odd last byte is dropped.
+	get := func(i int) rune {
+		if bigEndian {
+			return rune(data[i])<<8 | rune(data[i+1])
+		}
+		return rune(data[i]) | rune(data[i+1])<<8
+	}
+	for i := 0; i < n; i += 2 {
+		u := get(i)
+		if 0xD800 <= u && u < 0xDC00 && i+2 < n {
+			if v := get(i + 2); 0xDC00 <= v && v < 0xE000 {
+				u = 0x10000 + (u-0xD800)<<10 + (v - 0xDC00)
+				i += 2
+			}
+		}
+		buf.WriteRune(u) // Unpaired surrogates become RuneError.
+	}
+	return buf.String()
+}
+
+func toUtf16LE(s string) string   { return toUtf16(s, false) }
+func toUtf16BE(s string) string   { return toUtf16(s, true) }
+func fromUtf16LE(d string) string { return fromUtf16Endian(d, false) }
+func fromUtf16BE(d string) string { return fromUtf16Endian(d, true) }
+
+// fromUtf16 honors a byte order mark, defaulting to little endian.
+func fromUtf16(d string) string {
+	if strings.HasPrefix(d, "\xFE\xFF") {
+		return fromUtf16Endian(d[2:], true)
+	}
+	if strings.HasPrefix(d, "\xFF\xFE") {
+		return fromUtf16Endian(d[2:], false)
+	}
+	return fromUtf16Endian(d, false)
+}
+
+var encodingEnsemble = []EnsembleItem{
+	EnsembleItem{Name: "convertto", Cmd: cmdEncodingConvertTo, Doc: "?encoding? string"},
+	EnsembleItem{Name: "convertfrom", Cmd: cmdEncodingConvertFrom, Doc: "?encoding? data"},
+	EnsembleItem{Name: "names", Cmd: cmdEncodingNames},
+	EnsembleItem{Name: "system", Cmd: cmdEncodingSystem},
+}
+
+// encodingArgs takes "?encoding? string", returning both.
+func encodingArgs(argv []T) (string, string) {
+	switch len(argv) {
+	case 2:
+		return SystemEncoding, argv[1].String()
+	case 3:
+		return argv[1].String(), argv[2].String()
+	}
+	panic(Sprintf("Expected ?encoding? string, but got argv=%s", Showv(argv)))
+}
+
+func cmdEncodingConvertTo(fr *Frame, argv []T) T {
+	enc, s := encodingArgs(argv)
+	return MkString(ConvertTo(enc, s))
+}
+
+func cmdEncodingConvertFrom(fr *Frame, argv []T) T {
+	enc, data := encodingArgs(argv)
+	return MkString(ConvertFrom(enc, data))
+}
+
+func cmdEncodingNames(fr *Frame, argv []T) T {
+	Arg0(argv)
+	var names []string
+	for k := range encodings {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return MkStringList(names)
+}
+
+func cmdEncodingSystem(fr *Frame, argv []T) T {
+	Arg0(argv)
+	return MkString(SystemEncoding)
+}
+
+func init() {
+	if Safes == nil {
+		Safes = make(map[string]Command, 333)
+	}
+
+	Safes["encoding"] = MkEnsemble(encodingEnsemble)
+}
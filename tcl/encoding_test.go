@@ -0,0 +1,44 @@
+package tcl
+
+import (
+	"testing"
+)
+
+var unicodeTests = `
+	set s "日本語 text"
+	must 8 [string length $s]
+	must 14 [string bytelength $s]
+	must 本 [string index $s 1]
+	must 語 [string range $s 2 2]
+	must "text" [string range $s 4 end]
+	must 4 [string first text $s]
+	must 1 [string match "日*語 ?ext" $s]
+	must 1 [string match {[日月]本*} $s]
+	must {日 語} [split "日本語" 本]
+
+	must A "\x41"
+	must "é" "\xe9"
+	must "日" "\u65e5"
+	must "😀" "\U0001F600"
+	must "x" "\x"
+	must "ABC" "\101\102\x43"
+	must 1 [string length "\u65E5"]
+	must 日 [lindex {a \u65e5 b} 1]
+
+	must 3 [string bytelength [encoding convertto utf-8 "日"]]
+	must 日本 [encoding convertfrom utf-8 [encoding convertto utf-8 日本]]
+	must 1 [string bytelength [encoding convertto iso8859-1 "é"]]
+	must "é" [encoding convertfrom iso8859-1 [encoding convertto iso8859-1 "é"]]
+	must "a?" [encoding convertto ascii "aé"]
+	must 4 [string bytelength [encoding convertto utf-16 "日A"]]
+	must "日A😀" [encoding convertfrom utf-16 [encoding convertto utf-16 "日A😀"]]
+	must "日A" [encoding convertfrom utf-16be [encoding convertto utf-16be "日A"]]
+	must "日本語" [encoding convertfrom [encoding convertto "日本語"]]
+	must utf-8 [encoding system]
+	mustfail {encoding convertto klingon abc}
+`
+
+func TestUnicode(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(unicodeTests))
+}
@@ -0,0 +1,128 @@
+package tcl
+
+import (
+	. "fmt"
+	"os"
+	"strings"
+)
+
+// The env array mirrors the environment of the process: reading an
+// element calls os.Getenv, and setting or unsetting one calls os.Setenv
+// or os.Unsetenv, so programs started by exec or open "|cmd" see the
+// changes.  Env is another name for it.  Changing it, or replacing or
+// unsetting the whole array, requires the "env" capability.  Safe
+// interpreters see only the names in SafeEnvNames, and cannot change them.
+
+// HashMirror holds the elements of an array somewhere else.
+// Store and Delete get the frame making the change, which is nil
+// when Go code changes the array with PutAt.  Drop is called before
+// the variable holding the array is set to something else or unset,
+// and may panic to refuse.
+type HashMirror interface {
+	Load() Hash                           // All the elements.
+	Lookup(key string) T                  // One element, or nil.
+	Store(fr *Frame, key string, value T) // Sets an element.
+	Delete(fr *Frame, key string)         // Unsets an element.
+	Drop(fr *Frame, name string)          // Before the array goes away.
+}
+
+// MkMirroredHash makes an array whose elements are kept by the mirror.
+func MkMirroredHash(m HashMirror) T {
+	MkHashCounter.Incr()
+	return &terpHash{h: m.Load(), m: m}
+}
+
+// IsMirroredHash tells if the value is an array made by MkMirroredHash.
+func IsMirroredHash(t T) bool {
+	h, ok := t.(*terpHash)
+	return ok && h.m != nil
+}
+
+// SafeEnvNames are the environment variables a safe interpreter may read.
+var SafeEnvNames = map[string]bool{
+	"LANG":     true,
+	"LC_ALL":   true,
+	"LC_CTYPE": true,
+	"TERM":     true,
+	"TZ":       true,
+}
+
+// envMirror is the HashMirror of the env array.
+type envMirror struct {
+	safe bool
+}
+
+func (e envMirror) visible(key string) bool {
+	return !e.safe || SafeEnvNames[key]
+}
+
+func (e envMirror) Load() Hash {
+	h := make(Hash)
+	for _, s := range os.Environ() {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) == 2 && kv[0] != "" && e.visible(kv[0]) {
+			h[kv[0]] = MkString(kv[1])
+		}
+	}
+	return h
+}
+
+func (e envMirror) Lookup(key string) T {
+	if !e.visible(key) {
+		return nil
+	}
+	if v, ok := os.LookupEnv(key); ok {
+		return MkString(v)
+	}
+	return nil
+}
+
+func (e envMirror) Store(fr *Frame, key string, value T) {
+	if e.safe {
+		panic(Sprintf("can't set \"env(%s)\": env is read-only in a safe interpreter", key))
+	}
+	if fr != nil {
+		fr.CheckCaps("env")
+	}
+	if err := os.Setenv(key, value.String()); err != nil {
+		panic(Sprintf("can't set \"env(%s)\": %s", key, err.Error()))
+	}
+}
+
+func (e envMirror) Delete(fr *Frame, key string) {
+	if e.safe {
+		panic(Sprintf("can't unset \"env(%s)\": env is read-only in a safe interpreter", key))
+	}
+	if fr != nil {
+		fr.CheckCaps("env")
+	}
+	os.Unsetenv(key)
+}
+
+func (e envMirror) Drop(fr *Frame, name string) {
+	if e.safe {
+		panic(Sprintf("can't set or unset %q: env is read-only in a safe interpreter", name))
+	}
+	fr.CheckCaps("env")
+}
+
+// dropMirror lets the mirror of an array refuse to have the variable
+// at loc, called name, set to something else or unset.
+func (fr *Frame) dropMirror(loc Loc, name string) {
+	if loc == nil || !loc.Has() {
+		return
+	}
+	if h, ok := loc.Get().(*terpHash); ok && h.m != nil {
+		h.m.Drop(fr, name)
+	}
+}
+
+// initEnv makes the env array, and Env as another name for it.
+func (g *Global) initEnv() {
+	g.Fr.Vars["env"] = &Slot{Elem: MkMirroredHash(envMirror{safe: g.IsSafe})}
+	g.Fr.Vars["Env"] = &Slot{Up: &UpSlot{Fr: &g.Fr, RemoteName: "env"}}
+}
+
+func init() {
+	RequireCaps("env", "env")
+}
@@ -0,0 +1,70 @@
+package tcl
+
+import (
+	"os"
+	"testing"
+)
+
+var envTests = `
+  must /test/home $env(HOME)
+  must /test/home $Env(HOME)
+  must 0 [info exists env(TCL67_ENV_TEST)]
+  set env(TCL67_ENV_TEST) one
+  must one $Env(TCL67_ENV_TEST)
+  must one [go_getenv TCL67_ENV_TEST]
+  proc get {} {
+    global env
+    return $env(TCL67_ENV_TEST)
+  }
+  must one [get]
+  array set env {TCL67_ENV_TEST two}
+  must two [go_getenv TCL67_ENV_TEST]
+  must TCL67_ENV_TEST [array names env TCL67_ENV_*]
+  mustfail {withcred {} {set env(TCL67_ENV_TEST) three}}
+  mustfail {withcred {exec} {array set env {TCL67_ENV_TEST three}}}
+  mustfail {withcred {} {unset env(TCL67_ENV_TEST)}}
+  must two [withcred {} {set env(TCL67_ENV_TEST)}]
+  must three [withcred {env} {set env(TCL67_ENV_TEST) three}]
+  must three [go_getenv TCL67_ENV_TEST]
+  unset env(TCL67_ENV_TEST)
+  must 0 [info exists env(TCL67_ENV_TEST)]
+  must {} [go_getenv TCL67_ENV_TEST]
+  array unset env TCL67_*
+
+  mustfail {withcred {} {unset env}}
+  mustfail {withcred {} {array unset env}}
+  mustfail {withcred {exec} {set Env 1}}
+  proc clobber {} {
+    global env
+    set env 1
+  }
+  mustfail {withcred {} clobber}
+  must /test/home $env(HOME)
+  set k [interp create]
+  must 0 [interp eval $k {unset env; info exists env}]
+
+  set s [interp create -safe]
+  must C [interp eval $s {set env(LANG)}]
+  must 0 [interp eval $s {info exists env(HOME)}]
+  must {LANG} [interp eval $s {array names env}]
+  mustfail {interp eval $s {set env(LANG) fr}}
+  mustfail {interp eval $s {unset env(LANG)}}
+  mustfail {interp eval $s {unset env}}
+  mustfail {interp eval $s {set Env 1}}
+  must C [interp eval $s {set env(LANG)}]
+  must C $env(LANG)
+`
+
+func TestEnv(a *testing.T) {
+	a.Setenv("HOME", "/test/home")
+	a.Setenv("LANG", "C")
+	for _, k := range []string{"LC_ALL", "LC_CTYPE", "TERM", "TZ"} {
+		a.Setenv(k, "") // Restored after the test.
+		os.Unsetenv(k)
+	}
+	fr := NewInterpreter()
+	fr.G.Cmds["go_getenv"] = &CmdNode{Fn: func(fr *Frame, argv []T) T {
+		return MkString(os.Getenv(Arg1(argv).String()))
+	}}
+	fr.Eval(MkString(envTests))
+}
@@ -0,0 +1,423 @@
+package tcl
+
+import (
+	. "fmt"
+	"log"
+	R "reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event loop.  Each interpreter has an EventLoop holding timers made by
+// "after", idle scripts made by "after idle", and Events posted by other
+// goroutines (such as finished "go -command" tasks, and channel events).
+// Events run only in the interpreter's own goroutine, when it calls
+// DoOneEvent, as "vwait" and "update" do.
+//
+// A goroutine that will post later calls Hold first, and Release when
+// done, so "vwait" knows whether anything can still happen.
+//
+// Scripts run with the Cred of the frame that scheduled them, so
+// "withcred" also limits what its timers and callbacks may do.
+
+// Event is a function run by the event loop in the interpreter's goroutine.
+type Event func(fr *Frame)
+
+type timerEvent struct {
+	ID     string
+	When   time.Time // Zero for idle.
+	Script T
+	Cred   Hash // Of the frame that scheduled it.
+}
+
+// EventLoop is the queue of events of an interpreter.
+type EventLoop struct {
+	mu     sync.Mutex
+	posted []Event
+	timers []*timerEvent // Sorted by When.
+	idle   []*timerEvent
+	holds  int
+	n      int
+	wake   chan struct{}
+}
+
+// NewEventLoop makes an empty EventLoop.
+func NewEventLoop() *EventLoop {
+	return &EventLoop{wake: make(chan struct{}, 1)}
+}
+
+// Post adds an event to the queue.  It may be called from any goroutine.
+func (el *EventLoop) Post(e Event) {
+	el.mu.Lock()
+	el.posted = append(el.posted, e)
+	el.mu.Unlock()
+	el.signal()
+}
+
+// PostWithCred adds an event to the queue, to run with the frame's Cred
+// narrowed by cred, which is normally the Cred of the frame that
+// arranged for the event.  It may be called from any goroutine.
+func (el *EventLoop) PostWithCred(cred Hash, e Event) {
+	el.Post(func(fr *Frame) {
+		fr.WithCred(cred, func() T {
+			e(fr)
+			return Empty
+		})
+	})
+}
+
+// Hold tells the loop that an event may be posted later.
+func (el *EventLoop) Hold() {
+	el.mu.Lock()
+	el.holds++
+	el.mu.Unlock()
+}
+
+// Release undoes a Hold.
+func (el *EventLoop) Release() {
+	el.mu.Lock()
+	el.holds--
+	el.mu.Unlock()
+	el.signal()
+}
+
+func (el *EventLoop) signal() {
+	select {
+	case el.wake <- struct{}{}:
+	default:
+	}
+}
+
+// After schedules the script to run after the duration, with the Cred,
+// returning its id.
+func (el *EventLoop) After(d time.Duration, script T, cred Hash) string {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	el.n++
+	te := &timerEvent{ID: Sprintf("after#%d", el.n), When: time.Now().Add(d), Script: script, Cred: cred}
+	i := sort.Search(len(el.timers), func(i int) bool { return el.timers[i].When.After(te.When) })
+	el.timers = append(el.timers, nil)
+	copy(el.timers[i+1:], el.timers[i:])
+	el.timers[i] = te
+	return te.ID
+}
+
+// AfterIdle schedules the script to run, with the Cred, when no other
+// events are ready.
+func (el *EventLoop) AfterIdle(script T, cred Hash) string {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	el.n++
+	te := &timerEvent{ID: Sprintf("after#%d", el.n), Script: script, Cred: cred}
+	el.idle = append(el.idle, te)
+	return te.ID
+}
+
+// Cancel removes the timer or idle script with the id, or else
+// the first one with the script.  It returns false if there was none.
+func (el *EventLoop) Cancel(idOrScript string) bool {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	for _, byID := range []bool{true, false} {
+		for _, list := range []*[]*timerEvent{&el.timers, &el.idle} {
+			for i, te := range *list {
+				if byID && te.ID == idOrScript || !byID && te.Script.String() == idOrScript {
+					*list = append((*list)[:i], (*list)[i+1:]...)
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Info returns the ids of the pending timers and idle scripts.
+func (el *EventLoop) Info() []string {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	var z []string
+	for _, te := range el.timers {
+		z = append(z, te.ID)
+	}
+	for _, te := range el.idle {
+		z = append(z, te.ID)
+	}
+	return z
+}
+
+func (el *EventLoop) lookup(id string) *timerEvent {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	for _, list := range [][]*timerEvent{el.timers, el.idle} {
+		for _, te := range list {
+			if te.ID == id {
+				return te
+			}
+		}
+	}
+	return nil
+}
+
+// next removes and returns the next ready event, or returns nil.
+// If nothing is ready, it also returns how long until the next timer
+// (or -1 if none), and whether anything can still become ready.
+func (el *EventLoop) next(idleOK bool) (e Event, wait time.Duration, live bool) {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	if len(el.posted) > 0 {
+		e = el.posted[0]
+		el.posted = el.posted[1:]
+		return e, 0, true
+	}
+	wait = -1
+	if len(el.timers) > 0 {
+		te := el.timers[0]
+		wait = time.Until(te.When)
+		if wait <= 0 {
+			el.timers = el.timers[1:]
+			return te.run, 0, true
+		}
+	}
+	if idleOK && len(el.idle) > 0 {
+		te := el.idle[0]
+		el.idle = el.idle[1:]
+		return te.run, 0, true
+	}
+	return nil, wait, wait >= 0 || el.holds > 0
+}
+
+func (te *timerEvent) run(fr *Frame) {
+	fr.WithCred(te.Cred, func() T {
+		return fr.Eval(te.Script)
+	})
+}
+
+// DoOneEvent runs one ready event in the global frame, returning true.
+// If none is ready and block is set, it waits for one, unless nothing
+// can become ready, when it returns false.
+func (fr *Frame) DoOneEvent(block bool, idleOK bool) bool {
+	g := fr.G
+	for {
+		e, wait, live := g.Events.next(idleOK)
+		if e != nil {
+			g.runEvent(e)
+			return true
+		}
+		if !block || !live {
+			return false
+		}
+		cases := []R.SelectCase{{Dir: R.SelectRecv, Chan: R.ValueOf(g.Events.wake)}}
+		if wait >= 0 {
+			cases = append(cases, R.SelectCase{Dir: R.SelectRecv, Chan: R.ValueOf(time.After(wait))})
+		}
+		fr.selectLimited(cases)
+	}
+}
+
+// runEvent runs the event in the global frame, reporting errors with
+// the "bgerror" command, if there is one, or else to the log.
+func (g *Global) runEvent(e Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			if IsLimitExceeded(r) {
+				panic(r)
+			}
+			if j, ok := r.(Jump); ok && j.Status == RETURN {
+				return
+			}
+			g.backgroundError(Sprintf("%v", r))
+		}
+	}()
+	e(&g.Fr)
+}
+
+func (g *Global) backgroundError(msg string) {
+	if node, ok := g.Cmds["bgerror"]; ok {
+		defer func() {
+			if r := recover(); r != nil {
+				if IsLimitExceeded(r) {
+					panic(r)
+				}
+				log.Printf("error in bgerror: %v", r)
+			}
+		}()
+		node.Fn(&g.Fr, []T{MkString("bgerror"), MkString(msg)})
+		return
+	}
+	log.Printf("background error: %s", msg)
+}
+
+// Update runs the events that are ready, without waiting.
+func (fr *Frame) Update() {
+	for fr.DoOneEvent(false, true) {
+	}
+}
+
+// watchLoc wraps a variable's Loc, noting when it is set or unset, for vwait.
+type watchLoc struct {
+	Loc
+	written bool
+}
+
+func (w *watchLoc) Set(t T) {
+	w.Loc.Set(t)
+	w.written = true
+}
+
+// VWait runs events until the global variable is set.  For an element,
+// like "a(x)", that is when the element is set or unset, or the whole
+// array is set.
+func (fr *Frame) VWait(name string) {
+	varName := name
+	arr, key, isElem := SplitArrayName(name)
+	if isElem {
+		varName = arr
+	}
+	g := &fr.G.Fr
+	if g.Vars == nil {
+		g.Vars = make(Scope)
+	}
+	loc, ok := g.Vars[varName]
+	if !ok {
+		loc = new(Slot)
+	}
+	w := &watchLoc{Loc: loc}
+	g.Vars[varName] = w
+	defer func() {
+		if g.Vars[varName] == w {
+			g.Vars[varName] = loc
+			if !ok && !loc.Has() {
+				delete(g.Vars, varName)
+			}
+		}
+	}()
+	elemWritten := false
+	if isElem && loc.Has() {
+		if h, ok := loc.Get().(*terpHash); ok {
+			if h.watched == nil {
+				h.watched = make(map[string]*bool)
+			}
+			saved, had := h.watched[key]
+			h.watched[key] = &elemWritten
+			defer func() {
+				if had {
+					*saved = *saved || elemWritten
+					h.watched[key] = saved // An outer vwait of the same element.
+				} else {
+					delete(h.watched, key)
+				}
+			}()
+		}
+	}
+	for !w.written && !elemWritten {
+		if !fr.DoOneEvent(true, true) {
+			panic(Sprintf("vwait: can't wait for variable %q: would wait forever", name))
+		}
+	}
+}
+
+// cmdAfter implements
+//
+//	after ms                    sleeps
+//	after ms script ?script...? schedules the concatenated scripts
+//	after idle script ?script...?
+//	after cancel id|script
+//	after info ?id?
+func cmdAfter(fr *Frame, argv []T) T {
+	if len(argv) < 2 {
+		panic("usage: after ms ?script ...? | after idle|cancel|info ...")
+	}
+	el := fr.G.Events
+	concat := func(tt []T) T {
+		var ss []string
+		for _, t := range tt {
+			ss = append(ss, strings.TrimSpace(t.String()))
+		}
+		return MkString(strings.Join(ss, " "))
+	}
+	switch argv[1].String() {
+	case "idle":
+		if len(argv) < 3 {
+			panic("usage: after idle script ?script ...?")
+		}
+		return MkString(el.AfterIdle(concat(argv[2:]), fr.Cred))
+	case "cancel":
+		if len(argv) < 3 {
+			panic("usage: after cancel id|script")
+		}
+		el.Cancel(concat(argv[2:]).String())
+		return Empty
+	case "info":
+		switch len(argv) {
+		case 2:
+			var zz []T
+			for _, id := range el.Info() {
+				zz = append(zz, MkString(id))
+			}
+			return MkList(zz)
+		case 3:
+			te := el.lookup(argv[2].String())
+			if te == nil {
+				panic(Sprintf("event %q doesn't exist", argv[2].String()))
+			}
+			kind := "timer"
+			if te.When.IsZero() {
+				kind = "idle"
+			}
+			return MkList([]T{te.Script, MkString(kind)})
+		}
+		panic("usage: after info ?id?")
+	}
+	ms := argv[1].Float()
+	d := time.Duration(ms * float64(time.Millisecond))
+	if len(argv) == 2 {
+		fr.selectLimited([]R.SelectCase{{Dir: R.SelectRecv, Chan: R.ValueOf(time.After(d))}})
+		return Empty
+	}
+	return MkString(el.After(d, concat(argv[2:]), fr.Cred))
+}
+
+func cmdVWait(fr *Frame, argv []T) T {
+	name := Arg1(argv)
+	fr.VWait(name.String())
+	return Empty
+}
+
+// cmdUpdate runs ready events; with "idletasks", only idle scripts.
+func cmdUpdate(fr *Frame, argv []T) T {
+	switch len(argv) {
+	case 1:
+		fr.Update()
+	case 2:
+		if argv[1].String() != "idletasks" {
+			panic("usage: update ?idletasks?")
+		}
+		el := fr.G.Events
+		for {
+			el.mu.Lock()
+			if len(el.idle) == 0 {
+				el.mu.Unlock()
+				break
+			}
+			te := el.idle[0]
+			el.idle = el.idle[1:]
+			el.mu.Unlock()
+			fr.G.runEvent(te.run)
+		}
+	default:
+		panic("usage: update ?idletasks?")
+	}
+	return Empty
+}
+
+func init() {
+	if Safes == nil {
+		Safes = make(map[string]Command, 333)
+	}
+
+	Safes["after"] = cmdAfter
+	Safes["vwait"] = cmdVWait
+	Safes["update"] = cmdUpdate
+}
@@ -0,0 +1,74 @@
+package tcl
+
+import (
+	"testing"
+)
+
+var eventTests = `
+  set Log {}
+  after 30 {lappend Log thirty}
+  after 10 lappend Log ten
+  after idle {lappend Log idle}
+  set id [after 20 {lappend Log twenty}]
+  must {{lappend Log twenty} timer} [after info $id]
+  after cancel $id
+  after 40 {set Done 1}
+  must 4 [llength [after info]]
+  vwait Done
+  must {idle ten thirty} $Log
+
+  set Log {}
+  after 0 {lappend Log zero}
+  after idle {lappend Log idle}
+  after cancel {lappend Log idle}
+  update
+  must {zero} $Log
+  after idle {lappend Log idle2}
+  update idletasks
+  must {zero idle2} $Log
+  must {} [after info]
+
+  after 0 {set a(x) 1}
+  vwait a(x)
+  must 1 $a(x)
+  after 0 {set a(y) 2}
+  after 5 {set a(x) 3}
+  vwait a(x)
+  must {3 2} [list $a(x) $a(y)]
+  after 0 {unset a(x)}
+  vwait a(x)
+  must 0 [info exists a(x)]
+  mustfail {vwait a(never)}
+
+  mustfail {vwait Never}
+  mustfail {after info nosuch}
+  after 1
+
+  proc square {x} { expr $x * $x }
+  proc finished {task} {
+    lappend Finished [wait $task]
+  }
+  set Finished {}
+  go -command finished square 6
+  go -command finished square 7
+  while {[llength $Finished] < 2} {
+    vwait Finished
+  }
+  must {36 49} [lsort $Finished]
+  mustfail {go -command finished}
+  proc noted {task} { set Noted $task }
+  set t [go -command noted square 8]
+  vwait Noted
+  must $t $Noted
+  mustfail {wait $t}
+
+  proc bgerror {msg} { set BgError $msg }
+  after 0 {error oops}
+  vwait BgError
+  must 1 [string match "oops*" $BgError]
+`
+
+func TestEvent(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(eventTests))
+}
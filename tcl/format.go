@@ -0,0 +1,669 @@
+package tcl
+
+import (
+	. "fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Format and scan follow Tcl's own specifiers, not Go's.
+// Integers in this interpreter are 64 bits, so a missing size modifier
+// means the same as "l" or "ll"; only "h" truncates (to 16 bits).
+
+const errFormatNotEnough = "not enough arguments for all format specifiers"
+const errFormatEnded = "format string ended in middle of field specifier"
+const errFormatMixed = `cannot mix "%" and "%n$" conversion specifiers`
+const errFormatIndex = `"%n$" argument index out of range`
+
+// FormatInt gets an integer argument for format, with Tcl's error message.
+func FormatInt(t T) int64 {
+	if t.IsQuickInt() {
+		return t.Int()
+	}
+	s := strings.TrimSpace(t.String())
+	if z, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return z
+	}
+	if z, err := strconv.ParseUint(s, 0, 64); err == nil {
+		return int64(z) // Allow 0xFFFFFFFFFFFFFFFF for -1.
+	}
+	panic(Sprintf("expected integer but got %q", t.String()))
+}
+
+// FormatFloat gets a floating point argument for format, with Tcl's error message.
+func FormatFloat(t T) float64 {
+	if t.IsQuickNumber() {
+		return t.Float()
+	}
+	s := strings.TrimSpace(t.String())
+	if z, err := strconv.ParseFloat(s, 64); err == nil {
+		return z
+	}
+	if z, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return float64(z)
+	}
+	panic(Sprintf("expected floating-point number but got %q", t.String()))
+}
+
+func isDigitByte(c byte) bool { return '0' <= c && c <= '9' }
+
+// FormatArgs formats the args like Tcl's "format" command.
+func FormatArgs(f string, args []T) string {
+	var buf strings.Builder
+	argi := 0 // Index of the next argument.
+	xpg := 0  // 0 until decided, then 1 for sequential or 2 for XPG3 "%n$" positions.
+	next := func() T {
+		if argi >= len(args) {
+			if xpg == 2 {
+				panic(errFormatIndex)
+			}
+			panic(errFormatNotEnough)
+		}
+		argi++
+		return args[argi-1]
+	}
+
+	i := 0
+	for i < len(f) {
+		if f[i] != '%' {
+			j := strings.IndexByte(f[i:], '%')
+			if j < 0 {
+				j = len(f) - i
+			}
+			buf.WriteString(f[i : i+j])
+			i += j
+			continue
+		}
+		i++
+		if i >= len(f) {
+			panic(errFormatEnded)
+		}
+		if f[i] == '%' {
+			buf.WriteByte('%')
+			i++
+			continue
+		}
+
+		// XPG3 position, like "%2$s".
+		j := i
+		for j < len(f) && isDigitByte(f[j]) {
+			j++
+		}
+		if j > i && j < len(f) && f[j] == '$' {
+			if xpg == 1 {
+				panic(errFormatMixed)
+			}
+			xpg = 2
+			n, _ := strconv.Atoi(f[i:j])
+			if n < 1 || n > len(args) {
+				panic(errFormatIndex)
+			}
+			argi = n - 1
+			i = j + 1
+		} else {
+			if xpg == 2 {
+				panic(errFormatMixed)
+			}
+			xpg = 1
+		}
+
+		// Flags.
+		flags := ""
+		for i < len(f) && strings.IndexByte("-+ 0#", f[i]) >= 0 {
+			flags += f[i : i+1]
+			i++
+		}
+
+		// Width.
+		width := -1
+		if i < len(f) && f[i] == '*' {
+			width = int(FormatInt(next()))
+			if width < 0 {
+				flags += "-"
+				width = -width
+			}
+			i++
+		} else {
+			j := i
+			for i < len(f) && isDigitByte(f[i]) {
+				i++
+			}
+			if i > j {
+				width, _ = strconv.Atoi(f[j:i])
+			}
+		}
+
+		// Precision.
+		prec := -1
+		if i < len(f) && f[i] == '.' {
+			i++
+			if i < len(f) && f[i] == '*' {
+				prec = int(FormatInt(next()))
+				if prec < 0 {
+					prec = 0
+				}
+				i++
+			} else {
+				j := i
+				for i < len(f) && isDigitByte(f[i]) {
+					i++
+				}
+				prec, _ = strconv.Atoi("0" + f[j:i])
+			}
+		}
+
+		// Size modifier.
+		short := false
+		for i < len(f) && strings.IndexByte("hlLqjzt", f[i]) >= 0 {
+			short = f[i] == 'h'
+			i++
+		}
+
+		if i >= len(f) {
+			panic(errFormatEnded)
+		}
+		c := f[i]
+		i++
+
+		spec := func(verb byte, fl string) string {
+			z := "%" + fl
+			if width >= 0 {
+				z += strconv.Itoa(width)
+			}
+			if prec >= 0 {
+				z += "." + strconv.Itoa(prec)
+			}
+			return z + string(verb)
+		}
+		unsignedFlags := strings.NewReplacer("+", "", " ", "").Replace(flags)
+		padded := func(s string) string {
+			fl := strings.Replace(flags, "0", "", -1)
+			if width >= 0 {
+				return Sprintf("%"+fl+"*s", width, s)
+			}
+			return s
+		}
+
+		switch c {
+		case 'd', 'i':
+			v := FormatInt(next())
+			if short {
+				v = int64(int16(v))
+			}
+			buf.WriteString(Sprintf(spec('d', flags), v))
+		case 'u', 'o', 'x', 'X', 'b', 'p':
+			v := uint64(FormatInt(next()))
+			if short {
+				v = uint64(uint16(v))
+			}
+			switch c {
+			case 'u':
+				c = 'd'
+			case 'p':
+				c = 'x'
+				unsignedFlags += "#"
+			}
+			buf.WriteString(Sprintf(spec(c, unsignedFlags), v))
+		case 'c':
+			r := rune(FormatInt(next()))
+			prec = -1
+			buf.WriteString(Sprintf(spec('c', flags), r))
+		case 's':
+			buf.WriteString(Sprintf(spec('s', flags), next().String()))
+		case 'f', 'e', 'E', 'g', 'G', 'a', 'A':
+			v := FormatFloat(next())
+			switch {
+			case math.IsNaN(v):
+				buf.WriteString(padded("NaN"))
+			case math.IsInf(v, 1):
+				if strings.Contains(flags, "+") {
+					buf.WriteString(padded("+Inf"))
+				} else {
+					buf.WriteString(padded("Inf"))
+				}
+			case math.IsInf(v, -1):
+				buf.WriteString(padded("-Inf"))
+			case c == 'a' || c == 'A':
+				s := hexFloat(v, prec, strings.Contains(flags, "+"))
+				if c == 'A' {
+					s = strings.ToUpper(s)
+				}
+				buf.WriteString(padded(s))
+			default:
+				if (c == 'g' || c == 'G') && prec < 0 {
+					prec = 6 // As in C, unlike Go.
+				}
+				buf.WriteString(Sprintf(spec(c, flags), v))
+			}
+		default:
+			panic(Sprintf("bad field specifier \"%c\"", c))
+		}
+	}
+	return buf.String()
+}
+
+// hexFloat formats like C's %a, with no leading zeros in the exponent.
+func hexFloat(v float64, prec int, plus bool) string {
+	s := strconv.FormatFloat(v, 'x', prec, 64)
+	if k := strings.IndexByte(s, 'p'); k >= 0 && k+2 < len(s) {
+		exp := strings.TrimLeft(s[k+2:], "0")
+		if exp == "" {
+			exp = "0"
+		}
+		s = s[:k+2] + exp
+	}
+	if plus && v >= 0 {
+		s = "+" + s
+	}
+	return s
+}
+
+func cmdFormat(fr *Frame, argv []T) T {
+	f, args := Arg1v(argv)
+	return MkString(FormatArgs(f.String(), args))
+}
+
+// scanItem is a parsed piece of a scan format.
+type scanItem struct {
+	conv    byte   // conversion letter; 0 for a literal; ' ' for white space
+	literal rune   // when conv is 0
+	pos     int    // index into results; -1 if suppressed with '*'
+	width   int    // 0 for no limit
+	set     string // characters in a %[...] set
+	negate  bool   // %[^...]
+}
+
+func (o *scanItem) inSet(r rune) bool {
+	s := o.set
+	found := false
+	for len(s) > 0 {
+		a, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		if len(s) >= 2 && s[0] == '-' {
+			b, size2 := utf8.DecodeRuneInString(s[1:])
+			s = s[1+size2:]
+			if a > b {
+				a, b = b, a
+			}
+			if a <= r && r <= b {
+				found = true
+			}
+		} else if a == r {
+			found = true
+		}
+	}
+	return found != o.negate
+}
+
+// parseScanFormat checks the format like Tcl does before scanning,
+// returning the items and the number of result slots.
+func parseScanFormat(f string) ([]*scanItem, int) {
+	var items []*scanItem
+	xpg := 0 // 0 until decided, then 1 for sequential or 2 for XPG3 "%n$" positions.
+	seq := 0
+	numResults := 0
+
+	for i := 0; i < len(f); {
+		r, size := utf8.DecodeRuneInString(f[i:])
+		if unicode.IsSpace(r) {
+			items = append(items, &scanItem{conv: ' '})
+			i += size
+			continue
+		}
+		if r != '%' || i+1 < len(f) && f[i+1] == '%' {
+			if r == '%' {
+				size = 2
+			}
+			items = append(items, &scanItem{literal: r})
+			i += size
+			continue
+		}
+		i++
+
+		o := &scanItem{pos: -2}
+		if i < len(f) && f[i] == '*' {
+			o.pos = -1
+			i++
+		} else {
+			j := i
+			for j < len(f) && isDigitByte(f[j]) {
+				j++
+			}
+			if j > i && j < len(f) && f[j] == '$' {
+				if xpg == 1 {
+					panic(errFormatMixed)
+				}
+				xpg = 2
+				n, _ := strconv.Atoi(f[i:j])
+				if n < 1 {
+					panic(errFormatIndex)
+				}
+				o.pos = n - 1
+				i = j + 1
+			}
+		}
+
+		j := i
+		for i < len(f) && isDigitByte(f[i]) {
+			i++
+		}
+		if i > j {
+			o.width, _ = strconv.Atoi(f[j:i])
+		}
+		for i < len(f) && strings.IndexByte("hlLqjzt", f[i]) >= 0 {
+			i++
+		}
+		if i >= len(f) {
+			panic(errFormatEnded)
+		}
+		o.conv = f[i]
+		i++
+
+		switch o.conv {
+		case 'd', 'i', 'o', 'x', 'X', 'b', 'u', 's', 'e', 'f', 'g', 'E', 'G', 'a', 'A':
+		case 'c':
+			if o.width != 0 {
+				panic("field width may not be specified in %c conversion")
+			}
+		case 'n':
+		case '[':
+			if i < len(f) && f[i] == '^' {
+				o.negate = true
+				i++
+			}
+			j := i
+			if i < len(f) && f[i] == ']' {
+				i++ // A leading ']' is in the set.
+			}
+			for i < len(f) && f[i] != ']' {
+				i++
+			}
+			if i >= len(f) {
+				panic("unmatched [ in format string")
+			}
+			o.set = f[j:i]
+			i++
+		default:
+			r, _ := utf8.DecodeRuneInString(f[i-1:])
+			panic(Sprintf("bad scan conversion character \"%c\"", r))
+		}
+
+		if o.pos == -2 {
+			if xpg == 2 {
+				panic(errFormatMixed)
+			}
+			xpg = 1
+			o.pos = seq
+			seq++
+		}
+		if o.pos >= numResults {
+			numResults = o.pos + 1
+		}
+		items = append(items, o)
+	}
+	return items, numResults
+}
+
+// scanNumber finds the longest prefix of s (at most width bytes) that is
+// a number for the conversion.  It returns the length of the number,
+// and for integers the base and where the digits start (after any sign and prefix).
+func scanNumber(s string, width int, conv byte) (n int, base int, start int) {
+	if width > 0 && width < len(s) {
+		s = s[:width]
+	}
+	i := 0
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		i++
+	}
+	digitsIn := func(base int) int {
+		j := i
+		for j < len(s) && int(digitValue(s[j])) < base {
+			j++
+		}
+		return j - i
+	}
+	// hasPrefix requires a digit in the base after the prefix.
+	hasPrefix := func(p string, base int) bool {
+		k := i + len(p)
+		return k < len(s) && strings.EqualFold(s[i:k], p) && int(digitValue(s[k])) < base
+	}
+
+	switch conv {
+	case 'd', 'u':
+		base = 10
+	case 'o':
+		base = 8
+	case 'b':
+		base = 2
+	case 'x', 'X':
+		base = 16
+		if hasPrefix("0x", 16) {
+			i += 2
+		}
+	case 'i':
+		switch {
+		case hasPrefix("0x", 16):
+			base = 16
+			i += 2
+		case hasPrefix("0b", 2):
+			base = 2
+			i += 2
+		case hasPrefix("0o", 8):
+			base = 8
+			i += 2
+		case i < len(s) && s[i] == '0':
+			base = 8
+		default:
+			base = 10
+		}
+	default: // Floating point.
+		rest := strings.ToLower(s[i:])
+		for _, word := range []string{"infinity", "inf", "nan"} {
+			if strings.HasPrefix(rest, word) {
+				return i + len(word), 0, 0
+			}
+		}
+		m := digitsIn(10)
+		i += m
+		if i < len(s) && s[i] == '.' {
+			i++
+			f := digitsIn(10)
+			m += f
+			i += f
+		}
+		if m == 0 {
+			return 0, 0, 0
+		}
+		if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+			j := i + 1
+			if j < len(s) && (s[j] == '+' || s[j] == '-') {
+				j++
+			}
+			k := j
+			for k < len(s) && isDigitByte(s[k]) {
+				k++
+			}
+			if k > j {
+				i = k // Only take the exponent if it has digits.
+			}
+		}
+		return i, 0, 0
+	}
+	m := digitsIn(base)
+	if m == 0 {
+		return 0, base, 0
+	}
+	return i + m, base, i
+}
+
+// scanInt converts the digits of an integer, giving a string for values
+// that do not fit in 64 bits, as Tcl would give a bignum.
+func scanInt(neg bool, digits string, base int, conv byte) T {
+	z, ok := new(big.Int).SetString(digits, base)
+	if !ok {
+		panic(Sprintf("scan: bad integer %q", digits))
+	}
+	if neg {
+		z.Neg(z)
+		if conv == 'u' {
+			z.Add(z, new(big.Int).Lsh(big.NewInt(1), 64))
+		}
+	}
+	if z.IsInt64() {
+		return MkInt(z.Int64())
+	}
+	return MkString(z.String())
+}
+
+func cmdScan(fr *Frame, argv []T) T {
+	sT, fT, vars := Arg2v(argv)
+	s := sT.String()
+	items, numResults := parseScanFormat(fT.String())
+
+	if len(vars) > 0 {
+		if numResults > len(vars) {
+			panic(errFormatIndex)
+		}
+		if numResults < len(vars) {
+			panic("different numbers of variable names and field specifiers")
+		}
+	}
+	assigned := make([]bool, numResults)
+	for _, o := range items {
+		if o.conv != 0 && o.conv != ' ' && o.pos >= 0 {
+			assigned[o.pos] = true
+		}
+	}
+	for _, a := range assigned {
+		if !a {
+			panic("variable is not assigned by any conversion specifiers")
+		}
+	}
+
+	results := make([]T, numResults)
+	conversions := 0
+	underflow := false
+	si := 0 // Byte index into s.
+	skipSpace := func() {
+		for si < len(s) {
+			r, size := utf8.DecodeRuneInString(s[si:])
+			if !unicode.IsSpace(r) {
+				break
+			}
+			si += size
+		}
+	}
+
+Loop:
+	for _, o := range items {
+		switch o.conv {
+		case ' ':
+			skipSpace()
+			continue
+		case 0:
+			if si >= len(s) {
+				underflow = true
+				break Loop
+			}
+			r, size := utf8.DecodeRuneInString(s[si:])
+			if r != o.literal {
+				break Loop
+			}
+			si += size
+			continue
+		case 'n':
+			if o.pos >= 0 {
+				results[o.pos] = MkInt(int64(CharLen(s[:si])))
+				conversions++
+			}
+			continue
+		case 'c', '[':
+		default:
+			skipSpace()
+		}
+		if si >= len(s) {
+			underflow = true
+			break Loop
+		}
+
+		var value T
+		switch o.conv {
+		case 'c':
+			r, size := utf8.DecodeRuneInString(s[si:])
+			si += size
+			value = MkInt(int64(r))
+		case 's', '[':
+			j, count := si, 0
+			for j < len(s) && (o.width == 0 || count < o.width) {
+				r, size := utf8.DecodeRuneInString(s[j:])
+				if o.conv == 's' && unicode.IsSpace(r) || o.conv == '[' && !o.inSet(r) {
+					break
+				}
+				j += size
+				count++
+			}
+			if j == si {
+				break Loop
+			}
+			value = MkString(s[si:j])
+			si = j
+		case 'd', 'i', 'o', 'x', 'X', 'b', 'u':
+			n, base, start := scanNumber(s[si:], o.width, o.conv)
+			if n == 0 {
+				break Loop
+			}
+			value = scanInt(s[si] == '-', s[si+start:si+n], base, o.conv)
+			si += n
+		default:
+			n, _, _ := scanNumber(s[si:], o.width, o.conv)
+			if n == 0 {
+				break Loop
+			}
+			f, err := strconv.ParseFloat(s[si:si+n], 64)
+			if err != nil && f == 0 {
+				break Loop
+			}
+			value = MkFloat(f)
+			si += n
+		}
+		if o.pos >= 0 {
+			results[o.pos] = value
+			conversions++
+		}
+	}
+
+	if len(vars) == 0 {
+		if underflow && conversions == 0 {
+			return Empty
+		}
+		for i, v := range results {
+			if v == nil {
+				results[i] = Empty
+			}
+		}
+		return MkList(results)
+	}
+
+	for i, v := range results {
+		if v != nil {
+			fr.SetVar(vars[i].String(), v)
+		}
+	}
+	if underflow && conversions == 0 {
+		return MkInt(-1)
+	}
+	return MkInt(int64(conversions))
+}
+
+func init() {
+	if Safes == nil {
+		Safes = make(map[string]Command, 333)
+	}
+
+	Safes["format"] = cmdFormat
+	Safes["scan"] = cmdScan
+}
@@ -0,0 +1,72 @@
+package tcl
+
+import (
+	"testing"
+)
+
+var formatTests = `
+	must "  3.14" [format %6.2f 3.14159]
+	must "42|-7|+5| 5" [format "%d|%i|%+d|% d" 42 -7 5 5]
+	must "00042|42   |   42" [format %05d|%-5d|%5d 42 42 42]
+	must "A日" [format %c%c 65 0x65e5]
+	must "   ab" [format %*s 5 ab]
+	must "ab   |" [format %*s| -5 ab]
+	must "3.14" [format %.*f 2 3.14159]
+	must "ff|FF|377|101|0xff" [format %x|%X|%o|%b|%#x 255 255 255 5 255]
+	must 18446744073709551615 [format %u -1]
+	must 65535 [format %hu -1]
+	must 123 [format %ld 123]
+	must "b a" [format {%2$s %1$s} a b]
+	must "a a" [format {%1$s %1$s} a]
+	must "100%" [format %d%% 100]
+	must "0.3|1e+06|1.5" [format %g|%g|%g [expr 0.1+0.2] 1000000.0 1.5]
+	must "1.000000e+00" [format %e 1]
+	must "abc" [format %.3s abcdef]
+	must "日本" [format %.2s 日本語]
+	must "Inf" [format %f Inf]
+	must "0x1p+0" [format %a 1.0]
+	must "only 1" [format "only %d" 1 2 3]
+
+	mustfail {format %d}
+	mustfail {format %d abc}
+	mustfail {format %d 3.7}
+	mustfail {format %v 1}
+	mustfail {format %q 1}
+	mustfail {format %T 1}
+	mustfail {format {%1$s %s} a b}
+	mustfail {format {%3$s} a b}
+	mustfail {format abc%}
+
+	must 2 [scan "12 abc" "%d %s" n s]
+	must 12 $n
+	must abc $s
+	must {12 abc} [scan "12 abc" "%d %s"]
+	must {255 8 5 7} [scan "ff 010 101 7" "%x %i %b %o"]
+	must {65 66} [scan "AB" "%c%c"]
+	must {abc def} [scan "abcdef" "%3s%s"]
+	must {abc 123} [scan "abc123" {%[a-z]%[0-9]}]
+	must {abc 3} [scan "abc123" {%[^0-9]%n}]
+	must 7 [scan "x 7" "%*s %d"]
+	must {b a} [scan "a b" {%2$s %1$s}]
+	must {1.5 -250} [scan "1.5 -2.5e2" "%f %g"]
+	must {12 {}} [scan "12" "%d %d"]
+	must {} [scan "" "%d"]
+	must -1 [scan "" "%d" x]
+	must 0 [scan "abc" "%d" y]
+	must 0 [info exists y]
+	must 1 [scan "x=9" "x=%d" z]
+	must 9 $z
+	must 123456789012345678901234567890 [scan 123456789012345678901234567890 %d]
+
+	mustfail {scan 1 %d a b}
+	mustfail {scan 1 "%d %d" a}
+	mustfail {scan 1 %5c}
+	mustfail {scan 1 {%[abc}}
+	mustfail {scan 1 %v}
+	mustfail {scan 1 {%1$d %d}}
+`
+
+func TestFormat(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(formatTests))
+}
@@ -0,0 +1,342 @@
+package tcl
+
+import (
+	. "fmt"
+	R "reflect"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Channels for goroutines, like Go channels of strings:
+//
+//	chan create ?size?           returns the name of a new channel
+//	chan send ch value           blocks until received (or buffered)
+//	chan recv ch ?varName?       blocks for a value
+//	chan close ch                also closes I/O channels
+//	chan event ch kind ?script?  like fileevent, for I/O channels
+//	select {clause ...}          waits for the first ready clause
+//
+// An interpreter may use the channels it created, and those known to the
+// interpreter that started it with "go".  Other names are found only by
+// an unsafe interpreter with the "interp" capability.  A channel is
+// forgotten when closed, except by interpreters that know it already,
+// until they find it closed and empty.
+// Values are sent as strings, so goroutines share no T.
+// Blocking stops early if the interpreter's time or context limit passes.
+
+// GoChan is a channel made by "chan create".
+type GoChan struct {
+	Name string
+	C    chan string
+}
+
+// goChans holds the open GoChans by name.
+var goChans = struct {
+	sync.Mutex
+	m map[string]*GoChan
+	n int
+}{m: make(map[string]*GoChan)}
+
+// NewGoChan makes and registers a GoChan with the given buffer size.
+func NewGoChan(size int) *GoChan {
+	goChans.Lock()
+	defer goChans.Unlock()
+	goChans.n++
+	gc := &GoChan{Name: Sprintf("gochan%d", goChans.n), C: make(chan string, size)}
+	goChans.m[gc.Name] = gc
+	return gc
+}
+
+// AddGoChan lets the interpreter use the GoChan.
+func (g *Global) AddGoChan(gc *GoChan) {
+	if g.GoChans == nil {
+		g.GoChans = make(map[string]*GoChan)
+	}
+	g.GoChans[gc.Name] = gc
+}
+
+// LookupGoChan returns the named GoChan, or panics.
+func (fr *Frame) LookupGoChan(name string) *GoChan {
+	gc := fr.findGoChan(name)
+	if gc == nil {
+		panic(Sprintf("no such channel: %q", name))
+	}
+	return gc
+}
+
+// findGoChan returns the named GoChan if the interpreter may use it, or nil.
+func (fr *Frame) findGoChan(name string) *GoChan {
+	if gc, ok := fr.G.GoChans[name]; ok {
+		return gc
+	}
+	if fr.G.IsSafe {
+		return nil
+	}
+	if _, ok := fr.Cred["interp"]; fr.Cred != nil && !ok {
+		return nil
+	}
+	goChans.Lock()
+	gc := goChans.m[name]
+	goChans.Unlock()
+	if gc != nil {
+		fr.G.AddGoChan(gc)
+	}
+	return gc
+}
+
+// forgetGoChan removes a closed GoChan from the registry, so only
+// interpreters that know it already can find it.
+func forgetGoChan(gc *GoChan) {
+	goChans.Lock()
+	defer goChans.Unlock()
+	delete(goChans.m, gc.Name)
+}
+
+// ReflectChannel, if set, makes an I/O channel implemented by a command
+// prefix, for "chan create mode cmdPrefix".  Package posix sets it.
+var ReflectChannel func(fr *Frame, mode, cmdPrefix T) T
+
+// CloseChannel, if set, closes a registered I/O channel for "chan close".
+// Package posix sets it.
+var CloseChannel func(fr *Frame, name T)
+
+// ChannelEvent, if set, implements "chan event" for I/O channels.
+// Package posix sets it.
+var ChannelEvent Command
+
+// limitCases returns select cases for the context and time limits of the
+// interpreter and its parents, which blocking commands add to their own
+// cases, with the LimitExceeded for each case.
+func (g *Global) limitCases() ([]R.SelectCase, []LimitExceeded) {
+	var cases []R.SelectCase
+	var limits []LimitExceeded
+	for ; g != nil; g = g.Parent {
+		if g.Context != nil {
+			cases = append(cases, R.SelectCase{Dir: R.SelectRecv, Chan: R.ValueOf(g.Context.Done())})
+			limits = append(limits, LimitExceeded{G: g, Kind: "context"})
+		}
+		if !g.TimeLimit.IsZero() {
+			timer := time.After(time.Until(g.TimeLimit))
+			cases = append(cases, R.SelectCase{Dir: R.SelectRecv, Chan: R.ValueOf(timer)})
+			limits = append(limits, LimitExceeded{G: g, Kind: "time"})
+		}
+	}
+	return cases, limits
+}
+
+// selectLimited runs reflect.Select on the cases plus the limit cases,
+// panicking with a LimitExceeded if a limit is chosen.
+func (fr *Frame) selectLimited(cases []R.SelectCase) (int, R.Value, bool) {
+	n := len(cases)
+	limitCases, limits := fr.G.limitCases()
+	chosen, v, ok := R.Select(append(cases, limitCases...))
+	if chosen >= n {
+		panic(limits[chosen-n])
+	}
+	return chosen, v, ok
+}
+
+// awaitDone blocks until done is closed, or a limit passes.
+func (fr *Frame) awaitDone(done <-chan struct{}) {
+	fr.selectLimited([]R.SelectCase{{Dir: R.SelectRecv, Chan: R.ValueOf(done)}})
+}
+
+// unlessClosed calls fn, returning false if it panics by sending on
+// (or closing) a closed channel.
+func unlessClosed(fn func()) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, isRuntime := r.(runtime.Error); !isRuntime {
+				panic(r)
+			}
+			ok = false
+		}
+	}()
+	fn()
+	return true
+}
+
+var chanEnsemble = []EnsembleItem{
+	EnsembleItem{Name: "create", Cmd: cmdChanCreate, Doc: "?size? | mode cmdPrefix"},
+	EnsembleItem{Name: "send", Cmd: cmdChanSend, Doc: "channel value"},
+	EnsembleItem{Name: "recv", Cmd: cmdChanRecv, Doc: "channel ?varName?"},
+	EnsembleItem{Name: "close", Cmd: cmdChanClose, Doc: "channel"},
+	EnsembleItem{Name: "event", Cmd: cmdChanEvent, Doc: "channel readable|writable ?script?"},
+}
+
+func cmdChanCreate(fr *Frame, argv []T) T {
+	size := 0
+	switch len(argv) {
+	case 1:
+	case 2:
+		size = int(argv[1].Int())
+		if size < 0 {
+			panic(Sprintf("bad channel size: %d", size))
+		}
+	case 3:
+		if ReflectChannel == nil {
+			panic("chan create: reflected channels are not available")
+		}
+		return ReflectChannel(fr, argv[1], argv[2])
+	default:
+		panic("usage: chan create ?size? | chan create mode cmdPrefix")
+	}
+	gc := NewGoChan(size)
+	fr.G.AddGoChan(gc)
+	return MkString(gc.Name)
+}
+
+func cmdChanSend(fr *Frame, argv []T) T {
+	name, value := Arg2(argv)
+	gc := fr.LookupGoChan(name.String())
+	s := value.String()
+	ok := unlessClosed(func() {
+		fr.selectLimited([]R.SelectCase{{Dir: R.SelectSend, Chan: R.ValueOf(gc.C), Send: R.ValueOf(s)}})
+	})
+	if !ok {
+		panic(Sprintf("send on closed channel: %q", gc.Name))
+	}
+	return Empty
+}
+
+// cmdChanRecv returns the received value, raising an error if the channel
+// is closed.  With a varName, it sets the variable and returns 1, or 0 if
+// the channel is closed.
+func cmdChanRecv(fr *Frame, argv []T) T {
+	name, optionalName := Arg1v(argv)
+	if len(optionalName) > 1 {
+		panic("usage: chan recv channel ?varName?")
+	}
+	gc := fr.LookupGoChan(name.String())
+	_, v, ok := fr.selectLimited([]R.SelectCase{{Dir: R.SelectRecv, Chan: R.ValueOf(gc.C)}})
+	if !ok {
+		delete(fr.G.GoChans, gc.Name) // Closed and empty.
+	}
+	if len(optionalName) == 0 {
+		if !ok {
+			panic(Sprintf("receive on closed channel: %q", gc.Name))
+		}
+		return MkString(v.String())
+	}
+	fr.SetVar(optionalName[0].String(), MkString(v.String()))
+	return MkBool(ok)
+}
+
+func cmdChanClose(fr *Frame, argv []T) T {
+	name := Arg1(argv)
+	if _, ok := fr.G.Channels[name.String()]; ok && CloseChannel != nil && fr.findGoChan(name.String()) == nil {
+		CloseChannel(fr, name)
+		return Empty
+	}
+	gc := fr.LookupGoChan(name.String())
+	ok := unlessClosed(func() { close(gc.C) })
+	if !ok {
+		panic(Sprintf("close of closed channel: %q", gc.Name))
+	}
+	forgetGoChan(gc)
+	return Empty
+}
+
+func cmdChanEvent(fr *Frame, argv []T) T {
+	if ChannelEvent == nil {
+		panic("chan event: file events are not available")
+	}
+	return ChannelEvent(fr, argv)
+}
+
+// cmdSelect waits for the first ready clause and evaluates its body.
+// The clauses are
+//
+//	recv channel varName body    varName may be {valueVar okVar}
+//	send channel value body
+//	timeout ms body
+//	default body
+func cmdSelect(fr *Frame, argv []T) T {
+	clauses := Arg1(argv).List()
+	var cases []R.SelectCase
+	var bodies []T
+	var varNames []T
+	var recvs []*GoChan
+	var hasDefault bool
+	var defaultBody T
+	for i := 0; i < len(clauses); {
+		need := func(n int) []T {
+			if i+n > len(clauses) {
+				panic(Sprintf("select: missing arguments for %q", clauses[i].String()))
+			}
+			z := clauses[i+1 : i+n]
+			i += n
+			return z
+		}
+		switch kw := clauses[i].String(); kw {
+		case "recv":
+			a := need(4)
+			gc := fr.LookupGoChan(a[0].String())
+			cases = append(cases, R.SelectCase{Dir: R.SelectRecv, Chan: R.ValueOf(gc.C)})
+			recvs = append(recvs, gc)
+			varNames = append(varNames, a[1])
+			bodies = append(bodies, a[2])
+		case "send":
+			a := need(4)
+			gc := fr.LookupGoChan(a[0].String())
+			cases = append(cases, R.SelectCase{Dir: R.SelectSend, Chan: R.ValueOf(gc.C), Send: R.ValueOf(a[1].String())})
+			recvs = append(recvs, nil)
+			varNames = append(varNames, nil)
+			bodies = append(bodies, a[2])
+		case "timeout":
+			a := need(3)
+			d := time.Duration(a[0].Float() * float64(time.Millisecond))
+			cases = append(cases, R.SelectCase{Dir: R.SelectRecv, Chan: R.ValueOf(time.After(d))})
+			recvs = append(recvs, nil)
+			varNames = append(varNames, nil)
+			bodies = append(bodies, a[1])
+		case "default":
+			a := need(2)
+			hasDefault, defaultBody = true, a[0]
+		default:
+			panic(Sprintf("select: bad clause %q: should be recv, send, timeout, or default", kw))
+		}
+	}
+	if hasDefault {
+		cases = append(cases, R.SelectCase{Dir: R.SelectDefault})
+		bodies = append(bodies, defaultBody)
+		recvs = append(recvs, nil)
+		varNames = append(varNames, nil)
+	}
+	if len(cases) == 0 {
+		panic("select: no clauses")
+	}
+
+	var chosen int
+	var v R.Value
+	var ok bool
+	sent := unlessClosed(func() { chosen, v, ok = fr.selectLimited(cases) })
+	if !sent {
+		panic("select: send on closed channel")
+	}
+	if recvs[chosen] != nil && !ok {
+		delete(fr.G.GoChans, recvs[chosen].Name) // Closed and empty.
+	}
+	if varNames[chosen] != nil {
+		names := varNames[chosen].List()
+		value := Empty
+		if ok {
+			value = MkString(v.String())
+		}
+		fr.SetVar(names[0].String(), value)
+		if len(names) > 1 {
+			fr.SetVar(names[1].String(), MkBool(ok))
+		}
+	}
+	return fr.Eval(bodies[chosen])
+}
+
+func init() {
+	if Safes == nil {
+		Safes = make(map[string]Command, 333)
+	}
+
+	Safes["chan"] = MkEnsemble(chanEnsemble)
+	Safes["select"] = cmdSelect
+}
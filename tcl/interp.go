@@ -0,0 +1,435 @@
+package tcl
+
+import (
+	. "fmt"
+	"time"
+)
+
+// Child interpreters are made by "interp create", and named by paths
+// (lists of names) relative to the interpreter using the "interp" command.
+// A safe interpreter can only create safe children.
+
+// Child returns the global frame of the interpreter named by the path,
+// which is relative to this one.  The empty path means this interpreter.
+func (fr *Frame) Child(path T) *Frame {
+	z := &fr.G.Fr
+	for _, e := range path.List() {
+		c, ok := z.G.Children[e.String()]
+		if !ok {
+			panic(Sprintf("could not find interpreter %q", path.String()))
+		}
+		z = c
+	}
+	return z
+}
+
+// NewChild makes a child interpreter with the given name.
+func (fr *Frame) NewChild(name string, safe bool) *Frame {
+	g := fr.G
+	if _, ok := g.Children[name]; ok {
+		panic(Sprintf("interpreter named %q already exists, cannot create", name))
+	}
+	if _, ok := g.Cmds[name]; ok {
+		panic(Sprintf("cannot create interpreter %q: command already exists", name))
+	}
+	child := newEitherInterpreter(safe || g.IsSafe)
+	child.G.Parent = g
+	if g.Children == nil {
+		g.Children = make(map[string]*Frame)
+	}
+	g.Children[name] = child
+
+	// A command with the child's name is a shortcut for "interp sub name ...".
+	g.Cmds[name] = &CmdNode{Fn: func(fr2 *Frame, argv []T) T {
+		if len(argv) < 2 {
+			panic(Sprintf("Usage: %s subcommand ?arg ...?", name))
+		}
+		switch sub := argv[1].String(); sub {
+		case "alias", "aliases", "eval", "issafe", "limit":
+			argv2 := []T{MkString("interp"), argv[1], MkString(name)}
+			fr2.CheckCred(argv2)
+			return Safes["interp"](fr2, append(argv2, argv[2:]...))
+		default:
+			panic(Sprintf("bad option %q: must be alias, aliases, eval, issafe, or limit", sub))
+		}
+	}}
+	return child
+}
+
+// deleteChildren deletes all children of the interpreter, recursively.
+func (g *Global) deleteChildren() {
+	for name, c := range g.Children {
+		c.G.deleteChildren()
+		c.G.Deleted = true
+		delete(g.Cmds, name)
+	}
+	g.Children = nil
+}
+
+var interpEnsemble = []EnsembleItem{
+	EnsembleItem{Name: "create", Cmd: cmdInterpCreate, Doc: "?-safe? ?--? ?path?"},
+	EnsembleItem{Name: "delete", Cmd: cmdInterpDelete, Doc: "?path ...?"},
+	EnsembleItem{Name: "eval", Cmd: cmdInterpEval, Doc: "path arg ?arg ...?"},
+	EnsembleItem{Name: "exists", Cmd: cmdInterpExists, Doc: "path"},
+	EnsembleItem{Name: "issafe", Cmd: cmdInterpIsSafe, Doc: "?path?"},
+	EnsembleItem{Name: "children", Cmd: cmdInterpChildren, Doc: "?path?"},
+	EnsembleItem{Name: "slaves", Cmd: cmdInterpChildren, Doc: "?path?"},
+	EnsembleItem{Name: "alias", Cmd: cmdInterpAlias, Doc: "srcPath srcCmd ?targetPath targetCmd ?arg ...??"},
+	EnsembleItem{Name: "aliases", Cmd: cmdInterpAliases, Doc: "?path?"},
+	EnsembleItem{Name: "share", Cmd: cmdInterpShare, Doc: "srcPath channel destPath"},
+	EnsembleItem{Name: "transfer", Cmd: cmdInterpTransfer, Doc: "srcPath channel destPath"},
+	EnsembleItem{Name: "limit", Cmd: cmdInterpLimit, Doc: "path commands|time ?-option value ...?"},
+}
+
+func cmdInterpCreate(fr *Frame, argv []T) T {
+	args := argv[1:]
+	safe := false
+	for len(args) > 0 {
+		opt := args[0].String()
+		if opt == "-safe" {
+			safe = true
+		} else if opt == "--" {
+			args = args[1:]
+			break
+		} else {
+			break
+		}
+		args = args[1:]
+	}
+
+	var path []T
+	switch len(args) {
+	case 0:
+		for {
+			name := Sprintf("interp%d", fr.G.interpCounter)
+			fr.G.interpCounter++
+			if _, ok := fr.G.Children[name]; !ok {
+				path = []T{MkString(name)}
+				break
+			}
+		}
+	case 1:
+		path = args[0].List()
+		if len(path) == 0 {
+			panic("interp create: empty path")
+		}
+	default:
+		panic("Usage: interp create ?-safe? ?--? ?path?")
+	}
+
+	parent := fr.Child(MkList(path[:len(path)-1]))
+	parent.NewChild(path[len(path)-1].String(), safe)
+	return MkList(path)
+}
+
+func cmdInterpDelete(fr *Frame, argv []T) T {
+	for _, p := range argv[1:] {
+		path := p.List()
+		if len(path) == 0 {
+			panic("cannot delete the current interpreter")
+		}
+		parent := fr.Child(MkList(path[:len(path)-1]))
+		name := path[len(path)-1].String()
+		child, ok := parent.G.Children[name]
+		if !ok {
+			panic(Sprintf("could not find interpreter %q", p.String()))
+		}
+		child.G.deleteChildren()
+		child.G.Deleted = true
+		delete(parent.G.Children, name)
+		delete(parent.G.Cmds, name)
+	}
+	return Empty
+}
+
+func cmdInterpEval(fr *Frame, argv []T) T {
+	path, args := Arg1v(argv)
+	if len(args) == 0 {
+		panic("Usage: interp eval path arg ?arg ...?")
+	}
+	child := fr.Child(path)
+	var script T
+	if len(args) == 1 {
+		script = args[0]
+	} else {
+		script = cmdConcat(fr, append([]T{MkString("concat")}, args...))
+	}
+	return child.WithCred(fr.Cred, func() T {
+		return EvalChild(child, script)
+	})
+}
+
+// EvalChild evaluates the script in the child's global frame,
+// turning a "return" into its result, as at top level.
+func EvalChild(child *Frame, script T) (result T) {
+	defer func() {
+		if r := recover(); r != nil {
+			if j, ok := r.(Jump); ok && j.Status == RETURN {
+				result = j.Result
+				return
+			}
+			panic(r)
+		}
+	}()
+	return child.Eval(script)
+}
+
+func cmdInterpExists(fr *Frame, argv []T) T {
+	path := Arg1(argv)
+	z := &fr.G.Fr
+	for _, e := range path.List() {
+		c, ok := z.G.Children[e.String()]
+		if !ok {
+			return False
+		}
+		z = c
+	}
+	return True
+}
+
+func optionalPath(fr *Frame, argv []T, usage string) *Frame {
+	rest := Arg0v(argv)
+	switch len(rest) {
+	case 0:
+		return &fr.G.Fr
+	case 1:
+		return fr.Child(rest[0])
+	}
+	panic("Usage: interp " + usage)
+}
+
+func cmdInterpIsSafe(fr *Frame, argv []T) T {
+	return MkBool(optionalPath(fr, argv, "issafe ?path?").G.IsSafe)
+}
+
+func cmdInterpChildren(fr *Frame, argv []T) T {
+	g := optionalPath(fr, argv, "children ?path?").G
+	var zz []T
+	for k := range g.Children {
+		zz = append(zz, MkString(k))
+	}
+	SortListByString(zz)
+	return MkList(zz)
+}
+
+// cmdInterpAlias makes srcCmd in the interpreter srcPath invoke targetCmd
+// (with any extra args before the caller's args) in the interpreter targetPath.
+func cmdInterpAlias(fr *Frame, argv []T) T {
+	if len(argv) < 3 {
+		panic("Usage: interp alias srcPath srcCmd ?targetPath targetCmd ?arg ...??")
+	}
+	src := fr.Child(argv[1])
+	srcCmd := argv[2].String()
+	switch {
+	case len(argv) == 3:
+		return MkList(src.G.Aliases[srcCmd])
+	case len(argv) == 4 && argv[3].IsEmpty():
+		if _, ok := src.G.Aliases[srcCmd]; !ok {
+			panic(Sprintf("alias %q not found", srcCmd))
+		}
+		delete(src.G.Aliases, srcCmd)
+		delete(src.G.Cmds, srcCmd)
+		return Empty
+	case len(argv) < 5:
+		panic("Usage: interp alias srcPath srcCmd ?targetPath targetCmd ?arg ...??")
+	}
+
+	if _, ok := Safes[srcCmd]; ok {
+		panic(Sprintf("cannot alias over builtin command %q", srcCmd))
+	}
+	target := fr.Child(argv[3])
+	prefix := append([]T(nil), argv[4:]...)
+	tg := target.G
+	src.G.Cmds[srcCmd] = &CmdNode{Fn: func(fr2 *Frame, argv2 []T) T {
+		if tg.Deleted {
+			panic(Sprintf("alias %q: target interpreter was deleted", srcCmd))
+		}
+		cmd := append(append([]T(nil), prefix...), argv2[1:]...)
+		return tg.Fr.WithCred(fr2.Cred, func() T {
+			return tg.Fr.Apply(cmd)
+		})
+	}}
+	if src.G.Aliases == nil {
+		src.G.Aliases = make(map[string][]T)
+	}
+	src.G.Aliases[srcCmd] = append([]T{argv[3]}, prefix...)
+	return argv[2]
+}
+
+func cmdInterpAliases(fr *Frame, argv []T) T {
+	g := optionalPath(fr, argv, "aliases ?path?").G
+	var zz []T
+	for k := range g.Aliases {
+		zz = append(zz, MkString(k))
+	}
+	SortListByString(zz)
+	return MkList(zz)
+}
+
+// RegisterChannel makes the channel findable by name in this interpreter.
+func (fr *Frame) RegisterChannel(ch T) {
+	if fr.G.Channels == nil {
+		fr.G.Channels = make(map[string]T)
+	}
+	fr.G.Channels[ch.String()] = ch
+}
+
+// UnregisterChannel forgets the channel in this interpreter.
+func (fr *Frame) UnregisterChannel(ch T) {
+	delete(fr.G.Channels, ch.String())
+}
+
+// NewStdChannel, if set, makes the channel for "stdin", "stdout" or
+// "stderr" (or returns nil for other names), when an unsafe interpreter
+// first looks one up.  Package posix sets it.
+var NewStdChannel func(name string) T
+
+// LookupChannel returns the channel named by t in this interpreter.
+func (fr *Frame) LookupChannel(t T) T {
+	name := t.String()
+	ch, ok := fr.G.Channels[name]
+	if !ok && NewStdChannel != nil && !fr.G.IsSafe {
+		if ch = NewStdChannel(name); ch != nil {
+			fr.RegisterChannel(ch)
+			return ch
+		}
+	}
+	if !ok {
+		panic(Sprintf("can not find channel named %q", name))
+	}
+	return ch
+}
+
+func moveChannel(fr *Frame, argv []T, keep bool) T {
+	srcPath, chanName, destPath := Arg3(argv)
+	src, dest := fr.Child(srcPath), fr.Child(destPath)
+	ch := src.LookupChannel(chanName)
+	dest.RegisterChannel(ch)
+	if !keep {
+		src.UnregisterChannel(ch)
+	}
+	return Empty
+}
+
+func cmdInterpShare(fr *Frame, argv []T) T    { return moveChannel(fr, argv, true) }
+func cmdInterpTransfer(fr *Frame, argv []T) T { return moveChannel(fr, argv, false) }
+
+// cmdInterpLimit queries or sets limits:
+//
+//	interp limit path commands ?-value n?
+//	interp limit path time ?-seconds epochSeconds? ?-milliseconds ms?
+//
+// An empty value removes the limit.  As a query returns, -milliseconds
+// is the part of the second after -seconds; without -seconds, the
+// seconds of the current limit are kept.  Only a parent may change the
+// limits of an interpreter, so the path may not be empty.
+func cmdInterpLimit(fr *Frame, argv []T) T {
+	path, kind, opts := Arg2v(argv)
+	g := fr.Child(path).G
+	if len(opts)%2 == 1 {
+		if len(opts) == 1 {
+			return interpLimitQuery(g, kind.String(), opts[0].String())
+		}
+		panic("interp limit: options must come in pairs")
+	}
+	if len(opts) == 0 {
+		return interpLimitQuery(g, kind.String(), "")
+	}
+	if g == fr.G {
+		panic("interp limit: an interpreter may not change its own limits")
+	}
+
+	switch kind.String() {
+	case "commands":
+		for i := 0; i < len(opts); i += 2 {
+			switch opts[i].String() {
+			case "-value":
+				if opts[i+1].IsEmpty() {
+					g.CommandLimit = 0
+				} else {
+					g.CommandLimit = g.CommandCount + opts[i+1].Int()
+				}
+			default:
+				panic(Sprintf("bad option %q: must be -value", opts[i].String()))
+			}
+		}
+	case "time":
+		var secs, millis T
+		for i := 0; i < len(opts); i += 2 {
+			switch opts[i].String() {
+			case "-seconds":
+				secs = opts[i+1]
+			case "-milliseconds":
+				millis = opts[i+1]
+			default:
+				panic(Sprintf("bad option %q: must be -seconds or -milliseconds", opts[i].String()))
+			}
+		}
+		if secs != nil && secs.IsEmpty() {
+			g.TimeLimit = time.Time{}
+			break
+		}
+		var sec, ms int64
+		switch {
+		case secs != nil:
+			sec = secs.Int()
+		case !g.TimeLimit.IsZero():
+			sec = g.TimeLimit.Unix()
+			ms = int64(g.TimeLimit.Nanosecond() / 1e6)
+		default:
+			sec = time.Now().Unix()
+		}
+		if millis != nil {
+			ms = 0
+			if !millis.IsEmpty() {
+				ms = millis.Int()
+			}
+		}
+		g.TimeLimit = time.Unix(sec, 0).Add(time.Duration(ms) * time.Millisecond)
+	default:
+		panic(Sprintf("bad limit type %q: must be commands or time", kind.String()))
+	}
+	g.resetBudget()
+	return Empty
+}
+
+func interpLimitQuery(g *Global, kind, opt string) T {
+	var names []string
+	var values []T
+	switch kind {
+	case "commands":
+		var v T = Empty
+		if g.CommandLimit > 0 {
+			v = MkInt(g.CommandLimit - g.CommandCount)
+		}
+		names, values = []string{"-value"}, []T{v}
+	case "time":
+		var secs, millis T = Empty, Empty
+		if !g.TimeLimit.IsZero() {
+			secs = MkInt(g.TimeLimit.Unix())
+			millis = MkInt(int64(g.TimeLimit.Nanosecond() / 1e6))
+		}
+		names, values = []string{"-seconds", "-milliseconds"}, []T{secs, millis}
+	default:
+		panic(Sprintf("bad limit type %q: must be commands or time", kind))
+	}
+	var zz []T
+	for i, n := range names {
+		if opt == n {
+			return values[i]
+		}
+		zz = append(zz, MkString(n), values[i])
+	}
+	if opt != "" {
+		panic(Sprintf("bad option %q", opt))
+	}
+	return MkList(zz)
+}
+
+func init() {
+	if Safes == nil {
+		Safes = make(map[string]Command, 333)
+	}
+
+	Safes["interp"] = MkEnsemble(interpEnsemble)
+}
@@ -0,0 +1,85 @@
+package tcl
+
+import (
+	"testing"
+)
+
+var interpTests = `
+  must kid [interp create -safe kid]
+  must 1 [interp exists kid]
+  must 0 [interp exists nobody]
+  must 1 [interp issafe kid]
+  must 0 [interp issafe]
+  must {kid} [interp children]
+
+  must 3 [interp eval kid {set x 3}]
+  must 3 [interp eval kid set x]
+  must 3 [kid eval {set x}]
+  mustfail {set x}
+  must 7 [interp eval kid {return 7}]
+  mustfail {interp eval kid {error boom}}
+
+  set Log {}
+  proc record {tag args} {
+    lappend Log $tag $args
+    llength $args
+  }
+  must report [interp alias kid report {} record R]
+  must 2 [interp eval kid {report a b}]
+  must R [lindex $Log 0]
+  must {a b} [lindex $Log 1]
+  must {{} record R} [interp alias kid report]
+  must {report} [interp aliases kid]
+  mustfail {interp alias kid set {} record}
+  interp alias kid report {}
+  mustfail {interp eval kid {report a b}}
+
+  must {kid grandkid} [interp create {kid grandkid}]
+  must 1 [interp issafe {kid grandkid}]
+  must {grandkid} [interp eval kid {interp children}]
+  must 1 [interp eval kid {interp issafe grandkid}]
+  must 5 [interp eval {kid grandkid} {expr 2 + 3}]
+
+  must interp0 [interp create]
+  must interp1 [interp create]
+  interp delete interp0 interp1 kid
+  must {} [interp children]
+  mustfail {interp eval kid {set x}}
+
+  interp create busy
+  interp limit busy commands -value 50
+  must 50 [interp limit busy commands -value]
+  mustfail {interp eval busy {while 1 {}}}
+  interp limit busy commands -value {}
+  must {-value {}} [interp limit busy commands]
+  must 45 [interp eval busy {set n 0; while {$n < 9} {incr n}; expr {$n * 5}}]
+
+  interp create slow
+  interp limit slow time -seconds [expr {[clock seconds] - 1}]
+  mustfail {slow eval {while 1 {}}}
+  interp limit slow time -seconds {}
+  must {-seconds {} -milliseconds {}} [interp limit slow time]
+
+  # The queried form sets the same limit back.
+  interp limit slow time -seconds 2000000000 -milliseconds 250
+  set lim [interp limit slow time]
+  must {-seconds 2000000000 -milliseconds 250} $lim
+  interp limit slow time -seconds {}
+  eval interp limit slow time $lim
+  must $lim [interp limit slow time]
+  interp limit slow time -milliseconds 500
+  must {-seconds 2000000000 -milliseconds 500} [interp limit slow time]
+
+  # A child cannot lift its own limits.
+  interp create -safe jail
+  interp limit jail commands -value 50
+  mustfail {interp eval jail {interp limit {} commands -value {}}}
+  mustfail {interp eval jail {interp limit {} time -seconds {}}}
+  must {} [interp eval jail {interp limit {} time -seconds}]
+  mustfail {interp eval jail {set n 0; while {$n < 1000} {incr n}}}
+`
+
+func TestInterp(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(interpTests))
+}
@@ -5,6 +5,8 @@ import (
 	. "fmt"
 	"regexp"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Token uint8
@@ -344,60 +346,90 @@ func (lex *Lex) Stretch1() {
 	lex.Next++
 }
 
-// StretchBackslashEscaped stretches the Next pointer across \C or \ooo for octal.
-func (lex *Lex) StretchBackslashEscaped() byte {
-	s := lex.Str
+// StretchBackslashEscaped stretches the Next pointer across \C, \ooo for octal,
+// \xHH, \uHHHH, or \UHHHHHHHH, returning the character.
+func (lex *Lex) StretchBackslashEscaped() rune {
 	MustB('\\', lex.PeekNext())
 
 	if lex.Next+1 >= lex.Len {
 		panic("EOS after escaping backslash")
 	}
 
-	switch s[lex.Next+1] {
+	r, next := DecodeBackslash(lex.Str, lex.Next)
+	lex.Next = next
+	return r
+}
+
+// DecodeBackslash decodes the backslash sequence starting at s[i],
+// returning the character and the index just after the sequence.
+// Like Tcl, octal takes 1 to 3 digits, \x takes 1 or 2 hex digits,
+// \u takes 1 to 4, and \U takes 1 to 8.  With no digits, the escaped
+// letter stands for itself.
+func DecodeBackslash(s string, i int) (rune, int) {
+	if i+1 >= len(s) {
+		return '\\', i + 1 // Lone backslash at end of string.
+	}
+	switch s[i+1] {
 	case 'a':
-		lex.Next += 2
-		return '\a'
+		return '\a', i + 2
 	case 'b':
-		lex.Next += 2
-		return '\b'
+		return '\b', i + 2
 	case 'f':
-		lex.Next += 2
-		return '\f'
+		return '\f', i + 2
 	case 'n':
-		lex.Next += 2
-		return '\n'
+		return '\n', i + 2
 	case 'r':
-		lex.Next += 2
-		return '\r'
+		return '\r', i + 2
 	case 't':
-		lex.Next += 2
-		return '\t'
+		return '\t', i + 2
 	case 'v':
-		lex.Next += 2
-		return '\v'
+		return '\v', i + 2
 	case 'x':
-		panic("Hexadecimal Backslash Escapes not supported (yet)")
+		return decodeDigits(s, i, 16, 2)
+	case 'u':
+		return decodeDigits(s, i, 16, 4)
+	case 'U':
+		return decodeDigits(s, i, 16, 8)
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		return decodeDigits(s, i-1, 8, 3) // i-1 because octal has no letter.
 	}
-	if s[lex.Next+1] < '0' || s[lex.Next+1] > '7' {
-		// Default for all other cases is the escaped char.
-		z := s[lex.Next+1]
-		lex.Next += 2
-		return z
+	// Default for all other cases is the escaped char.
+	r, size := utf8.DecodeRuneInString(s[i+1:])
+	return r, i + 1 + size
+}
+
+// decodeDigits decodes up to max digits in the base,
+// starting at s[i+2], after a backslash and a letter.
+func decodeDigits(s string, i int, base rune, max int) (rune, int) {
+	var z rune
+	j := i + 2
+	for j < len(s) && j < i+2+max {
+		d := digitValue(s[j])
+		if d >= base {
+			break
+		}
+		z = z*base + d
+		j++
 	}
-	if lex.Next+3 >= lex.Len {
-		panic("EOS in octal escape")
+	if j == i+2 {
+		return rune(s[i+1]), i + 2 // No digits; the letter stands for itself.
 	}
-	if s[lex.Next+2] < '0' || s[lex.Next+2] > '7' {
-		panic(Sprintf("Second character after backslash is not octal %q.", s[lex.Next:lex.Next+3]))
+	if z > unicode.MaxRune {
+		z = unicode.ReplacementChar
 	}
-	if s[lex.Next+3] < '0' || s[lex.Next+3] > '7' {
-		panic(Sprintf("Third character after backslash is not octal %q.", s[lex.Next:lex.Next+4]))
+	return z, j
+}
+
+func digitValue(c byte) rune {
+	switch {
+	case '0' <= c && c <= '9':
+		return rune(c - '0')
+	case 'a' <= c && c <= 'f':
+		return rune(c-'a') + 10
+	case 'A' <= c && c <= 'F':
+		return rune(c-'A') + 10
 	}
-	a := s[lex.Next+1] - '0'
-	b := s[lex.Next+2] - '0'
-	c := s[lex.Next+3] - '0'
-	lex.Next += 4
-	return byte(a*64 + b*8 + c)
+	return 99
 }
 
 /* purify
@@ -0,0 +1,77 @@
+# listutil -- list helpers written in Tcl.
+
+package provide listutil 1.0
+
+# Returns the list of integers from 0 to n-1.
+proc listutil::iota {n} {
+  set z {}
+  set i 0
+  while {$i < $n} {
+    lappend z $i
+    incr i
+  }
+  set z
+}
+
+# Returns the list with nested lists expanded by one level.
+proc listutil::flatten {list} {
+  set z {}
+  foreach e $list {
+    foreach x $e {
+      lappend z $x
+    }
+  }
+  set z
+}
+
+# Returns the list without repeated elements, keeping the first of each.
+proc listutil::unique {list} {
+  set z {}
+  foreach e $list {
+    if {![info exists seen($e)]} {
+      set seen($e) 1
+      lappend z $e
+    }
+  }
+  set z
+}
+
+# Returns the elements for which the lambda (as for apply) is true.
+proc listutil::filter {list lambda} {
+  set z {}
+  foreach e $list {
+    if {[apply $lambda $e]} {
+      lappend z $e
+    }
+  }
+  set z
+}
+
+# Returns the results of applying the lambda (as for apply) to each element.
+proc listutil::map {list lambda} {
+  set z {}
+  foreach e $list {
+    lappend z [apply $lambda $e]
+  }
+  set z
+}
+
+# Returns the sum of the elements.
+proc listutil::sum {list} {
+  set z 0
+  foreach e $list {
+    set z [expr {$z + $e}]
+  }
+  set z
+}
+
+# Returns a list alternating elements of a and b.
+proc listutil::zip {a b} {
+  set z {}
+  set i 0
+  foreach x $a {
+    lappend z $x [lindex $b $i]
+    incr i
+  }
+  set z
+}
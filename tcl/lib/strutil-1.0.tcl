@@ -0,0 +1,45 @@
+# strutil -- string helpers written in Tcl.
+
+package provide strutil 1.0
+
+# Returns s repeated n times.
+proc strutil::repeat {s n} {
+  set z ""
+  while {$n > 0} {
+    append z $s
+    incr n -1
+  }
+  set z
+}
+
+# Returns s padded on the left with c to at least width characters.
+proc strutil::padleft {s width {c { }}} {
+  set n [expr {$width - [string length $s]}]
+  return "[strutil::repeat $c $n]$s"
+}
+
+# Returns s padded on the right with c to at least width characters.
+proc strutil::padright {s width {c { }}} {
+  set n [expr {$width - [string length $s]}]
+  return "$s[strutil::repeat $c $n]"
+}
+
+proc strutil::startswith {s prefix} {
+  string match "$prefix*" $s
+}
+
+proc strutil::endswith {s suffix} {
+  string match "*$suffix" $s
+}
+
+
+# Returns s with its characters in reverse order.
+proc strutil::reverse {s} {
+  set z ""
+  set i [string length $s]
+  while {$i > 0} {
+    incr i -1
+    append z [string index $s $i]
+  }
+  set z
+}
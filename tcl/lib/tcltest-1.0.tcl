@@ -0,0 +1,89 @@
+# tcltest -- a small harness compatible with the common uses of Tcl's tcltest.
+#
+#   test name description ?-setup s? ?-body b? ?-cleanup c? ?-result r? ?-returnCodes codes?
+#   test name description body result
+#   cleanupTests
+#
+# Scripts run at global level.  For errors, the result is compared
+# with the first line of the error message.
+
+package provide tcltest 1.0
+
+set TcltestPassed 0
+set TcltestFailed 0
+set TcltestFailures {}
+
+proc tcltest::code {name} {
+  set codes [hash ok 0 error 1 return 2 break 3 continue 4]
+  if {[catch {hget $codes $name} z]} {
+    return $name
+  }
+  return $z
+}
+
+proc test {name description args} {
+  set setup ""
+  set body ""
+  set cleanup ""
+  set result ""
+  set returnCodes {ok return}
+  if {[llength $args] == 2} {
+    set body [lindex $args 0]
+    set result [lindex $args 1]
+  } else {
+    set i 0
+    while {$i < [llength $args]} {
+      set opt [lindex $args $i]
+      set val [lindex $args [expr {$i + 1}]]
+      case $opt in {
+        -setup {set setup $val}
+        -body {set body $val}
+        -cleanup {set cleanup $val}
+        -result {set result $val}
+        -returnCodes {set returnCodes $val}
+        default {error "test $name: bad option $opt"}
+      }
+      incr i 2
+    }
+  }
+
+  uplevel #0 $setup
+  set code [catch {uplevel #0 $body} got]
+  uplevel #0 $cleanup
+  if {$code == 1} {
+    set got [lindex [split $got "\n"] 0]
+  }
+
+  set okCode 0
+  foreach c $returnCodes {
+    if {[tcltest::code $c] == $code} {
+      set okCode 1
+    }
+  }
+  if {$okCode && $got eq $result} {
+    incr TcltestPassed
+    return
+  }
+  incr TcltestFailed
+  lappend TcltestFailures $name
+  echo "==== $name $description FAILED"
+  echo "---- Result was: $got"
+  echo "---- Result should have been: $result"
+  if {!$okCode} {
+    echo "---- Return code was: $code"
+  }
+}
+
+# Prints a summary, resets the counts, and returns the number failed.
+proc cleanupTests {} {
+  set total [expr {$TcltestPassed + $TcltestFailed}]
+  echo "Total $total Passed $TcltestPassed Failed $TcltestFailed"
+  if {$TcltestFailed > 0} {
+    echo "Failed: $TcltestFailures"
+  }
+  set z $TcltestFailed
+  set TcltestPassed 0
+  set TcltestFailed 0
+  set TcltestFailures {}
+  return $z
+}
@@ -0,0 +1,68 @@
+package tcl
+
+import (
+	"embed"
+	. "fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Library is the Tcl source of a package that "package require" can load
+// without any file access, so it works in safe interpreters too.
+type Library struct {
+	Name    string
+	Version string
+	Script  string
+}
+
+// Libraries are registered in init(), like Safes, by LibraryKey,
+// so several versions of a library may be registered.
+// Each new interpreter gets a "package ifneeded" for each of them.
+var Libraries map[string]*Library
+
+// LibraryKey is the key of a library in Libraries, like "name-1.0".
+func LibraryKey(name, version string) string {
+	return name + "-" + version
+}
+
+// AddLibraries registers every file in dir of fsys named "name-version.tcl",
+// usually from a go:embed filesystem.
+func AddLibraries(fsys fs.FS, dir string) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		panic(Sprintf("AddLibraries: %v", err))
+	}
+	for _, e := range entries {
+		base := e.Name()
+		if e.IsDir() || !strings.HasSuffix(base, ".tcl") {
+			continue
+		}
+		stem := strings.TrimSuffix(base, ".tcl")
+		i := strings.LastIndexByte(stem, '-')
+		if i < 1 {
+			panic(Sprintf("AddLibraries: expected name-version.tcl, got %q", base))
+		}
+		bb, err := fs.ReadFile(fsys, path.Join(dir, base))
+		if err != nil {
+			panic(Sprintf("AddLibraries: %v", err))
+		}
+		AddLibrary(&Library{Name: stem[:i], Version: stem[i+1:], Script: string(bb)})
+	}
+}
+
+// AddLibrary registers one library.
+func AddLibrary(lib *Library) {
+	if Libraries == nil {
+		Libraries = make(map[string]*Library)
+	}
+	parseVersion(lib.Version)
+	Libraries[LibraryKey(lib.Name, lib.Version)] = lib
+}
+
+//go:embed lib/*.tcl
+var libFS embed.FS
+
+func init() {
+	AddLibraries(libFS, "lib")
+}
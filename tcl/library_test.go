@@ -0,0 +1,47 @@
+package tcl
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+var libraryTests = `
+  must 1.0 [package require listutil]
+  must {0 1 2 3} [listutil::iota 4]
+  must 6 [listutil::sum {1 2 3}]
+  must {a b c} [listutil::unique {a b a c b}]
+  must {a b c d} [listutil::flatten {{a b} c {d}}]
+  must {1 3} [listutil::filter {1 2 3 4} {x {expr {$x % 2}}}]
+  must {10 20} [listutil::map {1 2} {x {expr {$x * 10}}}]
+  must {a 1 b 2} [listutil::zip {a b} {1 2}]
+
+  must 1.0 [package require strutil 1]
+  must 007 [strutil::padleft 7 3 0]
+  must "ab  " [strutil::padright ab 4]
+  must 1 [strutil::startswith hello he]
+  must 0 [strutil::endswith hello he]
+  must cba [strutil::reverse abc]
+
+  must 1.0 [package require tcltest]
+  test t1 simple {expr 1+1} 2
+  test t2 options -setup {set V 5} -body {incr V} -cleanup {unset V} -result 6
+  test t3 error -body {error boom} -returnCodes error -result boom
+  must 3 $TcltestPassed
+  must 0 $TcltestFailed
+
+  must {2.5 3.0} [lsort [package versions mylib]]
+  must 2.5 [package require -exact mylib 2.5]
+  must hello [mylib::hello]
+`
+
+func TestLibrary(a *testing.T) {
+	AddLibraries(fstest.MapFS{
+		"lib/mylib-2.5.tcl": {Data: []byte("package provide mylib 2.5; proc mylib::hello {} {return hello}")},
+		"lib/mylib-3.0.tcl": {Data: []byte("package provide mylib 3.0")},
+	}, "lib")
+	defer delete(Libraries, LibraryKey("mylib", "2.5"))
+	defer delete(Libraries, LibraryKey("mylib", "3.0"))
+
+	fr := NewSafeInterpreter()
+	fr.Eval(MkString(libraryTests))
+}
@@ -0,0 +1,182 @@
+package tcl
+
+import (
+	"context"
+	. "fmt"
+	"time"
+)
+
+// Limits stop runaway scripts.  They are fields of Global, set from Go or
+// by "interp limit", and checked before each command and each loop iteration:
+//
+//	CommandLimit  total commands (and loop iterations) evaluated
+//	TimeLimit     wall-clock deadline
+//	Context       cancellation, e.g. by context.WithTimeout
+//	DepthLimit    nesting of proc calls
+//	AllocLimit    total size of command results, approximating allocation
+//
+// A child interpreter's commands count against the limits of its parents
+// too, so it cannot escape them.  So do the commands of tasks started by
+// "go", through budgets shared with the interpreters that started them
+// (see task.go), which also limit how many tasks may run at once.
+//
+// Exceeding a limit panics with a LimitExceeded, which "catch" within the
+// same interpreter (or its children) cannot catch, so the script really stops.
+
+// limitGranularity is how many commands run between checks of the clock and context.
+const limitGranularity = 100
+
+// LimitExceeded is panicked when an interpreter exceeds one of its limits.
+type LimitExceeded struct {
+	G    *Global // Interpreter that exceeded its limit.
+	Kind string  // "commands", "time", "context", "depth", or "alloc".
+}
+
+func (e LimitExceeded) String() string {
+	return Sprintf("limit exceeded: %s", e.Kind)
+}
+
+// IsLimitExceeded tells if a recovered value is a LimitExceeded.
+func IsLimitExceeded(r interface{}) bool {
+	_, ok := r.(LimitExceeded)
+	return ok
+}
+
+// stops tells if the limit stops the interpreter, because it is the
+// interpreter's own limit or the limit of one of its parents.
+func (e LimitExceeded) stops(g *Global) bool {
+	for ; g != nil; g = g.Parent {
+		if e.G == g {
+			return true
+		}
+		for _, b := range g.budgets {
+			if e.G == b.g {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CheckLimits is called before each command, and panics if the
+// interpreter or one of its parents has exceeded its command, time,
+// or context limit.
+func (g *Global) CheckLimits() {
+	for ; g != nil; g = g.Parent {
+		g.CommandCount++
+		for _, b := range g.budgets {
+			b.charge(&b.commands, 1, "commands")
+		}
+		if g.CommandLimit > 0 && g.CommandCount > g.CommandLimit {
+			panic(LimitExceeded{G: g, Kind: "commands"})
+		}
+		if g.CommandCount%limitGranularity == 0 {
+			g.checkTime()
+		}
+	}
+}
+
+// checkTime panics if the interpreter has passed its time or context limit.
+func (g *Global) checkTime() {
+	if !g.TimeLimit.IsZero() && time.Now().After(g.TimeLimit) {
+		panic(LimitExceeded{G: g, Kind: "time"})
+	}
+	if g.Context != nil && g.Context.Err() != nil {
+		panic(LimitExceeded{G: g, Kind: "context"})
+	}
+}
+
+// CheckTimeLimits panics if the interpreter or one of its parents has
+// passed its time or context limit, for Go code that has stopped waiting
+// because of a context from LimitContext.
+func (g *Global) CheckTimeLimits() {
+	for ; g != nil; g = g.Parent {
+		g.checkTime()
+	}
+}
+
+// LimitContext returns a context that is done when the time or context
+// limit of the interpreter or one of its parents passes, for Go code
+// that blocks, and the function to release it.
+func (g *Global) LimitContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var deadline time.Time
+	for ; g != nil; g = g.Parent {
+		if !g.TimeLimit.IsZero() && (deadline.IsZero() || g.TimeLimit.Before(deadline)) {
+			deadline = g.TimeLimit
+		}
+		if g.Context != nil {
+			go func(done <-chan struct{}) {
+				select {
+				case <-done:
+					cancel()
+				case <-ctx.Done():
+				}
+			}(g.Context.Done())
+		}
+	}
+	if deadline.IsZero() {
+		return ctx, cancel
+	}
+	dctx, dcancel := context.WithDeadline(ctx, deadline)
+	return dctx, func() {
+		dcancel()
+		cancel()
+	}
+}
+
+// checkDepth is called when making a frame at the given depth.
+func (g *Global) checkDepth(depth int) {
+	for ; g != nil; g = g.Parent {
+		if g.DepthLimit > 0 && depth > g.DepthLimit {
+			panic(LimitExceeded{G: g, Kind: "depth"})
+		}
+	}
+}
+
+// allocLimited tells if command results must be charged with chargeAlloc.
+func (g *Global) allocLimited() bool {
+	return g.AllocLimit > 0 || g.Parent != nil || g.budgets != nil
+}
+
+// chargeAlloc adds the size of a command result to AllocCount,
+// of the interpreter and of its parents.
+func (g *Global) chargeAlloc(t T) {
+	var n int64
+	switch {
+	case t.IsQuickList():
+		n = int64(16 * len(t.List()))
+	case t.IsQuickHash():
+		n = int64(32 * len(t.Hash()))
+	case t.IsQuickString():
+		n = int64(len(t.String()))
+	}
+	for ; g != nil; g = g.Parent {
+		g.AllocCount += n
+		for _, b := range g.budgets {
+			b.charge(&b.alloc, n, "alloc")
+		}
+		if g.AllocLimit > 0 && g.AllocCount > g.AllocLimit {
+			panic(LimitExceeded{G: g, Kind: "alloc"})
+		}
+	}
+}
+
+// SetContext makes evaluation stop when the context is done,
+// and sets TimeLimit from the context's deadline, if any.
+func (g *Global) SetContext(ctx context.Context) {
+	g.Context = ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		g.TimeLimit = deadline
+	}
+}
+
+// ResetLimits removes all limits and zeroes the counts.
+func (g *Global) ResetLimits() {
+	g.Context = nil
+	g.CommandCount, g.CommandLimit = 0, 0
+	g.TimeLimit = time.Time{}
+	g.DepthLimit = 0
+	g.AllocCount, g.AllocLimit = 0, 0
+	g.resetBudget()
+}
@@ -0,0 +1,86 @@
+package tcl
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// evalLimited evaluates the script, returning what it panicked with.
+func evalLimited(fr *Frame, script string) (r interface{}) {
+	defer func() { r = recover() }()
+	fr.Eval(MkString(script))
+	return nil
+}
+
+func expectLimit(a *testing.T, kind string, r interface{}) {
+	e, ok := r.(LimitExceeded)
+	if !ok || e.Kind != kind {
+		a.Errorf("expected limit %q, got %v", kind, r)
+	}
+}
+
+func TestLimits(a *testing.T) {
+	fr := NewInterpreter()
+	fr.G.CommandLimit = 1000
+	expectLimit(a, "commands", evalLimited(fr, `while 1 {}`))
+	// A script cannot catch its own limit.
+	expectLimit(a, "commands", evalLimited(fr, `catch {while 1 {}}`))
+
+	fr = NewInterpreter()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	fr.G.SetContext(ctx)
+	start := time.Now()
+	expectLimit(a, "time", evalLimited(fr, `set i 0; while 1 {incr i}`))
+	if d := time.Since(start); d > time.Second {
+		a.Errorf("took too long to stop: %v", d)
+	}
+
+	fr = NewInterpreter()
+	ctx, cancel = context.WithCancel(context.Background())
+	fr.G.SetContext(ctx)
+	cancel()
+	expectLimit(a, "context", evalLimited(fr, `foreach x {1 2 3 4 5 6 7 8 9 10} {while 1 {}}`))
+
+	fr = NewInterpreter()
+	fr.G.DepthLimit = 50
+	expectLimit(a, "depth", evalLimited(fr, `proc down {n} {down [expr {$n + 1}]}; down 0`))
+	fr.G.ResetLimits()
+	if r := evalLimited(fr, `proc down10 {n} {if {$n < 10} {down10 [expr {$n + 1}]}}; down10 0`); r != nil {
+		a.Errorf("after ResetLimits: %v", r)
+	}
+
+	fr = NewInterpreter()
+	fr.G.AllocLimit = 100000
+	expectLimit(a, "alloc", evalLimited(fr, `set s x; while 1 {append s $s}`))
+
+	// A parent can catch the limit of a child.
+	fr = NewInterpreter()
+	fr.Eval(MkString(`
+		interp create kid
+		interp limit kid commands -value 100
+		must 1 [catch {interp eval kid {while 1 {}}} msg]
+		must "limit exceeded: commands" $msg
+	`))
+
+	// A child cannot escape the limits of its parent, even by catching.
+	fr = NewInterpreter()
+	fr.G.CommandLimit = 1000
+	expectLimit(a, "commands", evalLimited(fr, `
+		interp create kid
+		catch {interp eval kid {catch {while 1 {}}}}
+	`))
+	fr = NewInterpreter()
+	fr.G.DepthLimit = 50
+	expectLimit(a, "depth", evalLimited(fr, `
+		interp create kid
+		interp eval kid {proc down {n} {down [expr {$n + 1}]}; down 0}
+	`))
+	fr = NewInterpreter()
+	fr.G.AllocLimit = 100000
+	expectLimit(a, "alloc", evalLimited(fr, `
+		interp create kid
+		interp eval kid {set s x; while 1 {append s $s}}
+	`))
+}
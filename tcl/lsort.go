@@ -0,0 +1,288 @@
+package tcl
+
+import (
+	. "fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	lsortAscii = iota
+	lsortDictionary
+	lsortInteger
+	lsortReal
+	lsortCommand
+)
+
+var lsortOptions = []string{
+	"-ascii", "-command", "-decreasing", "-dictionary", "-increasing", "-index",
+	"-indices", "-integer", "-nocase", "-real", "-stride", "-unique",
+}
+
+type lsorter struct {
+	fr         *Frame
+	mode       int
+	nocase     bool
+	descending bool
+	unique     bool
+	indices    bool
+	command    []T // command prefix for -command
+	index      []T // index path for -index
+	stride     int
+}
+
+// lsortItem is a list element (or group, with -stride) with its key
+// extracted before sorting, so comparisons need not convert anything.
+type lsortItem struct {
+	pos int // position in the original list
+	key T   // for -command
+	str string
+	i   int64
+	f   float64
+}
+
+func (o *lsorter) compare(a, b *lsortItem) int {
+	var c int
+	switch o.mode {
+	case lsortInteger:
+		c = compareOrdered(a.i, b.i)
+	case lsortReal:
+		c = compareOrdered(a.f, b.f)
+	case lsortDictionary:
+		c = DictionaryCompare(a.str, b.str)
+	case lsortCommand:
+		c = o.callCommand(a.key, b.key)
+	default:
+		c = strings.Compare(a.str, b.str)
+	}
+	if o.descending {
+		c = -c
+	}
+	return c
+}
+
+func (o *lsorter) callCommand(a, b T) int {
+	argv := append(append([]T(nil), o.command...), a, b)
+	z := argv[0].Apply(o.fr, argv)
+	defer func() {
+		if r := recover(); r != nil {
+			panic(Sprintf("lsort: -command returned non-integer result %q", z.String()))
+		}
+	}()
+	return int(FormatInt(z))
+}
+
+func compareOrdered[N int64 | float64](a, b N) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// keyOf applies the -index path to a list element.
+func (o *lsorter) keyOf(t T) T {
+	for _, ix := range o.index {
+		vec := t.List()
+		i := ParseIndex(ix, len(vec))
+		if i < 0 || i >= len(vec) {
+			panic(Sprintf("element %d missing from sublist %q", i, t.String()))
+		}
+		t = vec[i]
+	}
+	return t
+}
+
+func (o *lsorter) makeItem(pos int, t T) *lsortItem {
+	key := o.keyOf(t)
+	z := &lsortItem{pos: pos, key: key}
+	switch o.mode {
+	case lsortInteger:
+		z.i = FormatInt(key)
+	case lsortReal:
+		z.f = FormatFloat(key)
+	case lsortCommand:
+	default:
+		z.str = key.String()
+		if o.nocase && o.mode == lsortAscii {
+			z.str = strings.ToLower(z.str)
+		}
+	}
+	return z
+}
+
+func cmdLSort(fr *Frame, argv []T) T {
+	// lsort ?options? list
+	if len(argv) < 2 {
+		panic("lsort needs a list arg")
+	}
+
+	o := &lsorter{fr: fr, mode: lsortAscii, stride: 1}
+	opts := argv[1 : len(argv)-1] // Remove cmd name (first) and the list (final).
+	for len(opts) > 0 {
+		opt := MatchOption("lsort", opts[0].String(), lsortOptions)
+		opts = opts[1:]
+		switch opt {
+		case "-ascii":
+			o.mode = lsortAscii
+		case "-dictionary":
+			o.mode = lsortDictionary
+		case "-integer":
+			o.mode = lsortInteger
+		case "-real":
+			o.mode = lsortReal
+		case "-increasing":
+			o.descending = false
+		case "-decreasing":
+			o.descending = true
+		case "-nocase":
+			o.nocase = true
+		case "-unique":
+			o.unique = true
+		case "-indices":
+			o.indices = true
+		case "-command", "-index", "-stride":
+			if len(opts) == 0 {
+				panic(Sprintf("lsort: %q option must be followed by a value", opt))
+			}
+			val := opts[0]
+			opts = opts[1:]
+			switch opt {
+			case "-command":
+				o.mode = lsortCommand
+				o.command = val.List()
+				if len(o.command) == 0 {
+					panic("lsort: empty -command")
+				}
+			case "-index":
+				o.index = val.List()
+			case "-stride":
+				o.stride = int(FormatInt(val))
+				if o.stride < 2 {
+					panic("lsort: stride length must be at least 2")
+				}
+			}
+		}
+	}
+
+	vec := argv[len(argv)-1].List()
+	if len(vec)%o.stride != 0 {
+		panic("lsort: list size must be a multiple of the stride length")
+	}
+	if o.stride > 1 && len(o.index) > 0 {
+		if i := ParseIndex(o.index[0], o.stride); i < 0 || i >= o.stride {
+			panic("lsort: when used with \"-stride\", the leading \"-index\" value must be within the group")
+		}
+	}
+
+	items := make([]*lsortItem, 0, len(vec)/o.stride)
+	for k := 0; k < len(vec); k += o.stride {
+		var t T = vec[k]
+		if o.stride > 1 {
+			if len(o.index) == 0 {
+				o.index = []T{MkInt(0)}
+			}
+			t = MkList(vec[k : k+o.stride])
+		}
+		items = append(items, o.makeItem(k, t))
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return o.compare(items[i], items[j]) < 0
+	})
+
+	if o.unique {
+		// Of equal items, keep the last one.
+		kept := items[:0]
+		for i, e := range items {
+			if i+1 < len(items) && o.compare(e, items[i+1]) == 0 {
+				continue
+			}
+			kept = append(kept, e)
+		}
+		items = kept
+	}
+
+	z := make([]T, 0, len(vec))
+	for _, e := range items {
+		if o.indices {
+			z = append(z, MkInt(int64(e.pos)))
+		} else {
+			z = append(z, vec[e.pos:e.pos+o.stride]...)
+		}
+	}
+	return MkList(z)
+}
+
+// DictionaryCompare compares like "lsort -dictionary": case is ignored
+// except as a tie-breaker (upper before lower), and embedded numbers
+// compare as integers.
+func DictionaryCompare(a, b string) int {
+	tie := 0
+	for len(a) > 0 && len(b) > 0 {
+		if isDigitByte(a[0]) && isDigitByte(b[0]) {
+			// Skip leading zeros, remembering which had more.
+			zeros := 0
+			for len(a) > 1 && a[0] == '0' && isDigitByte(a[1]) {
+				a = a[1:]
+				zeros++
+			}
+			for len(b) > 1 && b[0] == '0' && isDigitByte(b[1]) {
+				b = b[1:]
+				zeros--
+			}
+			if tie == 0 {
+				tie = zeros
+			}
+			// The longer run of digits is the bigger number;
+			// if the same length, the first differing digit decides.
+			diff := 0
+			i := 0
+			for ; i < len(a) && i < len(b) && isDigitByte(a[i]) && isDigitByte(b[i]); i++ {
+				if diff == 0 {
+					diff = int(a[i]) - int(b[i])
+				}
+			}
+			aMore := i < len(a) && isDigitByte(a[i])
+			bMore := i < len(b) && isDigitByte(b[i])
+			switch {
+			case aMore && !bMore:
+				return 1
+			case bMore && !aMore:
+				return -1
+			case diff != 0:
+				return diff
+			}
+			a, b = a[i:], b[i:]
+			continue
+		}
+
+		ra, sa := utf8.DecodeRuneInString(a)
+		rb, sb := utf8.DecodeRuneInString(b)
+		a, b = a[sa:], b[sb:]
+		if ra != rb {
+			la, lb := unicode.ToLower(ra), unicode.ToLower(rb)
+			if la != lb {
+				return int(la) - int(lb)
+			}
+			if tie == 0 {
+				if unicode.IsUpper(ra) {
+					tie = -1
+				} else {
+					tie = 1
+				}
+			}
+		}
+	}
+	switch {
+	case len(a) > 0:
+		return 1
+	case len(b) > 0:
+		return -1
+	}
+	return tie
+}
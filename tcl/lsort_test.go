@@ -0,0 +1,60 @@
+package tcl
+
+import (
+	"testing"
+)
+
+var lsortTests = `
+	must {B2 a1 a10 a2 b1} [lsort {a10 B2 b1 a1 a2}]
+	must {a1 a2 a10 b1 B2} [lsort -dictionary {a10 B2 b1 a1 a2}]
+	must {x1 x01 x001 X2} [lsort -dictionary {x001 X2 x01 x1}]
+	must {Abc abc ABd} [lsort -dictionary {abc ABd Abc}]
+	must {a1 a10 a2 b1 B2} [lsort -nocase {a10 B2 b1 a1 a2}]
+	must {2 10 33} [lsort -integer {33 10 2}]
+	must {33 10 2} [lsort -integer -decreasing {33 10 2}]
+	must {0.5 1.25 10} [lsort -real {10 1.25 0.5}]
+	must {a b c} [lsort -unique {c a b a c}]
+	must {1 2 0} [lsort -indices {c a b}]
+
+	# Stable: equal keys keep their order.
+	must {{a 1} {b 1} {c 1} {a 2}} [lsort -integer -index 1 {{a 1} {a 2} {b 1} {c 1}}]
+	must {{c 1} {b 1} {a 1}} [lsort -decreasing -index 0 {{b 1} {a 1} {c 1}}]
+
+	# Nested index paths and end.
+	must "y {2 a}" [lindex [lsort -index {1 end} {{y {2 a}} {x {1 b}}}] 0]
+	must "x {1 b}" [lindex [lsort -index {1 0} -integer {{y {2 a}} {x {1 b}}}] 0]
+
+	# Sorting rows by multiple columns: sort by the minor key first.
+	set rows {{bob 30} {amy 25} {cat 30} {dan 25}}
+	set rows [lsort -index 0 $rows]
+	must {{amy 25} {dan 25} {bob 30} {cat 30}} [lsort -integer -index 1 $rows]
+
+	must {a 3 b 1 c 2} [lsort -stride 2 {c 2 a 3 b 1}]
+	must {b 1 c 2 a 3} [lsort -stride 2 -index 1 -integer {c 2 a 3 b 1}]
+	must {2 4 0} [lsort -stride 2 -indices {c 2 a 3 b 1}]
+
+	must {3 2 1} [lsort -command {apply {{a b} {expr {$b - $a}}}} {1 3 2}]
+	proc bylen {a b} { expr {[string length $a] - [string length $b]} }
+	must {z yy xxx} [lsort -command bylen {xxx z yy}]
+	must {xxx yy z} [lsort -command bylen -decreasing {xxx z yy}]
+
+	# The input list is not changed.
+	set orig {c b a}
+	lsort $orig
+	must {c b a} $orig
+
+	must {b c} [lrange {a b c} end-1 end]
+	must b [lindex {a b c} end-1]
+	must c [lindex {a b c} 1+1]
+
+	mustfail {lsort -integer {1 x}}
+	mustfail {lsort -stride 2 {a b c}}
+	mustfail {lsort -bogus {a b}}
+	mustfail {lsort -in {a b}}
+	mustfail {lsort -command {apply {{a b} {list x}}} {1 2}}
+`
+
+func TestLSort(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(lsortTests))
+}
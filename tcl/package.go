@@ -0,0 +1,366 @@
+package tcl
+
+import (
+	. "fmt"
+	"strconv"
+	"strings"
+)
+
+// Packages are registered by "package provide" and found by "package require",
+// either because they are already provided, or by evaluating a script
+// registered with "package ifneeded", or by asking the "package unknown"
+// handler to register more scripts (typically by reading pkgIndex.tcl files).
+// Scripts and the handler run in the global frame, with only the capabilities
+// both of the frame requiring the package and of the frame that registered them.
+
+// DefaultPackageUnknown is the handler "package require" uses when none has
+// been set with "package unknown".  The posix package defines it, to search
+// the auto_path for pkgIndex.tcl files.
+const DefaultPackageUnknown = "tclPkgUnknown"
+
+type packageInfo struct {
+	provided string          // version provided, or empty
+	ifneeded map[string]T    // scripts to provide each version
+	creds    map[string]Hash // Cred that registered each script
+}
+
+func (g *Global) pkg(name string, create bool) *packageInfo {
+	p := g.Packages[name]
+	if p == nil && create {
+		if g.Packages == nil {
+			g.Packages = make(map[string]*packageInfo)
+		}
+		p = &packageInfo{ifneeded: make(map[string]T), creds: make(map[string]Hash)}
+		g.Packages[name] = p
+	}
+	return p
+}
+
+// parseVersion splits a version like "1.2.3" into its integers.
+func parseVersion(v string) []int {
+	if v == "" {
+		panic("expected version number but got \"\"")
+	}
+	parts := strings.Split(v, ".")
+	z := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || p[0] == '+' {
+			panic(Sprintf("expected version number but got %q", v))
+		}
+		z[i] = n
+	}
+	return z
+}
+
+// CompareVersions returns -1, 0, or 1 like "package vcompare".
+// Missing trailing components count as zero.
+func CompareVersions(a, b string) int {
+	x, y := parseVersion(a), parseVersion(b)
+	for i := 0; i < len(x) || i < len(y); i++ {
+		var p, q int
+		if i < len(x) {
+			p = x[i]
+		}
+		if i < len(y) {
+			q = y[i]
+		}
+		switch {
+		case p < q:
+			return -1
+		case p > q:
+			return 1
+		}
+	}
+	return 0
+}
+
+// VersionSatisfies tells if version v satisfies the requirement, which is
+// "min" (same major version, at least min), "min-" (at least min), or
+// "min-max" (at least min, less than max).
+func VersionSatisfies(v, req string) bool {
+	if i := strings.IndexByte(req, '-'); i >= 0 {
+		min, max := req[:i], req[i+1:]
+		if CompareVersions(v, min) < 0 {
+			return false
+		}
+		return max == "" || CompareVersions(v, max) < 0
+	}
+	if parseVersion(v)[0] != parseVersion(req)[0] {
+		return false
+	}
+	return CompareVersions(v, req) >= 0
+}
+
+func (p *packageInfo) accepts(v string, reqs []string, exact bool) bool {
+	if len(reqs) == 0 {
+		return true
+	}
+	for _, r := range reqs {
+		if exact && CompareVersions(v, r) == 0 || !exact && VersionSatisfies(v, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// bestIfNeeded returns the highest version with an ifneeded script
+// that is acceptable, or empty.
+func (p *packageInfo) bestIfNeeded(reqs []string, exact bool) string {
+	best := ""
+	for v := range p.ifneeded {
+		if p.accepts(v, reqs, exact) && (best == "" || CompareVersions(v, best) > 0) {
+			best = v
+		}
+	}
+	return best
+}
+
+// RequirePackage loads the package if needed, and returns its version.
+func (fr *Frame) RequirePackage(name string, reqs []string, exact bool) string {
+	g := fr.G
+	for _, r := range reqs {
+		if exact {
+			parseVersion(r)
+		} else {
+			parseVersion(strings.SplitN(r, "-", 2)[0])
+		}
+	}
+	if p := g.pkg(name, false); p != nil && p.provided != "" {
+		if !p.accepts(p.provided, reqs, exact) {
+			panic(Sprintf("version conflict for package %q: have %s, need %s",
+				name, p.provided, strings.Join(reqs, " ")))
+		}
+		return p.provided
+	}
+
+	p := g.pkg(name, false)
+	if p == nil || p.bestIfNeeded(reqs, exact) == "" {
+		// Ask the unknown handler to register more ifneeded scripts.
+		handler := g.PackageUnknown
+		if handler == nil {
+			if _, ok := g.Cmds[DefaultPackageUnknown]; ok {
+				handler = MkString(DefaultPackageUnknown)
+			}
+		}
+		if handler != nil && !handler.IsEmpty() {
+			argv := append(append([]T(nil), handler.List()...), MkString(name))
+			for _, r := range reqs {
+				argv = append(argv, MkString(r))
+			}
+			g.Fr.WithCred(IntersectCred(fr.Cred, g.PackageUnknownCred), func() T {
+				return g.Fr.Apply(argv)
+			})
+		}
+		p = g.pkg(name, false)
+	}
+
+	v := ""
+	if p != nil {
+		v = p.bestIfNeeded(reqs, exact)
+	}
+	if v == "" {
+		if len(reqs) > 0 {
+			panic(Sprintf("can't find package %s %s", name, strings.Join(reqs, " ")))
+		}
+		panic(Sprintf("can't find package %s", name))
+	}
+
+	g.Fr.WithCred(IntersectCred(fr.Cred, p.creds[v]), func() T {
+		return g.Fr.Eval(p.ifneeded[v])
+	})
+	if p.provided == "" {
+		panic(Sprintf("attempt to provide package %s %s failed: no version of package %s provided", name, v, name))
+	}
+	if p.provided != v {
+		panic(Sprintf("attempt to provide package %s %s failed: package %s %s provided instead", name, v, name, p.provided))
+	}
+	return v
+}
+
+var packageEnsemble = []EnsembleItem{
+	EnsembleItem{Name: "provide", Cmd: cmdPackageProvide, Doc: "name ?version?"},
+	EnsembleItem{Name: "require", Cmd: cmdPackageRequire, Doc: "?-exact? name ?version ...?"},
+	EnsembleItem{Name: "ifneeded", Cmd: cmdPackageIfNeeded, Doc: "name version ?script?"},
+	EnsembleItem{Name: "present", Cmd: cmdPackagePresent, Doc: "?-exact? name ?version ...?"},
+	EnsembleItem{Name: "names", Cmd: cmdPackageNames},
+	EnsembleItem{Name: "versions", Cmd: cmdPackageVersions, Doc: "name"},
+	EnsembleItem{Name: "forget", Cmd: cmdPackageForget, Doc: "?name ...?"},
+	EnsembleItem{Name: "unknown", Cmd: cmdPackageUnknown, Doc: "?command?"},
+	EnsembleItem{Name: "vcompare", Cmd: cmdPackageVCompare, Doc: "version1 version2"},
+	EnsembleItem{Name: "vsatisfies", Cmd: cmdPackageVSatisfies, Doc: "version requirement ..."},
+}
+
+func cmdPackageProvide(fr *Frame, argv []T) T {
+	nameT, rest := Arg1v(argv)
+	name := nameT.String()
+	switch len(rest) {
+	case 0:
+		if p := fr.G.pkg(name, false); p != nil {
+			return MkString(p.provided)
+		}
+		return Empty
+	case 1:
+	default:
+		panic("Usage: package provide name ?version?")
+	}
+	v := rest[0].String()
+	parseVersion(v)
+	p := fr.G.pkg(name, true)
+	if p.provided != "" && CompareVersions(p.provided, v) != 0 {
+		panic(Sprintf("conflicting versions provided for package %q: %s, then %s", name, p.provided, v))
+	}
+	p.provided = v
+	return Empty
+}
+
+// requireArgs parses "?-exact? name ?version ...?".
+func requireArgs(argv []T) (name string, reqs []string, exact bool) {
+	args := argv[1:]
+	if len(args) > 0 && args[0].String() == "-exact" {
+		exact = true
+		args = args[1:]
+	}
+	if len(args) == 0 || exact && len(args) != 2 {
+		panic(Sprintf("Usage: package %s ?-exact? name ?version ...?", argv[0].String()))
+	}
+	for _, a := range args[1:] {
+		reqs = append(reqs, a.String())
+	}
+	return args[0].String(), reqs, exact
+}
+
+func cmdPackageRequire(fr *Frame, argv []T) T {
+	name, reqs, exact := requireArgs(argv)
+	return MkString(fr.RequirePackage(name, reqs, exact))
+}
+
+func cmdPackagePresent(fr *Frame, argv []T) T {
+	name, reqs, exact := requireArgs(argv)
+	p := fr.G.pkg(name, false)
+	if p == nil || p.provided == "" {
+		panic(Sprintf("package %s is not present", name))
+	}
+	if !p.accepts(p.provided, reqs, exact) {
+		panic(Sprintf("version conflict for package %q: have %s, need %s",
+			name, p.provided, strings.Join(reqs, " ")))
+	}
+	return MkString(p.provided)
+}
+
+func cmdPackageIfNeeded(fr *Frame, argv []T) T {
+	nameT, verT, rest := Arg2v(argv)
+	v := verT.String()
+	parseVersion(v)
+	switch len(rest) {
+	case 0:
+		if p := fr.G.pkg(nameT.String(), false); p != nil {
+			if script, ok := p.ifneeded[v]; ok {
+				return script
+			}
+		}
+		return Empty
+	case 1:
+	default:
+		panic("Usage: package ifneeded name version ?script?")
+	}
+	p := fr.G.pkg(nameT.String(), true)
+	p.ifneeded[v] = rest[0]
+	p.creds[v] = fr.Cred
+	return Empty
+}
+
+func cmdPackageNames(fr *Frame, argv []T) T {
+	Arg0(argv)
+	var zz []T
+	for k := range fr.G.Packages {
+		zz = append(zz, MkString(k))
+	}
+	SortListByString(zz)
+	return MkList(zz)
+}
+
+func cmdPackageVersions(fr *Frame, argv []T) T {
+	nameT := Arg1(argv)
+	var zz []T
+	if p := fr.G.pkg(nameT.String(), false); p != nil {
+		for v := range p.ifneeded {
+			zz = append(zz, MkString(v))
+		}
+	}
+	SortListByString(zz)
+	return MkList(zz)
+}
+
+func cmdPackageForget(fr *Frame, argv []T) T {
+	for _, a := range argv[1:] {
+		delete(fr.G.Packages, a.String())
+	}
+	return Empty
+}
+
+func cmdPackageUnknown(fr *Frame, argv []T) T {
+	rest := Arg0v(argv)
+	switch len(rest) {
+	case 0:
+		if fr.G.PackageUnknown == nil {
+			return Empty
+		}
+		return fr.G.PackageUnknown
+	case 1:
+		fr.G.PackageUnknown, fr.G.PackageUnknownCred = rest[0], fr.Cred
+		return Empty
+	}
+	panic("Usage: package unknown ?command?")
+}
+
+func cmdPackageVCompare(fr *Frame, argv []T) T {
+	a, b := Arg2(argv)
+	return MkInt(int64(CompareVersions(a.String(), b.String())))
+}
+
+func cmdPackageVSatisfies(fr *Frame, argv []T) T {
+	v, reqs := Arg1v(argv)
+	if len(reqs) == 0 {
+		panic("Usage: package vsatisfies version requirement ...")
+	}
+	for _, r := range reqs {
+		if VersionSatisfies(v.String(), r.String()) {
+			return True
+		}
+	}
+	return False
+}
+
+// AutoLoad asks the "auto_load" command, if there is one, to define
+// the named command.  It tells whether the command now exists.
+func (fr *Frame) AutoLoad(name string) bool {
+	g := fr.G
+	if name == "auto_load" || g.Cmds["auto_load"] == nil {
+		return false
+	}
+	if !g.Fr.Apply([]T{MkString("auto_load"), MkString(name)}).Bool() {
+		return false
+	}
+	return g.Cmds[name] != nil
+}
+
+func cmdInfoScript(fr *Frame, argv []T) T {
+	rest := Arg0v(argv)
+	switch len(rest) {
+	case 0:
+	case 1:
+		fr.G.Script = rest[0].String()
+	default:
+		panic("Usage: info script ?filename?")
+	}
+	return MkString(fr.G.Script)
+}
+
+func init() {
+	if Safes == nil {
+		Safes = make(map[string]Command, 333)
+	}
+
+	Safes["package"] = MkEnsemble(packageEnsemble)
+}
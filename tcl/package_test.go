@@ -0,0 +1,83 @@
+package tcl
+
+import (
+	"testing"
+)
+
+var packageTests = `
+  must "" [package provide alpha]
+  package provide alpha 1.5
+  must 1.5 [package provide alpha]
+  must 1.5 [package require alpha]
+  must 1.5 [package require alpha 1.2]
+  must 1.5 [package require -exact alpha 1.5]
+  mustfail {package require alpha 2}
+  mustfail {package require -exact alpha 1.4}
+  mustfail {package provide alpha 1.6}
+
+  package ifneeded beta 0.9 {error "should prefer 1.1"}
+  package ifneeded beta 1.1 {package provide beta 1.1; set BetaLoaded 1}
+  must "" [package provide beta]
+  must 1.1 [package require beta 1.0]
+  must 1 $BetaLoaded
+  must {0.9 1.1} [package versions beta]
+  must {alpha beta listutil strutil tcltest} [package names]
+
+  package ifneeded gamma 1.0 {package provide gamma 1.1}
+  mustfail {package require gamma}
+  mustfail {package require delta}
+  mustfail {package present delta}
+
+  set Asked {}
+  package unknown [list lappend Asked]
+  mustfail {package require epsilon 3}
+  must {epsilon 3} $Asked
+  must {lappend Asked} [package unknown]
+
+  must -1 [package vcompare 1.2 1.10]
+  must 0 [package vcompare 1.0 1]
+  must 1 [package vsatisfies 1.5 1.2]
+  must 0 [package vsatisfies 2.0 1.2]
+  must 1 [package vsatisfies 2.0 1.2-]
+  must 0 [package vsatisfies 2.0 1.2-2.0]
+  mustfail {package vcompare 1.x 2}
+
+  must "" [info script]
+  must foo.tcl [info script foo.tcl]
+  must foo.tcl [info script]
+`
+
+func TestPackage(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(packageTests))
+}
+
+var unknownTests = `
+  mustfail {nosuch 1 2}
+  proc unknown {args} {
+    if {[lindex $args 0] eq "loop"} {
+      loop again
+    }
+    lappend Missing $args
+    return "unknown [llength $args]"
+  }
+  must "unknown 3" [nosuch 1 2]
+  must {{nosuch 1 2}} $Missing
+  proc callsMissing {} {
+    missing2 x
+  }
+  must "unknown 2" [callsMissing]
+  must "handled by Go" [fromGo x]
+  mustfail {loop}
+`
+
+func TestUnknown(a *testing.T) {
+	fr := NewInterpreter()
+	fr.G.Unknown = func(fr *Frame, argv []T) (T, bool) {
+		if argv[0].String() == "fromGo" {
+			return MkString("handled by Go"), true
+		}
+		return nil, false
+	}
+	fr.Eval(MkString(unknownTests))
+}
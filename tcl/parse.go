@@ -45,38 +45,8 @@ func (fr *Frame) EvalString(a string) (result T) {
 	return MkString(a).EvalSeq(fr)
 }
 
-func consumeBackslashEscaped(s string, i int) (byte, int) {
-	switch s[i+1] {
-	case 'a':
-		return '\a', i + 2
-	case 'b':
-		return '\b', i + 2
-	case 'f':
-		return '\f', i + 2
-	case 'n':
-		return '\n', i + 2
-	case 'r':
-		return '\r', i + 2
-	case 't':
-		return '\t', i + 2
-	case 'v':
-		return '\v', i + 2
-	case 'x':
-		panic("Hexadecimal Backslash Escapes not supported (yet)")
-	}
-	if s[i+1] < '0' || s[i+1] > '7' {
-		return s[i+1], i + 2 // Default for all other cases is the escaped char.
-	}
-	if s[i+2] < '0' || s[i+2] > '7' {
-		panic(Sprintf("Second character after backslash is not octal %q.", s[i:i+3]))
-	}
-	if s[i+3] < '0' || s[i+3] > '7' {
-		panic(Sprintf("Third character after backslash is not octal %q.", s[i:i+4]))
-	}
-	a := s[i+1] - '0'
-	b := s[i+2] - '0'
-	c := s[i+3] - '0'
-	return byte(a*64 + b*8 + c), i + 4
+func consumeBackslashEscaped(s string, i int) (rune, int) {
+	return DecodeBackslash(s, i)
 }
 
 type SubstFlags int
@@ -111,8 +81,7 @@ func (fr *Frame) SubstString(s string, flags SubstFlags) string {
 			str := part.Eval(fr).String()
 			buf.WriteString(str)
 		} else if c == '\\' && (flags&NoBackslash) == 0 {
-			c = lex.StretchBackslashEscaped()
-			buf.WriteByte(c)
+			buf.WriteRune(lex.StretchBackslashEscaped())
 		} else {
 			lex.Stretch1()
 			buf.WriteByte(c)
@@ -165,8 +134,10 @@ func ParseList(s string) []T {
 				case '}':
 					b--
 				case '\\':
-					c, i = consumeBackslashEscaped(s, i)
-					i -= 1
+					var r rune
+					r, i = consumeBackslashEscaped(s, i)
+					buf.WriteRune(r)
+					continue
 				}
 				if b == 0 {
 					break
@@ -185,8 +156,10 @@ func ParseList(s string) []T {
 					break
 				}
 				if c == '\\' {
-					c, i = consumeBackslashEscaped(s, i)
-					i -= 1
+					var r rune
+					r, i = consumeBackslashEscaped(s, i)
+					buf.WriteRune(r)
+					continue
 				}
 				buf.WriteByte(c)
 				i++
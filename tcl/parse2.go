@@ -9,7 +9,7 @@ import (
 	"bytes"
 	. "fmt"
 	"regexp"
-	_ "strings"
+	"strings"
 )
 
 // An expr command
@@ -171,6 +171,7 @@ func (me *PCmd) Eval(fr *Frame) T {
 		Say("PCmd.Eval: ", me.Show())
 	}
 	Parse2CmdEvalCounter.Incr()
+	fr.G.CheckLimits()
 
 	var words []T
 	for _, w := range me.Words {
@@ -186,6 +187,9 @@ func (me *PCmd) Eval(fr *Frame) T {
 
 	// Send Apply to the first word.
 	z := words[0].Apply(fr, words)
+	if fr.G.allocLimited() {
+		fr.G.chargeAlloc(z)
+	}
 	if Debug['w'] {
 		Say("PCmd.Eval: Return: ", z)
 	}
@@ -269,6 +273,19 @@ type PPart struct {
 	Word    *PWord // for DOLLAR2
 	Seq     *PSeq  // for SQUARE
 	Type    PartType
+
+	// For DOLLAR1 and DOLLAR2: if Layout is set, then in frames with
+	// that Layout, the variable is in Slots[Slot].
+	Layout *VarLayout
+	Slot   int
+}
+
+// getVar gets the value of the DOLLAR variable, using the Slot if it can.
+func (me *PPart) getVar(fr *Frame) T {
+	if me.Layout != nil && me.Layout == fr.Layout {
+		return fr.Slots[me.Slot].Get()
+	}
+	return fr.GetVar(me.VarName)
 }
 
 func (me *PPart) Eval(fr *Frame) T {
@@ -278,25 +295,29 @@ func (me *PPart) Eval(fr *Frame) T {
 	case SQUARE:
 		return me.Seq.Eval(fr)
 	case DOLLAR1:
-		v := fr.GetVar(me.VarName)
+		v := me.getVar(fr)
 		if v == nil {
 			panic(Sprintf("(* PWord.Eval.DOLLAR1 *) Variable %q does not exist.", me.VarName))
 		}
 		return v
 	case DOLLAR2:
-		v := fr.GetVar(me.VarName)
-		if v == nil {
-			panic(Sprintf("(* PWord.Eval.DOLLAR2 *) Variable %q does not exist.", me.VarName))
+		k := me.Word.Eval(fr).String()
+		var v T
+		if me.Layout != nil && me.Layout == fr.Layout {
+			v = fr.Slots[me.Slot].Get()
+		} else if loc := fr.findLoc(me.VarName); loc != nil {
+			v = loc.Get()
 		}
-		h := v.Hash()
-		if h == nil {
-			panic(Sprintf("(* PWord.Eval.DOLLAR2 *) Variable %q is not a hash.", me.VarName))
+		if v == nil {
+			panic(Sprintf("can't read \"%s(%s)\": no such variable", me.VarName, k))
 		}
-
-		k := me.Word.Eval(fr).String()
-		z, ok := h[k]
+		h, ok := v.(*terpHash)
 		if !ok {
-			panic(Sprintf("(*PWord.Eval.DOLLAR2*) Variable %q: Key not found", me.VarName))
+			panic(Sprintf("can't read \"%s(%s)\": variable isn't array", me.VarName, k))
+		}
+		z := h.get(k)
+		if z == nil {
+			panic(Sprintf("can't read \"%s(%s)\": no such element in array", me.VarName, k))
 		}
 		return z
 	}
@@ -422,8 +443,7 @@ Loop:
 			r := Parse2Dollar(lex)
 			parts = append(parts, r)
 		case '\\':
-			c = lex.StretchBackslashEscaped()
-			buf.WriteByte(c)
+			buf.WriteRune(lex.StretchBackslashEscaped())
 		default:
 			buf.WriteByte(c)
 			lex.Stretch1()
@@ -479,8 +499,7 @@ Loop:
 
 		case '\\':
 			lex.Next = lex.Pos // StretchBackslashEscaped wants Next to point to the backslash.
-			c := lex.StretchBackslashEscaped()
-			buf.WriteByte(c)
+			buf.WriteRune(lex.StretchBackslashEscaped())
 
 		default:
 			if Debug['p'] {
@@ -551,8 +570,7 @@ Loop:
 			r := Parse2Dollar(lex)
 			parts = append(parts, r)
 		case '\\':
-			c = lex.StretchBackslashEscaped()
-			buf.WriteByte(c)
+			buf.WriteRune(lex.StretchBackslashEscaped())
 		default:
 			buf.WriteByte(c)
 			lex.Stretch1()
@@ -572,10 +590,32 @@ func Parse2Dollar(lex *Lex) *PPart {
 		panic("Expected $ at beginning of Parse2Dollar")
 	}
 
+	if lex.PeekNext() == '{' {
+		// ${name} may have any characters but close-brace, and no substitutions.
+		end := strings.IndexByte(lex.Str[lex.Next:], '}')
+		if end < 0 {
+			panic("missing close-brace for variable name")
+		}
+		name := lex.Str[lex.Next+1 : lex.Next+end]
+		lex.Pos = lex.Next
+		lex.Next += end + 1
+		lex.Tok = Token('}')
+		return &PPart{
+			Type:    DOLLAR1,
+			VarName: name,
+		}
+	}
+
 	lex.AdvanceIfAlfaNum()
 	switch lex.Tok {
 	case TokAlfaNum, TokStrEq, TokStrNe, TokStrLt, TokStrLe, TokStrGt, TokStrGe:
 
+	case Token('$'):
+		// Like Tcl, a $ not followed by a name is just a $.
+		return &PPart{
+			Type:  BARE,
+			Multi: MkMulti("$"),
+		}
 	default:
 		panic("Expected a varname after $")
 	}
@@ -1072,7 +1112,8 @@ func (me *PPart) ExpandMacros(fr *Frame, maxSubCompile int) *PPart {
 			return &PPart{Type: BARE, Multi: MkMultiFr(fr, me.Multi)}
 		}
 	case DOLLAR1:
-		return me
+		// Copy it, because ResolveSlots may write into it.
+		return &PPart{Type: DOLLAR1, VarName: me.VarName}
 	case DOLLAR2:
 		return &PPart{Type: DOLLAR2, VarName: me.VarName, Word: me.Word.ExpandMacros(fr, maxSubCompile)}
 	case SQUARE:
@@ -1081,6 +1122,98 @@ func (me *PPart) ExpandMacros(fr *Frame, maxSubCompile int) *PPart {
 	panic("unknown PartType")
 }
 
+// ResolveSlots gives Slots in the layout to the local variables
+// that the sequence uses literally, including in nested bodies
+// and expressions that were compiled along with it.
+func (me *PSeq) ResolveSlots(layout *VarLayout) {
+	for _, c := range me.Cmds {
+		c.ResolveSlots(layout)
+	}
+}
+
+func (me *PCmd) ResolveSlots(layout *VarLayout) {
+	if len(me.Words) > 1 && me.Words[0].Multi != nil && me.Words[1].Multi != nil {
+		// Literal variable names given to common commands get slots, too.
+		switch me.Words[0].Multi.String() {
+		case "set", "incr", "append", "lappend":
+			if name := me.Words[1].Multi.String(); IsSlottable(name) {
+				layout.Add(name)
+			}
+		case "foreach":
+			if names, err := ParseListOrRecover(me.Words[1].Multi.String()); err == nil {
+				for _, e := range names {
+					if name := e.String(); IsSlottable(name) {
+						layout.Add(name)
+					}
+				}
+			}
+		}
+	}
+	for _, w := range me.Words {
+		w.ResolveSlots(layout)
+	}
+}
+
+func (me *PWord) ResolveSlots(layout *VarLayout) {
+	for _, part := range me.Parts {
+		part.ResolveSlots(layout)
+	}
+}
+
+func (me *PPart) ResolveSlots(layout *VarLayout) {
+	switch me.Type {
+	case BARE:
+		me.Multi.ResolveSlots(layout)
+	case DOLLAR1:
+		me.resolveVar(layout)
+	case DOLLAR2:
+		me.resolveVar(layout)
+		me.Word.ResolveSlots(layout)
+	case SQUARE:
+		me.Seq.ResolveSlots(layout)
+	}
+}
+
+func (me *PPart) resolveVar(layout *VarLayout) {
+	if IsSlottable(me.VarName) {
+		me.Layout = layout
+		me.Slot = layout.Add(me.VarName)
+	}
+}
+
+func (me *PExpr) ResolveSlots(layout *VarLayout) {
+	for _, e := range []*PExpr{me.A, me.B, me.C} {
+		if e != nil {
+			e.ResolveSlots(layout)
+		}
+	}
+	if me.Word != nil {
+		me.Word.ResolveSlots(layout)
+	}
+}
+
+func (t *terpMulti) ResolveSlots(layout *VarLayout) {
+	if t.seq != nil {
+		t.seq.ResolveSlots(layout)
+	}
+	// Only words with variables are worth compiling as expressions now.
+	if t.expr == nil && strings.IndexByte(t.s.s, '$') >= 0 {
+		t.expr = MaybeCompileExpr(t.s.s)
+	}
+	if t.expr != nil {
+		t.expr.ResolveSlots(layout)
+	}
+}
+
+func MaybeCompileExpr(s string) (expr *PExpr) {
+	defer func() {
+		if recover() != nil {
+			expr = nil
+		}
+	}()
+	return Parse2ExprStr(s)
+}
+
 func CHECK(b bool, rest ...interface{}) {
 	if !b {
 		s := "CHECK FAILS"
@@ -0,0 +1,463 @@
+package tcl
+
+import (
+	"errors"
+	. "fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Goroutines.  "go" runs its script in a new interpreter of its own,
+// so no Scope, CmdScope or Hash is touched by two goroutines.
+// The new interpreter starts with copies of the procs and global
+// variables of the one that ran "go", and its commands registered from
+// Go and its G.Unknown, which must therefore be safe to call from other
+// goroutines.  It shares only the SharedVars, FileSystem, Logger and
+// limits; aliases and child interpreters are not copied, since their
+// commands would use the other interpreter.  Scripts communicate through the
+// "shared" command, whose variables are strings protected by a lock,
+// and through channels (see gochan.go).
+//
+// "go" returns the name of a Task, which "wait" waits for, returning
+// the result of the script or raising its error.  A task is forgotten
+// once it has been waited for, or its -command callback has run.
+//
+// Tasks are charged to the command and allocation limits of the
+// interpreters that started them, through budgets (see taskBudget),
+// which also cap how many tasks may run at once.
+
+// SharedVars holds variables shared by an interpreter and the
+// interpreters its "go" commands create.
+type SharedVars struct {
+	mu   sync.Mutex
+	vars map[string]string
+}
+
+// NewSharedVars makes an empty SharedVars.
+func NewSharedVars() *SharedVars {
+	return &SharedVars{vars: make(map[string]string)}
+}
+
+// Get returns the value of the shared variable, and whether it exists.
+func (sv *SharedVars) Get(name string) (string, bool) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	s, ok := sv.vars[name]
+	return s, ok
+}
+
+// Set sets the shared variable.
+func (sv *SharedVars) Set(name, value string) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	sv.vars[name] = value
+}
+
+// Update replaces the shared variable with the result of fn,
+// which gets the old value (or "" if missing), under the lock.
+func (sv *SharedVars) Update(name string, fn func(old string) string) string {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	z := fn(sv.vars[name])
+	sv.vars[name] = z
+	return z
+}
+
+// Unset removes the shared variable, returning false if it did not exist.
+func (sv *SharedVars) Unset(name string) bool {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	_, ok := sv.vars[name]
+	delete(sv.vars, name)
+	return ok
+}
+
+// Names returns the sorted names of the shared variables.
+func (sv *SharedVars) Names() []string {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	var z []string
+	for k := range sv.vars {
+		z = append(z, k)
+	}
+	sort.Strings(z)
+	return z
+}
+
+// SharedVars returns the interpreter's SharedVars.
+func (fr *Frame) SharedVars() *SharedVars {
+	return fr.G.Shared
+}
+
+// copyValue makes a copy of a variable value that shares nothing
+// with the original, so another goroutine may use it.
+func copyValue(x T) T {
+	if x.IsQuickHash() {
+		h := make(Hash)
+		for k, v := range x.Hash() {
+			h[k] = MkString(v.String())
+		}
+		return MkHash(h)
+	}
+	return MkString(x.String())
+}
+
+// DefaultTaskLimit is how many tasks may run at once, started by an
+// interpreter and by its tasks, if its TaskLimit is not set.
+const DefaultTaskLimit = 1000
+
+// taskBudget holds what is left of an interpreter's command and
+// allocation limits, shared with the tasks it starts (and theirs),
+// which charge it from other goroutines, and counts its live tasks.
+type taskBudget struct {
+	commands int64 // Commands left, used atomically.  First, for alignment.
+	alloc    int64 // Allocation left, used atomically.
+	tasks    int64 // Live tasks, used atomically.
+	maxTasks int64
+	g        *Global // Interpreter whose limits these are.
+}
+
+// taskBudgets returns the budgets a task started by the interpreter is
+// charged to: the interpreter's own, made if needed, and those it and its
+// parents are charged to.
+func (g *Global) taskBudgets() []*taskBudget {
+	var z []*taskBudget
+	for h := g; h != nil; h = h.Parent {
+		if h.budget == nil {
+			h.budget = &taskBudget{g: h, maxTasks: h.TaskLimit}
+			if h.budget.maxTasks == 0 {
+				h.budget.maxTasks = DefaultTaskLimit
+			}
+			h.resetBudget()
+			h.budgets = append(h.budgets, h.budget)
+		}
+		z = append(z, h.budgets...)
+	}
+	return z
+}
+
+// resetBudget makes what is left for the interpreter and its tasks
+// match its limits again, after they change.
+func (g *Global) resetBudget() {
+	b := g.budget
+	if b == nil {
+		return
+	}
+	left := func(limit, count int64) int64 {
+		if limit == 0 {
+			return math.MaxInt64
+		}
+		return limit - count
+	}
+	atomic.StoreInt64(&b.commands, left(g.CommandLimit, g.CommandCount))
+	atomic.StoreInt64(&b.alloc, left(g.AllocLimit, g.AllocCount))
+}
+
+// charge takes n from what is left, panicking if it runs out.
+func (b *taskBudget) charge(left *int64, n int64, kind string) {
+	if atomic.AddInt64(left, -n) < 0 {
+		panic(LimitExceeded{G: b.g, Kind: kind})
+	}
+}
+
+// startTasks counts a new live task in the budgets,
+// or panics if one has too many.
+func startTasks(budgets []*taskBudget) {
+	for i, b := range budgets {
+		if atomic.AddInt64(&b.tasks, 1) > b.maxTasks {
+			endTasks(budgets[:i+1])
+			panic(Sprintf("too many tasks: %d are running", b.maxTasks))
+		}
+	}
+}
+
+// endTasks counts a task that has finished.
+func endTasks(budgets []*taskBudget) {
+	for _, b := range budgets {
+		atomic.AddInt64(&b.tasks, -1)
+	}
+}
+
+// NewGoInterpreter makes the interpreter for a "go" command.
+// It gets copies of the procs, Go commands and global variables,
+// and may use the same GoChans, but gets no I/O channels, children
+// or aliases.
+func (fr *Frame) NewGoInterpreter() *Frame {
+	g := fr.G
+	child := newEitherInterpreter(g.IsSafe)
+	cg := child.G
+
+	cg.FS = g.FS
+	cg.Shared = g.Shared
+	cg.Logger = g.Logger
+	cg.Verbosity = g.Verbosity
+	cg.LogName = g.LogName
+	cg.Context = g.Context
+	cg.TimeLimit = g.TimeLimit
+	cg.DepthLimit = g.DepthLimit
+	cg.budgets = g.taskBudgets()
+	if fr.Cred != nil {
+		child.Cred = MkCred(credNames(fr.Cred)...)
+	}
+
+	cg.Unknown = g.Unknown
+	for _, gc := range g.GoChans {
+		cg.AddGoChan(gc)
+	}
+	for name := range cg.Cmds {
+		if g.Cmds[name] == nil {
+			delete(cg.Cmds, name) // Removed by the host.
+		}
+	}
+	for name, node := range g.Cmds {
+		_, isProc := g.ProcDefs[name]
+		_, isAlias := g.Aliases[name]
+		_, isChild := g.Children[name]
+		if !isProc && !isAlias && !isChild {
+			cg.Cmds[name] = &CmdNode{Fn: node.Fn}
+		}
+	}
+	for name, def := range g.ProcDefs {
+		purifiedProc(child, []T{MkString("proc"), MkString(name), MkString(def[0]), MkString(def[1])})
+	}
+	for name, loc := range g.Fr.Vars {
+		if loc.Has() && !IsMirroredHash(loc.Get()) {
+			child.SetVar(name, copyValue(loc.Get()))
+		}
+	}
+	return child
+}
+
+// Task is a script running in a goroutine, started by "go".
+type Task struct {
+	Name   string
+	done   chan struct{} // Closed when the script finishes.
+	result string
+	err    error
+}
+
+// tasks holds the Tasks by name.  It is shared by all interpreters,
+// so a task name may be passed to another goroutine.
+var tasks = struct {
+	sync.Mutex
+	m map[string]*Task
+	n int
+}{m: make(map[string]*Task)}
+
+// StartTask runs the lists, as by "eval", in a goroutine with
+// a new interpreter made by NewGoInterpreter.
+func (fr *Frame) StartTask(lists []T) *Task {
+	child := fr.NewGoInterpreter()
+	budgets := child.G.budgets
+	startTasks(budgets)
+	fresh := make([]T, len(lists))
+	for i, a := range lists {
+		fresh[i] = MkString(a.String())
+	}
+
+	tasks.Lock()
+	tasks.n++
+	task := &Task{Name: Sprintf("task%d", tasks.n), done: make(chan struct{})}
+	tasks.m[task.Name] = task
+	tasks.Unlock()
+
+	go func() {
+		defer close(task.done)
+		defer endTasks(budgets)
+		defer func() {
+			if r := recover(); r != nil {
+				if j, ok := r.(Jump); ok {
+					if j.Status == RETURN {
+						task.result = j.Result.String()
+						return
+					}
+					r = "invoked break or continue outside of a loop"
+				}
+				task.err = errors.New(Sprintf("%v", r))
+			}
+		}()
+		task.result = EvalOrApplyLists(child, fresh).String()
+	}()
+	return task
+}
+
+// forgetTask removes the named Task, once it is no longer needed.
+func forgetTask(name string) {
+	tasks.Lock()
+	defer tasks.Unlock()
+	delete(tasks.m, name)
+}
+
+// LookupTask returns the named Task, or nil.
+func LookupTask(name string) *Task {
+	tasks.Lock()
+	defer tasks.Unlock()
+	return tasks.m[name]
+}
+
+// Done returns a channel that is closed when the task finishes.
+func (task *Task) Done() <-chan struct{} {
+	return task.done
+}
+
+// Wait waits for the task to finish, and returns its result or error.
+func (task *Task) Wait() (string, error) {
+	<-task.done
+	return task.result, task.err
+}
+
+// cmdGo starts a task.  With -command, the callback is evaluated
+// by the event loop, with the task name appended and the caller's Cred,
+// when the task finishes.
+func cmdGo(fr *Frame, argv []T) T {
+	var callback T
+	if len(argv) > 2 && argv[1].String() == "-command" {
+		callback = argv[2]
+		argv = argv[2:]
+	}
+	if len(argv) < 2 {
+		panic("usage: go ?-command callback? command ?arg ...?")
+	}
+	task := fr.StartTask(argv[1:])
+	if callback != nil {
+		el, cred := fr.G.Events, fr.Cred
+		el.Hold()
+		go func() {
+			<-task.Done()
+			el.PostWithCred(cred, func(fr *Frame) {
+				defer forgetTask(task.Name)
+				EvalOrApplyLists(fr, []T{callback, MkList([]T{MkString(task.Name)})})
+			})
+			el.Release()
+		}()
+	}
+	return MkString(task.Name)
+}
+
+// cmdWait waits for all the tasks, returning the result of one task,
+// or a list of results of several, and forgets them.
+// If a task failed, its error is raised.
+func cmdWait(fr *Frame, argv []T) T {
+	if len(argv) < 2 {
+		panic("usage: wait task ?task ...?")
+	}
+	var zz []T
+	for _, a := range argv[1:] {
+		task := LookupTask(a.String())
+		if task == nil {
+			panic(Sprintf("no such task: %q", a.String()))
+		}
+		fr.awaitDone(task.Done())
+		result, err := task.Wait()
+		forgetTask(task.Name)
+		if err != nil {
+			panic(err.Error())
+		}
+		zz = append(zz, MkString(result))
+	}
+	if len(zz) == 1 {
+		return zz[0]
+	}
+	return MkList(zz)
+}
+
+var sharedEnsemble = []EnsembleItem{
+	EnsembleItem{Name: "set", Cmd: cmdSharedSet, Doc: "name ?value?"},
+	EnsembleItem{Name: "get", Cmd: cmdSharedGet, Doc: "name"},
+	EnsembleItem{Name: "exists", Cmd: cmdSharedExists, Doc: "name"},
+	EnsembleItem{Name: "unset", Cmd: cmdSharedUnset, Doc: "name"},
+	EnsembleItem{Name: "incr", Cmd: cmdSharedIncr, Doc: "name ?delta?"},
+	EnsembleItem{Name: "append", Cmd: cmdSharedAppend, Doc: "name ?value ...?"},
+	EnsembleItem{Name: "lappend", Cmd: cmdSharedLAppend, Doc: "name ?value ...?"},
+	EnsembleItem{Name: "names", Cmd: cmdSharedNames, Doc: ""},
+}
+
+func cmdSharedSet(fr *Frame, argv []T) T {
+	if len(argv) == 2 {
+		return cmdSharedGet(fr, argv)
+	}
+	name, value := Arg2(argv)
+	s := value.String()
+	fr.SharedVars().Set(name.String(), s)
+	return MkString(s)
+}
+
+func cmdSharedGet(fr *Frame, argv []T) T {
+	name := Arg1(argv)
+	s, ok := fr.SharedVars().Get(name.String())
+	if !ok {
+		panic(Sprintf("can't read shared %q: no such variable", name.String()))
+	}
+	return MkString(s)
+}
+
+func cmdSharedExists(fr *Frame, argv []T) T {
+	name := Arg1(argv)
+	_, ok := fr.SharedVars().Get(name.String())
+	return MkBool(ok)
+}
+
+func cmdSharedUnset(fr *Frame, argv []T) T {
+	name := Arg1(argv)
+	fr.SharedVars().Unset(name.String())
+	return Empty
+}
+
+func cmdSharedIncr(fr *Frame, argv []T) T {
+	var name, delta T
+	if len(argv) == 2 {
+		name = Arg1(argv)
+		delta = One
+	} else {
+		name, delta = Arg2(argv)
+	}
+	d := delta.Float()
+	return MkString(fr.SharedVars().Update(name.String(), func(old string) string {
+		if old == "" {
+			old = "0"
+		}
+		return MkFloat(MkString(old).Float() + d).String()
+	}))
+}
+
+func cmdSharedAppend(fr *Frame, argv []T) T {
+	name, values := Arg1v(argv)
+	return MkString(fr.SharedVars().Update(name.String(), func(old string) string {
+		for _, v := range values {
+			old += v.String()
+		}
+		return old
+	}))
+}
+
+func cmdSharedLAppend(fr *Frame, argv []T) T {
+	name, values := Arg1v(argv)
+	strs := make([]T, len(values))
+	for i, v := range values {
+		strs[i] = MkString(v.String())
+	}
+	return MkString(fr.SharedVars().Update(name.String(), func(old string) string {
+		return MkList(append(MkString(old).List(), strs...)).String()
+	}))
+}
+
+func cmdSharedNames(fr *Frame, argv []T) T {
+	Arg0(argv)
+	var zz []T
+	for _, name := range fr.SharedVars().Names() {
+		zz = append(zz, MkString(name))
+	}
+	return MkList(zz)
+}
+
+func init() {
+	if Safes == nil {
+		Safes = make(map[string]Command, 333)
+	}
+
+	Safes["go"] = cmdGo
+	Safes["wait"] = cmdWait
+	Safes["shared"] = MkEnsemble(sharedEnsemble)
+}
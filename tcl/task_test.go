@@ -0,0 +1,281 @@
+package tcl
+
+import (
+	. "fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+var sharedTests = `
+  must 0 [shared exists a]
+  must 5 [shared set a 5]
+  must 5 [shared get a]
+  must 5 [shared set a]
+  must 1 [shared exists a]
+  must 7 [shared incr a 2]
+  must 1 [shared incr b]
+  must xyz [shared append c x y z]
+  must {p {q r}} [shared lappend d p {q r}]
+  must {a b c d} [shared names]
+  shared unset a
+  must 0 [shared exists a]
+  mustfail {shared get a}
+  mustfail {go}
+`
+
+func TestShared(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(sharedTests))
+}
+
+// goStress starts many goroutines that use procs, globals, arrays and
+// shared variables, while the starting interpreter keeps changing its own.
+var goStress = `
+  set Base 100
+  set Arr(x) 1
+  proc work {n} {
+    set sum 0
+    foreach i [list 1 2 3 4 5 6 7 8 9 10] {
+      set sum [expr $sum + $i * $n]
+      set Arr($i) $sum
+      set Base [expr $Base + 1]
+    }
+    shared lappend results $n
+    shared incr total $sum
+    shared incr done
+  }
+  set n 0
+  while {$n < 50} {
+    go work $n
+    set Arr(x) $n
+    set Base [expr $Base + $n]
+    incr n
+  }
+`
+
+func TestGoStress(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(goStress))
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		s, _ := fr.SharedVars().Get("done")
+		if s == "50" {
+			break
+		}
+		if time.Now().After(deadline) {
+			a.Fatalf("go scripts did not finish: done=%q", s)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// sum over n in 0..49 of 55*n
+	fr.Eval(MkString(`
+    must 67375 [shared get total]
+    must 50 [llength [shared get results]]
+  `))
+}
+
+var taskTests = `
+  proc square {x} { expr $x * $x }
+  set t [go square 7]
+  must 49 [wait $t]
+  mustfail {wait $t}
+  must {1 4 9} [wait [go square 1] [go square 2] [go square 3]]
+  mustfail {wait [go error oops]}
+  catch {wait [go error oops]} what
+  must 1 [string match "oops*" $what]
+  must ret [wait [go return ret]]
+  mustfail {wait nosuch}
+  mustfail {go}
+
+  set c [chan create]
+  set t [go chan send $c hello]
+  must hello [chan recv $c]
+  must "" [wait $t]
+
+  set c [chan create 3]
+  chan send $c a
+  chan send $c b
+  chan close $c
+  mustfail {chan send $c z}
+  mustfail {chan close $c}
+  must a [chan recv $c]
+  must 1 [chan recv $c v]
+  must b $v
+  must 0 [chan recv $c v]
+  must "" $v
+  mustfail {chan recv $c}
+  mustfail {chan recv nosuch}
+  mustfail {chan create -1}
+
+  set results [chan create]
+  proc fanout {ch n} {
+    set i 0
+    while {$i < $n} {
+      go chan send $ch [square $i]
+      incr i
+    }
+  }
+  fanout $results 10
+  set sum 0
+  set i 0
+  while {$i < 10} {
+    set sum [expr $sum + [chan recv $results]]
+    incr i
+  }
+  must 285 $sum
+
+  set a [chan create 1]
+  set b [chan create 1]
+  chan send $b bee
+  must got-bee [select [list recv $a x {concat "got-a"} recv $b x {concat "got-$x"}]]
+  must none [select [list recv $a x {concat a} default {concat none}]]
+  must late [select [list recv $a x {concat a} timeout 10 {concat late}]]
+  must sent [select [list send $a aye {concat sent}]]
+  must aye [chan recv $a]
+  chan close $a
+  must {{} 0} [select [list recv $a {x ok} {list $x $ok}]]
+  mustfail {select {bogus}}
+  mustfail {select {recv}}
+  mustfail {select {}}
+`
+
+func TestTask(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(taskTests))
+}
+
+func TestTaskLimit(a *testing.T) {
+	fr := NewInterpreter()
+	fr.G.TimeLimit = time.Now().Add(20 * time.Millisecond)
+	defer func() {
+		if r := recover(); !IsLimitExceeded(r) {
+			a.Errorf("expected time limit, got %v", r)
+		}
+	}()
+	fr.Eval(MkString(`chan recv [chan create]`))
+}
+
+func TestTaskLimitParent(a *testing.T) {
+	fr := NewInterpreter()
+	fr.G.TimeLimit = time.Now().Add(20 * time.Millisecond)
+	defer func() {
+		if r := recover(); !IsLimitExceeded(r) {
+			a.Errorf("expected time limit, got %v", r)
+		}
+	}()
+	fr.Eval(MkString(`interp create kid; interp eval kid {chan recv [chan create]}`))
+}
+
+var goHostScript = `
+  interp alias {} al {} hostcmd
+  go {
+    hostcmd a
+    nosuch
+    shared set alias [catch {al b} m]
+    shared set done 1
+  }
+`
+
+var goChanScopeTests = `
+  interp create kid
+  interp create -safe sk
+  set c [kid eval {chan create 1}]
+  mustfail {withcred {} {chan send $c x}}
+  chan send $c x
+  must x [kid eval [list chan recv $c]]
+  mustfail {sk eval [list chan send $c y]}
+
+  set d [chan create 1]
+  must "" [wait [go chan send $d y]]
+  chan close $d
+  mustfail {kid eval [list chan recv $d]}
+  must y [chan recv $d]
+  must 0 [chan recv $d v]
+  mustfail {chan recv $d v}
+`
+
+func TestGoChanScope(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(goChanScopeTests))
+}
+
+func TestGoHost(a *testing.T) {
+	fr := NewInterpreter()
+	fr.G.Cmds["hostcmd"] = &CmdNode{Fn: func(fr *Frame, argv []T) T {
+		fr.SharedVars().Set("host", Arg1(argv).String())
+		return Empty
+	}}
+	fr.G.Unknown = func(fr *Frame, argv []T) (T, bool) {
+		if argv[0].String() != "nosuch" {
+			return nil, false
+		}
+		fr.SharedVars().Set("unknown", "nosuch")
+		return Empty, true
+	}
+	fr.Eval(MkString(goHostScript))
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, ok := fr.SharedVars().Get("done"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			a.Fatalf("go script did not finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for k, want := range map[string]string{"host": "a", "unknown": "nosuch", "alias": "1"} {
+		if got, _ := fr.SharedVars().Get(k); got != want {
+			a.Errorf("shared %s: got %q, want %q", k, got, want)
+		}
+	}
+}
+
+// untilFailure evaluates the script until it panics, or fails the test.
+func untilFailure(a *testing.T, fr *Frame, script string) interface{} {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if r := evalLimited(fr, script); r != nil {
+			return r
+		}
+		time.Sleep(time.Millisecond)
+	}
+	a.Fatalf("no failure")
+	return nil
+}
+
+func TestGoBudget(a *testing.T) {
+	fr := NewInterpreter()
+	fr.G.CommandLimit = 10000
+	fr.Eval(MkString(`go {while 1 {}}`))
+	expectLimit(a, "commands", untilFailure(a, fr, `list`))
+
+	fr = NewInterpreter()
+	fr.G.AllocLimit = 100000
+	fr.Eval(MkString(`go {set s x; while 1 {append s $s}}`))
+	expectLimit(a, "alloc", untilFailure(a, fr, `list abc`))
+}
+
+func TestGoTaskLimit(a *testing.T) {
+	fr := NewInterpreter()
+	fr.G.TaskLimit = 2
+	// The tasks of tasks count too.
+	fr.Eval(MkString(`
+		go {
+			go {while {![shared exists stop]} {}}
+			while {![shared exists stop]} {}
+		}
+	`))
+	r := untilFailure(a, fr, `go list`)
+	if !strings.HasPrefix(Sprint(r), "too many tasks: 2 are running") {
+		a.Errorf("expected too many tasks, got %v", r)
+	}
+	fr.Eval(MkString(`shared set stop 1`))
+	deadline := time.Now().Add(10 * time.Second)
+	for evalLimited(fr, `go list`) != nil {
+		if time.Now().After(deadline) {
+			a.Fatalf("tasks did not finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -2,6 +2,7 @@ package tcl
 
 import (
 	"bytes"
+	"context"
 	. "fmt"
 	"go/ast"
 	"log"
@@ -9,7 +10,11 @@ import (
 	"path"
 	R "reflect"
 	"runtime"
+	"sort"
 	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
 var Debug [256]bool
@@ -56,11 +61,14 @@ type MacroNode struct {
 // and a new one is created for each proc or yproc invocation
 // (but not for every Command; non-proc commands do not make Frames).
 type Frame struct {
-	Vars Scope // local variables
-	Cred Hash  // credentials
+	Vars   Scope      // local variables not in Slots (made lazily, except in the global frame)
+	Slots  []Slot     // local variables resolved when the proc was compiled
+	Layout *VarLayout // names of the Slots; nil if the frame has no Slots
+	Cred   Hash       // credentials
 
-	Prev *Frame
-	G    *Global
+	Prev  *Frame
+	G     *Global
+	Depth int // Number of Prev links to the global frame.
 
 	DebugName string
 }
@@ -76,6 +84,46 @@ type Global struct {
 	Logger    *log.Logger
 	Verbosity int    // Log if message level <= verbosity.
 	LogName   string // for logging
+
+	Lambdas map[string]Command // Compiled bodies for apply, by lambda string.
+
+	Script         string                  // File being sourced, for info script.
+	Packages       map[string]*packageInfo // By package name.
+	PackageUnknown T                       // Handler for package require, or nil for default.
+	resolving      map[string]bool         // Missing commands being handled, to stop recursion.
+
+	Parent        *Global             // Interpreter that created this one, or nil.
+	Children      map[string]*Frame   // Child interpreters, by name.
+	Aliases       map[string][]T      // Alias target path and command prefix, by alias name.
+	Channels      map[string]T        // Channels, by name.
+	GoChans       map[string]*GoChan  // Channels of "chan create" it may use, by name.
+	FS            FileSystem          // Files; see FileSystem().
+	ProcDefs      map[string][]string // Args and body of each proc, by name.
+	Shared        *SharedVars         // Variables shared with "go" interpreters.
+	Events        *EventLoop          // Timers and posted events; see event.go.
+	Deleted       bool                // Set by "interp delete".
+	interpCounter int                 // For naming children.
+
+	// Limits; see limits.go.
+	CommandCount int64           // Commands evaluated so far.
+	CommandLimit int64           // If nonzero, limit for CommandCount.
+	TimeLimit    time.Time       // If nonzero, evaluation must end by then.
+	Context      context.Context // If not nil, evaluation must end when it is done.
+	DepthLimit   int             // If nonzero, limit for Frame Depth.
+	AllocCount   int64           // Total size of command results, if AllocLimit is set.
+	AllocLimit   int64           // If nonzero, limit for AllocCount.
+	TaskLimit    int64           // If nonzero, limit for live tasks, else DefaultTaskLimit.
+	budget       *taskBudget     // Shared with the tasks it starts; see task.go.
+	budgets      []*taskBudget   // Budgets its commands are charged to, its own included.
+
+	// Creds of the frames that registered hooks, which then run with no
+	// more capabilities than those frames had.
+	UnknownCred        Hash // Cred that defined the "unknown" proc.
+	PackageUnknownCred Hash // Cred that set PackageUnknown.
+
+	// Unknown, if set, is called with the argv of a missing command,
+	// before any "unknown" command.  It returns false if it declines.
+	Unknown func(fr *Frame, argv []T) (T, bool)
 }
 
 // StatusCode are the same integers as Tcl/C uses for return, break, and continue.
@@ -108,8 +156,20 @@ type Loc interface {
 }
 
 // Slot stores a variable value.
+// If Up is set (by upvar or global), the Slot forwards to that Loc instead.
 type Slot struct {
 	Elem T
+	Up   Loc
+}
+
+// VarLayout maps the local variable names that a proc body uses
+// literally to indices into Frame.Slots.  It is built once when the
+// proc is compiled, and shared by every Frame that runs the proc.
+// Names not in the VarLayout (from upvar, set with a computed name, etc.)
+// still live in Frame.Vars.
+type VarLayout struct {
+	Names []string
+	Index map[string]int
 }
 
 // UpSlot forwards a variable to another variable.
@@ -135,9 +195,12 @@ func newEitherInterpreter(isSafe bool) *Frame {
 			Vars: make(Scope),
 		},
 		IsSafe: isSafe,
+		Shared: NewSharedVars(),
+		Events: NewEventLoop(),
 	}
 
 	g.Fr.G = g
+	g.initEnv()
 
 	// Copy Safes to commands.
 	for k, v := range Safes {
@@ -153,6 +216,11 @@ func newEitherInterpreter(isSafe bool) *Frame {
 		}
 	}
 
+	// Make Libraries available to package require.
+	for _, lib := range Libraries {
+		g.pkg(lib.Name, true).ifneeded[lib.Version] = MkString(lib.Script)
+	}
+
 	return &g.Fr
 }
 
@@ -169,12 +237,42 @@ func NewSafeInterpreter() *Frame {
 // NewFrame makes a frame for calling another proc.
 func (fr *Frame) NewFrame() *Frame {
 	NewFrameCounter.Incr()
+	fr.G.checkDepth(fr.Depth + 1)
 	return &Frame{
-		Vars: make(Scope), // new local var scope
-		Cred: fr.Cred,     // same credentials as caller
-		Prev: fr,          // link back to prev frame
-		G:    fr.G,        // the Global struct
+		Cred:  fr.Cred,      // same credentials as caller
+		Prev:  fr,           // link back to prev frame
+		G:     fr.G,         // the Global struct
+		Depth: fr.Depth + 1, // one more than caller
+	}
+}
+
+// NewProcFrame makes a frame for calling a proc compiled with the layout.
+func (fr *Frame) NewProcFrame(layout *VarLayout) *Frame {
+	z := fr.NewFrame()
+	z.Layout = layout
+	z.Slots = make([]Slot, len(layout.Names))
+	return z
+}
+
+func NewVarLayout() *VarLayout {
+	return &VarLayout{Index: make(map[string]int)}
+}
+
+// Add returns the slot index for the name, adding it if it is new.
+func (lay *VarLayout) Add(name string) int {
+	if i, ok := lay.Index[name]; ok {
+		return i
 	}
+	i := len(lay.Names)
+	lay.Names = append(lay.Names, name)
+	lay.Index[name] = i
+	return i
+}
+
+// IsSlottable tells if a literal variable name may be given a Slot.
+// Globals, array elements, and destructuring names are looked up by name.
+func IsSlottable(name string) bool {
+	return len(name) > 0 && IsLocal(name) && !strings.ContainsAny(name, ",()")
 }
 
 // Initial capital letter for a variable means Global.
@@ -182,55 +280,151 @@ func IsGlobal(name string) bool {
 	if len(name) == 0 {
 		panic("Empty variable name")
 	}
+	if c := name[0]; c < utf8.RuneSelf {
+		return 'A' <= c && c <= 'Z' // Fast path for ASCII.
+	}
 	return ast.IsExported(name) // Same criteria, First is Uppercase.
 }
 
-// Initial capital letter for a variable means local.
+// Initial lowercase (or non-letter) for a variable means local.
 func IsLocal(name string) bool {
-	if len(name) == 0 {
-		panic("Empty variable name")
+	return !IsGlobal(name)
+}
+
+func (p *Slot) Has() bool {
+	if p.Up != nil {
+		return p.Up.Has()
+	}
+	return p.Elem != nil
+}
+func (p *Slot) Get() T {
+	if p.Up != nil {
+		return p.Up.Get()
+	}
+	return p.Elem
+}
+func (p *Slot) Set(t T) {
+	if p.Up != nil {
+		p.Up.Set(t)
+		return
 	}
-	return !ast.IsExported(name)
+	p.Elem = t
 }
 
-func (p *Slot) Has() bool { return p.Elem != nil }
-func (p *Slot) Get() T    { return p.Elem }
-func (p *Slot) Set(t T)   { p.Elem = t }
+// findLoc returns the Loc of the named variable, or nil if there is none.
+func (fr *Frame) findLoc(name string) Loc {
+	if IsGlobal(name) {
+		fr = &fr.G.Fr
+	}
+	if fr.Layout != nil {
+		if i, ok := fr.Layout.Index[name]; ok {
+			return &fr.Slots[i]
+		}
+	}
+	if loc, ok := fr.Vars[name]; ok {
+		return loc
+	}
+	return nil
+}
 
-func (fr *Frame) GetVarScope(name string) Scope {
-	if len(name) == 0 {
-		panic("Empty variable name")
+// LocalLocs returns the local variables of the frame, both in Slots and Vars.
+func (fr *Frame) LocalLocs() Scope {
+	z := make(Scope, len(fr.Slots)+len(fr.Vars))
+	for i := range fr.Slots {
+		p := &fr.Slots[i]
+		if p.Elem != nil || p.Up != nil {
+			z[fr.Layout.Names[i]] = p
+		}
+	}
+	for k, v := range fr.Vars {
+		z[k] = v
 	}
+	return z
+}
 
-	if IsGlobal(name) {
-		return fr.G.Fr.Vars
+// SplitArrayName splits an array element name "arr(key)" into "arr" and "key".
+// The key may contain commas, but the array name may not, since "a,b(c)"
+// is a destructuring assignment.
+func SplitArrayName(name string) (arr string, key string, ok bool) {
+	n := len(name)
+	if n < 3 || name[n-1] != ')' {
+		return "", "", false
 	}
-	return fr.Vars
+	i := strings.IndexByte(name, '(')
+	if i < 1 || strings.IndexByte(name[:i], ',') >= 0 {
+		return "", "", false
+	}
+	return name[:i], name[i+1 : n-1], true
 }
 
-func (fr *Frame) HasVar(name string) bool {
-	sc := fr.GetVarScope(name)
-	var loc Loc
-	var ok bool
-	loc, ok = sc[name]
+// arrayFor returns the array variable, or nil if it does not exist.
+// The verb is for the message if the variable is not an array.
+func (fr *Frame) arrayFor(arr string, name string, verb string) *terpHash {
+	loc := fr.findLoc(arr)
+	if loc == nil || !loc.Has() {
+		return nil
+	}
+	h, ok := loc.Get().(*terpHash)
 	if !ok {
+		panic(Sprintf("can't %s %q: variable isn't array", verb, name))
+	}
+	return h
+}
+
+func (fr *Frame) HasVar(name string) bool {
+	if arr, key, ok := SplitArrayName(name); ok {
+		loc := fr.findLoc(arr)
+		if loc == nil || !loc.Has() {
+			return false
+		}
+		h, ok := loc.Get().(*terpHash)
+		return ok && h.get(key) != nil
+	}
+	loc := fr.findLoc(name)
+	if loc == nil {
 		return false
 	}
 	return loc.Has()
 }
 
 func (fr *Frame) GetVar(name string) T {
-	sc := fr.GetVarScope(name)
-	var loc Loc
-	var ok bool
-	loc, ok = sc[name]
-	if !ok {
-		panic(Sprintf("Variable %q does not exist; scope contains %v", name, sc))
+	if arr, key, ok := SplitArrayName(name); ok {
+		h := fr.arrayFor(arr, name, "read")
+		if h == nil {
+			panic(Sprintf("can't read %q: no such variable", name))
+		}
+		z := h.get(key)
+		if z == nil {
+			panic(Sprintf("can't read %q: no such element in array", name))
+		}
+		return z
+	}
+	loc := fr.findLoc(name)
+	if loc == nil {
+		panic(Sprintf("Variable %q does not exist; scope contains %v", name, SortedKeysOfScope(fr.LocalLocs())))
 	}
 	return loc.Get()
 }
 
+func SortedKeysOfScope(sc Scope) []string {
+	keys := make([]string, 0, len(sc))
+	for k := range sc {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (fr *Frame) SetVar(name string, x T) {
+	if arr, key, ok := SplitArrayName(name); ok {
+		h := fr.arrayFor(arr, name, "set")
+		if h == nil {
+			h = MkHash(nil)
+			fr.SetVar(arr, h)
+		}
+		h.put(fr, key, x)
+		return
+	}
 	if strings.Contains(name, ",") {
 		// Support destructuring list assignment syntax.
 		xs := x.List()
@@ -246,13 +440,75 @@ func (fr *Frame) SetVar(name string, x T) {
 		}
 		return
 	}
-	sc := fr.GetVarScope(name)
-	ptr := sc[name]
-	if ptr == nil {
-		ptr = new(Slot)
-		sc[name] = ptr
+	loc := fr.findLoc(name)
+	if loc == nil {
+		loc = new(Slot)
+		fr.varsFor(name)[name] = loc
 	}
-	ptr.Set(x)
+	fr.dropMirror(loc, name)
+	loc.Set(x)
+}
+
+// UnsetVar removes the variable (or array element), returning false if it did not exist.
+// Unsetting a variable linked by upvar or global unsets the variable it links to.
+func (fr *Frame) UnsetVar(name string) bool {
+	if arr, key, ok := SplitArrayName(name); ok {
+		h := fr.arrayFor(arr, name, "unset")
+		if h == nil || h.get(key) == nil {
+			return false
+		}
+		h.del(fr, key)
+		return true
+	}
+	local := fr
+	if IsGlobal(name) {
+		local = &fr.G.Fr
+	}
+	if local.Layout != nil {
+		if i, ok := local.Layout.Index[name]; ok {
+			fr.dropMirror(&local.Slots[i], name)
+			return unsetLoc(&local.Slots[i])
+		}
+	}
+	loc, ok := local.Vars[name]
+	if !ok {
+		return false
+	}
+	fr.dropMirror(loc, name)
+	if p, isSlot := loc.(*Slot); isSlot && p.Up == nil {
+		delete(local.Vars, name)
+		return p.Elem != nil
+	}
+	return unsetLoc(loc)
+}
+
+func unsetLoc(loc Loc) bool {
+	switch p := loc.(type) {
+	case *Slot:
+		if p.Up != nil {
+			return unsetLoc(p.Up) // Keep the link, like Tcl.
+		}
+		existed := p.Elem != nil
+		p.Elem = nil
+		return existed
+	case *UpSlot:
+		return p.Fr.UnsetVar(p.RemoteName)
+	}
+	existed := loc.Has()
+	loc.Set(nil)
+	return existed
+}
+
+// varsFor returns the Vars map that holds the name if it is not in Slots,
+// making the map if needed.
+func (fr *Frame) varsFor(name string) Scope {
+	if IsGlobal(name) {
+		fr = &fr.G.Fr
+	}
+	if fr.Vars == nil {
+		fr.Vars = make(Scope)
+	}
+	return fr.Vars
 }
 
 func (p *UpSlot) Has() bool { return p.Fr.HasVar(p.RemoteName) }
@@ -260,8 +516,18 @@ func (p *UpSlot) Get() T    { return p.Fr.GetVar(p.RemoteName) }
 func (p *UpSlot) Set(t T)   { p.Fr.SetVar(p.RemoteName, t) }
 
 func (fr *Frame) DefineUpVar(name string, remFr *Frame, remName string) {
-	sc := fr.GetVarScope(name)
-	sc[name] = &UpSlot{Fr: remFr, RemoteName: remName}
+	up := &UpSlot{Fr: remFr, RemoteName: remName}
+	local := fr
+	if IsGlobal(name) {
+		local = &fr.G.Fr
+	}
+	if local.Layout != nil {
+		if i, ok := local.Layout.Index[name]; ok {
+			local.Slots[i] = Slot{Up: up}
+			return
+		}
+	}
+	fr.varsFor(name)[name] = up
 }
 
 func (fr *Frame) FindCommand(name T, callSuper bool) Command {
@@ -321,6 +587,7 @@ func (fr *Frame) Apply(argv []T) T {
 	fn := fr.FindCommand(head, false) // false: Don't call super.
 	if fn != nil {
 		// Found it; use it.
+		fr.CheckCred(argv)
 		z := fn(fr, argv)
 		if Debug['a'] {
 			Sayf("Apply...returns <%q>", z.String())
@@ -328,6 +595,11 @@ func (fr *Frame) Apply(argv []T) T {
 		return z
 	}
 
+	// Next let the unknown handlers try.
+	if z, ok := fr.applyUnknown(argv); ok {
+		return z
+	}
+
 	// purify // Next try to find a root.
 	// purify rootName := head.String()
 	// purify if len(rootName) > 0 && rootName[0] == '/' {
@@ -337,6 +609,37 @@ func (fr *Frame) Apply(argv []T) T {
 	panic(Sprintf("No such command: %q", head.String()))
 }
 
+// applyUnknown handles a missing command like Tcl, by calling the Go
+// callback G.Unknown, or else the "unknown" command with the full argv,
+// or else by trying auto_load.  It returns false if none of them apply.
+func (fr *Frame) applyUnknown(argv []T) (T, bool) {
+	g := fr.G
+	name := argv[0].String()
+	if g.resolving[name] {
+		return nil, false // Don't recurse on the same name.
+	}
+	if g.resolving == nil {
+		g.resolving = make(map[string]bool)
+	}
+	g.resolving[name] = true
+	defer delete(g.resolving, name)
+
+	if g.Unknown != nil {
+		if z, ok := g.Unknown(fr, argv); ok {
+			return z, true
+		}
+	}
+	if name != "unknown" && g.Cmds["unknown"] != nil {
+		return fr.WithCred(g.UnknownCred, func() T {
+			return fr.Apply(append([]T{MkString("unknown")}, argv...))
+		}), true
+	}
+	if fr.AutoLoad(name) {
+		return fr.Apply(argv), true
+	}
+	return nil, false
+}
+
 func Repr(a interface{}) string { return Sprintf("REPR<<%#v>>", a) }
 
 // Must takes 2 T values, and compares their Show()s.
@@ -420,22 +723,25 @@ func Logf(fmt string, args ...interface{}) {
 	log.Println(Sprintf(fmt, args...))
 }
 
-var SayPrefix = "Say" // TODO: Global Prefix WILL BREAK WITH goROUTINES.
+// SayPrefix begins the lines logged by Say.  Say never changes it,
+// so Say is safe to use from goroutines.
+var SayPrefix = "Say"
 
 // Quick internal logging function that needs no Frame.
 func Say(args ...interface{}) {
-	savedPrefix := SayPrefix
-	defer func() { SayPrefix = savedPrefix }()
+	sayWithPrefix(SayPrefix, args...)
+}
 
-	if len(SayPrefix) < 4 {
-		log.Println(Sprintf("%s --->%s --->", SayPrefix, Where()))
+func sayWithPrefix(sayPrefix string, args ...interface{}) {
+	if len(sayPrefix) < 4 {
+		log.Println(Sprintf("%s --->%s --->", sayPrefix, Where()))
 	}
 
 	prefix := " :::"
 	for _, a := range args {
 		switch t := a.(type) {
 		case Shower:
-			log.Println(Sprintf("%s %s", SayPrefix, t.Show()))
+			log.Println(Sprintf("%s %s", sayPrefix, t.Show()))
 			/* purify
 			case terpValue:
 				view := Sprintf("%v", t.v.Interface())
@@ -455,7 +761,7 @@ func Say(args ...interface{}) {
 				if t.v.CanAddr() {
 					address = Sprintf("@%x", t.v.Addr())
 				}
-				log.Println(Sprintf("%s terpVALUE{{{CanSet=%v %s %s %s :::%s:::%s::: %s:::%#v}}}", SayPrefix, t.v.CanSet(), targetCanSet, address, targAddress, t.v.Kind(), t.v.Type(), view, t.v))
+				log.Println(Sprintf("%s terpVALUE{{{CanSet=%v %s %s %s :::%s:::%s::: %s:::%#v}}}", sayPrefix, t.v.CanSet(), targetCanSet, address, targAddress, t.v.Kind(), t.v.Type(), view, t.v))
 			*/
 		case R.Value:
 			log.Panicf("Say: case R.Value for a= ((%T)) %#v", a, a)
@@ -477,17 +783,16 @@ func Say(args ...interface{}) {
 				if t.CanAddr() {
 					address = Sprintf("@%x", t.Addr())
 				}
-				log.Println(Sprintf("%s VALUE{{{CanSet=%v %s %s %s :::%s:::%s::: %s:::%#v}}}", SayPrefix, t.CanSet(), targetCanSet, address, targAddress, t.Kind(), t.Type(), view, t))
+				log.Println(Sprintf("%s VALUE{{{CanSet=%v %s %s %s :::%s:::%s::: %s:::%#v}}}", sayPrefix, t.CanSet(), targetCanSet, address, targAddress, t.Kind(), t.Type(), view, t))
 			*/
 		default:
 			rv := R.ValueOf(a)
 			rvt := rv.Type()
 			if rvt.Kind() == R.Slice {
-				log.Println(Sprintf("%s SLICE [%d] %s ........", SayPrefix, rv.Len(), rvt))
+				log.Println(Sprintf("%s SLICE [%d] %s ........", sayPrefix, rv.Len(), rvt))
 				for i := 0; i < rv.Len(); i++ {
-					SayPrefix = savedPrefix + Sprintf("SLICE [%d]: ", i)
 					elem := rv.Index(i)
-					Say(elem.Interface())
+					sayWithPrefix(sayPrefix+Sprintf("SLICE [%d]: ", i), elem.Interface())
 				}
 			} else {
 				log.Println(Sprintf("%s %#v", prefix, a))
@@ -508,11 +813,11 @@ type Counter struct {
 var Counters *Counter
 
 func (p *Counter) Incr() {
-	p.count++
+	atomic.AddInt64(&p.count, 1)
 }
 
 func (p *Counter) Show() string {
-	return Sprintf("%d %s", p.count, p.name)
+	return Sprintf("%d %s", atomic.LoadInt64(&p.count), p.name)
 }
 
 func (p *Counter) Register(name string) {
@@ -529,7 +834,7 @@ func LogAllCounters() {
 
 func ClearAllCounters() {
 	for p := Counters; p != nil; p = p.next {
-		p.count = 0
+		atomic.StoreInt64(&p.count, 0)
 	}
 }
 
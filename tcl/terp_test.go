@@ -0,0 +1,84 @@
+package tcl
+
+import (
+	"testing"
+)
+
+var slotTests = `
+  proc locals3 {a b} {
+    set c [expr {$a + $b}]
+    set d$a 7
+    info locals
+  }
+  must {a b c d1} [locals3 1 2]
+
+  proc dynamic {name} {
+    set $name 42
+    set x [set $name]
+    list $x [set $name]
+  }
+  must {42 42} [dynamic x]
+  must {42 42} [dynamic y]
+
+  proc upper {} {
+    set v 1
+    lower v
+    set v
+  }
+  proc lower {name} {
+    upvar 1 $name w
+    set w [expr {$w + 10}]
+    incr w
+  }
+  must 12 [upper]
+
+  set Gv 5
+  set gl 6
+  proc useGlobals {} {
+    global gl
+    set gl [expr {$gl + $Gv}]
+  }
+  must 11 [useGlobals]
+  must 11 $gl
+
+  proc loop {n} {
+    set sum 0
+    foreach i [list 1 2 3 $n] {
+      set sum [expr {$sum + $i}]
+    }
+    set sum
+  }
+  must 16 [loop 10]
+
+  proc withdefault {a {b 9}} {
+    list $a $b
+  }
+  must {1 9} [withdefault 1]
+  must {1 2} [withdefault 1 2]
+
+  proc withargs {a {b 9} args} {
+    list $a $b $args
+  }
+  must {1 9 {}} [withargs 1]
+  must {1 2 {3 4}} [withargs 1 2 3 4]
+
+  proc nested {x} {
+    if {$x > 0} {
+      uplevel 1 {set fromNested 1}
+      return [nested [expr {$x - 1}]]
+    }
+    return done
+  }
+  must done [nested 3]
+  must 1 $fromNested
+
+  proc destructure {a,b} {return "$a/$b"}
+  must 1/2 [destructure {1 2}]
+  proc destructureAfter {x a,b args} {return "$x:$a/$b:$args"}
+  must 0:1/2:3 [destructureAfter 0 {1 2} 3]
+`
+
+func TestSlots(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(slotTests))
+}
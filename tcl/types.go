@@ -87,7 +87,9 @@ func ShowExprUnlessNull(expr *PExpr) string {
 
 // *terpHash holds a Hash.
 type terpHash struct { // Implements T.
-	h Hash
+	h       Hash
+	m       HashMirror       // If set, h is only a copy of what m holds.
+	watched map[string]*bool // Elements vwait is waiting for, set true when written.
 }
 
 func MkHash(h Hash) *terpHash {
@@ -223,7 +225,7 @@ func (t *terpHash) Bool() bool {
 	panic("terpHash cannot be used as Bool")
 }
 func (t *terpHash) IsEmpty() bool {
-	z := (len(t.h) == 0)
+	z := (len(t.Hash()) == 0)
 	return z
 }
 
@@ -255,7 +257,7 @@ func (t *terpHash) IsPreservedByList() bool { return true }
 func (t *terpHash) IsQuickInt() bool        { return false }
 func (t *terpHash) IsQuickNumber() bool     { return false }
 func (t *terpHash) List() []T {
-	keys := SortedKeysOfHash(t.h)
+	keys := SortedKeysOfHash(t.Hash())
 	z := make([]T, 0, 2*len(keys))
 
 	for _, k := range keys {
@@ -268,20 +270,47 @@ func (t *terpHash) HeadTail() (hd, tl T) {
 	return MkList(t.List()).HeadTail()
 }
 func (t *terpHash) Hash() Hash {
+	if t.m != nil {
+		t.h = t.m.Load()
+	}
 	return t.h
 }
 func (t *terpHash) GetAt(key T) T {
-	k := key.String()
-
-	z := t.h[k]
-
-	return z
+	return t.get(key.String())
 }
 func (t *terpHash) PutAt(value T, key T) {
-	k := key.String()
+	t.put(nil, key.String(), value)
+}
+
+// get returns the element, or nil.
+func (t *terpHash) get(k string) T {
+	if t.m != nil {
+		return t.m.Lookup(k)
+	}
+	return t.h[k]
+}
 
+// put sets the element, for the frame (or nil, for Go code).
+func (t *terpHash) put(fr *Frame, k string, value T) {
+	if t.m != nil {
+		t.m.Store(fr, k, value)
+	}
+	if w, ok := t.watched[k]; ok {
+		*w = true
+	}
 	t.h[k] = value
 }
+
+// del unsets the element, for the frame (or nil, for Go code).
+func (t *terpHash) del(fr *Frame, k string) {
+	if t.m != nil {
+		t.m.Delete(fr, k)
+	}
+	if w, ok := t.watched[k]; ok {
+		*w = true
+	}
+	delete(t.h, k)
+}
 func (t *terpHash) EvalSeq(fr *Frame) T         { return Parse2EvalSeqStr(fr, t.String()) }
 func (t *terpHash) EvalExpr(fr *Frame) T        { return Parse2EvalExprStr(fr, t.String()) }
 func (t *terpHash) Apply(fr *Frame, args []T) T { panic("Cannot apply terpHash as command") }
@@ -726,6 +755,7 @@ func (t terpMulti) Apply(fr *Frame, args []T) T {
 			}
 		}()
 
+		fr.CheckCred(args)
 		return t.command(fr, args)
 	}
 	return fr.Apply(args)
@@ -811,6 +841,9 @@ func MkEnsemble(items []EnsembleItem) Command {
 		// Try for exact match.
 		for _, e := range items {
 			if e.Name == subName {
+				if fr.Cred != nil {
+					fr.CheckCaps(argv[0].String() + " " + e.Name)
+				}
 				return e.Cmd(fr, argv[1:])
 			}
 		}
@@ -827,6 +860,9 @@ func MkEnsemble(items []EnsembleItem) Command {
 			}
 		}
 		if found >= 0 {
+			if fr.Cred != nil {
+				fr.CheckCaps(argv[0].String() + " " + items[found].Name)
+			}
 			return items[found].Cmd(fr, argv[1:])
 		}
 		panic(Sprintf("Ensemble subcommand not found: %#v Options: %s",
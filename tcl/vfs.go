@@ -0,0 +1,554 @@
+package tcl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// FileSystem is what commands that touch files (open, file, glob, source,
+// binary readfile and writefile) go through.  Each interpreter has one in
+// Global.FS; if nil, an unsafe interpreter uses the real filesystem
+// and a safe interpreter has none.  Names use "/" as the separator.
+type FileSystem interface {
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	Stat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Mkdir(name string, perm fs.FileMode) error
+	Remove(name string) error
+	Rename(oldName, newName string) error
+}
+
+// LinkFS is a FileSystem with links.  The file command uses it if the
+// interpreter's FileSystem implements it.
+type LinkFS interface {
+	Lstat(name string) (fs.FileInfo, error)
+	Readlink(name string) (string, error)
+	Symlink(target, name string) error
+	Link(target, name string) error
+}
+
+// AttrFS is a FileSystem that can check access permissions (mode is
+// 4 for read, 2 for write, 1 for execute, as for access(2)) and set
+// file times.  The file command uses it if the FileSystem implements it.
+type AttrFS interface {
+	Access(name string, mode uint32) error
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// File is an open file in a FileSystem.  *os.File implements it.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	io.Closer
+	Stat() (fs.FileInfo, error)
+}
+
+// FileSystem returns the interpreter's FileSystem, or panics if it has none.
+func (fr *Frame) FileSystem() FileSystem {
+	g := fr.G
+	switch {
+	case g.FS != nil:
+		return g.FS
+	case g.IsSafe:
+		panic("no filesystem in safe interpreter")
+	}
+	return OSFS{}
+}
+
+// ReadFile returns the contents of the named file.
+func ReadFile(fsys FileSystem, name string) ([]byte, error) {
+	f, err := fsys.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// WriteFile replaces the contents of the named file.
+func WriteFile(fsys FileSystem, name string, data []byte, perm fs.FileMode) error {
+	f, err := fsys.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Glob returns the names matching the pattern, as for path.Match
+// applied to each element of the path.  As in Tcl, names beginning
+// with "." match only patterns beginning with ".".
+func Glob(fsys FileSystem, pattern string) ([]string, error) {
+	if !hasGlobMeta(pattern) {
+		if _, err := fsys.Stat(pattern); err != nil {
+			return nil, nil
+		}
+		return []string{pattern}, nil
+	}
+	dir, file := path.Split(pattern)
+	dir = strings.TrimSuffix(dir, "/")
+	if dir == "" && strings.HasPrefix(pattern, "/") {
+		dir = "/"
+	}
+
+	var dirs []string
+	switch {
+	case dir == "":
+		dirs = []string{""}
+	case hasGlobMeta(dir):
+		var err error
+		if dirs, err = Glob(fsys, dir); err != nil {
+			return nil, err
+		}
+	default:
+		dirs = []string{dir}
+	}
+
+	var z []string
+	for _, d := range dirs {
+		list := d
+		if list == "" {
+			list = "."
+		}
+		entries, err := fsys.ReadDir(list)
+		if err != nil {
+			continue // Like filepath.Glob, ignore unreadable directories.
+		}
+		for _, e := range entries {
+			if strings.HasPrefix(e.Name(), ".") && !strings.HasPrefix(file, ".") {
+				continue
+			}
+			ok, err := path.Match(file, e.Name())
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				z = append(z, path.Join(d, e.Name()))
+			}
+		}
+	}
+	sort.Strings(z)
+	return z, nil
+}
+
+func hasGlobMeta(s string) bool { return strings.ContainsAny(s, `*?[\`) }
+
+// OSFS is the real filesystem.  If Root is set, names are relative to it,
+// and neither ".." nor symbolic links can climb above it: links are
+// resolved within Root, as if it were "/", and Symlink refuses targets
+// that are absolute or climb above it.
+type OSFS struct {
+	Root string
+}
+
+// maxLinks limits the symbolic links followed in resolving one name.
+const maxLinks = 255
+
+func (o OSFS) real(name string) string {
+	return o.resolve(name, true)
+}
+
+// lreal is like real, but does not follow a link at the end of the name.
+func (o OSFS) lreal(name string) string {
+	return o.resolve(name, false)
+}
+
+// resolve returns the real path of the name.  Under a Root, it follows
+// links itself, so the system follows none out of the Root.  Following
+// too many gives a path under the Root that cannot exist, so using it
+// fails.
+func (o OSFS) resolve(name string, follow bool) string {
+	if o.Root == "" {
+		return filepath.FromSlash(name)
+	}
+	var done []string // Resolved elements, under the Root.
+	rest := strings.Split(name, "/")
+	for links := 0; len(rest) > 0; {
+		e := rest[0]
+		rest = rest[1:]
+		switch e {
+		case "", ".":
+			continue
+		case "..":
+			if len(done) > 0 {
+				done = done[:len(done)-1]
+			}
+			continue
+		}
+		done = append(done, e)
+		if len(rest) == 0 && !follow {
+			break
+		}
+		p := filepath.Join(o.Root, filepath.FromSlash(path.Join(done...)))
+		target, err := os.Readlink(p)
+		if err != nil {
+			continue // Not a link.
+		}
+		if links++; links > maxLinks {
+			return filepath.Join(p, "\x00")
+		}
+		done = done[:len(done)-1]
+		if strings.HasPrefix(target, "/") {
+			done = nil
+		}
+		rest = append(strings.Split(filepath.ToSlash(target), "/"), rest...)
+	}
+	return filepath.Join(o.Root, filepath.FromSlash(path.Join(done...)))
+}
+
+func (o OSFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := os.OpenFile(o.real(name), flag, perm)
+	if err != nil {
+		return nil, err // Not a typed nil.
+	}
+	return f, nil
+}
+func (o OSFS) Stat(name string) (fs.FileInfo, error)      { return os.Stat(o.real(name)) }
+func (o OSFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(o.real(name)) }
+func (o OSFS) Mkdir(name string, perm fs.FileMode) error  { return os.Mkdir(o.lreal(name), perm) }
+func (o OSFS) Remove(name string) error                   { return os.Remove(o.lreal(name)) }
+func (o OSFS) Rename(oldName, newName string) error {
+	return os.Rename(o.lreal(oldName), o.lreal(newName))
+}
+func (o OSFS) Lstat(name string) (fs.FileInfo, error) { return os.Lstat(o.lreal(name)) }
+func (o OSFS) Readlink(name string) (string, error)   { return os.Readlink(o.lreal(name)) }
+func (o OSFS) Symlink(target, name string) error {
+	if o.Root != "" {
+		t := filepath.ToSlash(target)
+		within := path.Join(path.Dir(strings.TrimPrefix(path.Clean("/"+name), "/")), t)
+		if strings.HasPrefix(t, "/") || within == ".." || strings.HasPrefix(within, "../") {
+			return &fs.PathError{Op: "symlink", Path: name, Err: fs.ErrPermission}
+		}
+	}
+	return os.Symlink(filepath.FromSlash(target), o.lreal(name))
+}
+func (o OSFS) Link(target, name string) error { return os.Link(o.lreal(target), o.lreal(name)) }
+func (o OSFS) Access(name string, mode uint32) error {
+	return syscall.Access(o.real(name), mode)
+}
+func (o OSFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(o.real(name), atime, mtime)
+}
+
+// ReadOnlyFS is a layer over another FileSystem that refuses all changes.
+type ReadOnlyFS struct {
+	FS FileSystem
+}
+
+func (r ReadOnlyFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return r.FS.OpenFile(name, flag, perm)
+}
+func (r ReadOnlyFS) Stat(name string) (fs.FileInfo, error)      { return r.FS.Stat(name) }
+func (r ReadOnlyFS) ReadDir(name string) ([]fs.DirEntry, error) { return r.FS.ReadDir(name) }
+func (r ReadOnlyFS) Mkdir(name string, perm fs.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrPermission}
+}
+func (r ReadOnlyFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+}
+func (r ReadOnlyFS) Rename(oldName, newName string) error {
+	return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrPermission}
+}
+func (r ReadOnlyFS) Access(name string, mode uint32) error {
+	if mode&2 != 0 {
+		return &fs.PathError{Op: "access", Path: name, Err: fs.ErrPermission}
+	}
+	if a, ok := r.FS.(AttrFS); ok {
+		return a.Access(name, mode)
+	}
+	_, err := r.FS.Stat(name)
+	return err
+}
+func (r ReadOnlyFS) Chtimes(name string, atime, mtime time.Time) error {
+	return &fs.PathError{Op: "chtimes", Path: name, Err: fs.ErrPermission}
+}
+
+// MemFS is a FileSystem in memory, for tests and sandboxes.
+// Relative names are relative to "/".  It is safe for concurrent use.
+type MemFS struct {
+	mu    sync.Mutex
+	nodes map[string]*memNode // by cleaned absolute name
+}
+
+type memNode struct {
+	name    string
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// NewMemFS makes an empty MemFS, with files given by name and contents.
+func NewMemFS(files map[string]string) *MemFS {
+	m := &MemFS{nodes: map[string]*memNode{
+		"/": {name: "/", mode: fs.ModeDir | 0777, modTime: time.Now()},
+	}}
+	for name, contents := range files {
+		m.mkdirAll(path.Dir(memClean(name)))
+		if err := WriteFile(m, name, []byte(contents), 0666); err != nil {
+			panic(err)
+		}
+	}
+	return m
+}
+
+func memClean(name string) string { return path.Clean("/" + name) }
+
+func (m *MemFS) mkdirAll(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for d := name; m.nodes[d] == nil; d = path.Dir(d) {
+		m.nodes[d] = &memNode{name: d, mode: fs.ModeDir | 0777, modTime: time.Now()}
+	}
+}
+
+// parentDir returns an error unless the parent directory of the cleaned name exists.
+func (m *MemFS) parentDir(op, name string) error {
+	if p := m.nodes[path.Dir(name)]; p == nil || !p.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clean := memClean(name)
+	n := m.nodes[clean]
+	switch {
+	case n == nil && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case n == nil:
+		if err := m.parentDir("open", clean); err != nil {
+			return nil, err
+		}
+		n = &memNode{name: clean, mode: perm &^ fs.ModeType, modTime: time.Now()}
+		m.nodes[clean] = n
+	case flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case n.mode.IsDir() && flag&(os.O_WRONLY|os.O_RDWR) != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	}
+	if flag&os.O_TRUNC != 0 {
+		n.data = nil
+		n.modTime = time.Now()
+	}
+	f := &memFile{fs: m, node: n, flag: flag}
+	return f, nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := m.nodes[memClean(name)]
+	if n == nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return n.info(), nil
+}
+
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clean := memClean(name)
+	n := m.nodes[clean]
+	if n == nil || !n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	var z []fs.DirEntry
+	for k, e := range m.nodes {
+		if k != clean && path.Dir(k) == clean {
+			z = append(z, fs.FileInfoToDirEntry(e.info()))
+		}
+	}
+	sort.Slice(z, func(i, j int) bool { return z[i].Name() < z[j].Name() })
+	return z, nil
+}
+
+func (m *MemFS) Mkdir(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clean := memClean(name)
+	if m.nodes[clean] != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if err := m.parentDir("mkdir", clean); err != nil {
+		return err
+	}
+	m.nodes[clean] = &memNode{name: clean, mode: fs.ModeDir | perm, modTime: time.Now()}
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clean := memClean(name)
+	if m.nodes[clean] == nil || clean == "/" {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	for k := range m.nodes {
+		if path.Dir(k) == clean && k != clean {
+			return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+		}
+	}
+	delete(m.nodes, clean)
+	return nil
+}
+
+func (m *MemFS) Rename(oldName, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	from, to := memClean(oldName), memClean(newName)
+	n := m.nodes[from]
+	if n == nil {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrNotExist}
+	}
+	if from == to {
+		return nil
+	}
+	if strings.HasPrefix(to, from+"/") {
+		return &fs.PathError{Op: "rename", Path: newName, Err: fs.ErrInvalid}
+	}
+	if err := m.parentDir("rename", to); err != nil {
+		return err
+	}
+	// Move the node and, for a directory, everything under it.
+	// Find them all before changing the map.
+	var moving []string
+	for k := range m.nodes {
+		if k == from || strings.HasPrefix(k, from+"/") {
+			moving = append(moving, k)
+		}
+	}
+	for _, k := range moving {
+		e := m.nodes[k]
+		delete(m.nodes, k)
+		e.name = to + k[len(from):]
+		m.nodes[e.name] = e
+	}
+	return nil
+}
+
+func (n *memNode) info() fs.FileInfo {
+	return memInfo{name: path.Base(n.name), size: int64(len(n.data)), mode: n.mode, modTime: n.modTime}
+}
+
+type memInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() fs.FileMode  { return i.mode }
+func (i memInfo) ModTime() time.Time { return i.modTime }
+func (i memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memInfo) Sys() interface{}   { return nil }
+
+// memFile is an open MemFS file, with its own offset.
+type memFile struct {
+	fs     *MemFS
+	node   *memNode
+	flag   int
+	offset int64
+	closed bool
+}
+
+func (f *memFile) check(write bool) error {
+	switch {
+	case f.closed:
+		return fs.ErrClosed
+	case write && f.flag&(os.O_WRONLY|os.O_RDWR) == 0:
+		return &fs.PathError{Op: "write", Path: f.node.name, Err: fs.ErrPermission}
+	case !write && f.flag&os.O_WRONLY != 0:
+		return &fs.PathError{Op: "read", Path: f.node.name, Err: fs.ErrPermission}
+	}
+	return nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check(false); err != nil {
+		return 0, err
+	}
+	if f.offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check(true); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.node.data))
+	}
+	if gap := f.offset - int64(len(f.node.data)); gap > 0 {
+		f.node.data = append(f.node.data, bytes.Repeat([]byte{0}, int(gap))...)
+	}
+	end := f.offset + int64(len(p))
+	if end > int64(len(f.node.data)) {
+		f.node.data = append(f.node.data[:f.offset], p...)
+	} else {
+		copy(f.node.data[f.offset:], p)
+	}
+	f.offset = end
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	}
+	if offset < 0 {
+		return 0, errors.New("seek: negative position")
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return f.node.info(), nil
+}
@@ -0,0 +1,129 @@
+package tcl
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMemFS(a *testing.T) {
+	m := NewMemFS(map[string]string{
+		"a/one.txt": "one",
+		"a/two.txt": "two",
+		"/b/c.tcl":  "c",
+	})
+	if bb, err := ReadFile(m, "/a/one.txt"); err != nil || string(bb) != "one" {
+		a.Errorf("ReadFile: %q %v", bb, err)
+	}
+	if err := WriteFile(m, "a/three.txt", []byte("3"), 0666); err != nil {
+		a.Fatal(err)
+	}
+	if err := WriteFile(m, "nodir/x", []byte("x"), 0666); err == nil {
+		a.Errorf("WriteFile without parent dir should fail")
+	}
+
+	f, _ := m.OpenFile("a/one.txt", os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte("+1"))
+	f.Close()
+	if bb, _ := ReadFile(m, "a/one.txt"); string(bb) != "one+1" {
+		a.Errorf("append: %q", bb)
+	}
+	if _, err := m.OpenFile("a/one.txt", os.O_CREATE|os.O_EXCL, 0666); err == nil {
+		a.Errorf("O_EXCL should fail on existing file")
+	}
+
+	got, _ := Glob(m, "/a/*.txt")
+	if want := []string{"/a/one.txt", "/a/three.txt", "/a/two.txt"}; !reflect.DeepEqual(got, want) {
+		a.Errorf("Glob: %v", got)
+	}
+	got, _ = Glob(m, "*/c.*")
+	if want := []string{"b/c.tcl"}; !reflect.DeepEqual(got, want) {
+		a.Errorf("Glob relative: %v", got)
+	}
+
+	if err := m.Rename("a", "d"); err != nil {
+		a.Fatal(err)
+	}
+	if info, err := m.Stat("d/two.txt"); err != nil || info.Size() != 3 {
+		a.Errorf("after Rename: %v %v", info, err)
+	}
+	if err := m.Rename("d", "d/sub"); err == nil {
+		a.Errorf("Rename into its own subtree should fail")
+	}
+	if err := m.Mkdir("d/deep", 0777); err != nil {
+		a.Fatal(err)
+	}
+	WriteFile(m, "d/deep/x", []byte("x"), 0666)
+	if err := m.Rename("d", "dd"); err != nil {
+		a.Fatal(err)
+	}
+	if err := m.Rename("dd", "d"); err != nil {
+		a.Fatal(err)
+	}
+	got, _ = Glob(m, "d/*/*")
+	if want := []string{"d/deep/x"}; !reflect.DeepEqual(got, want) {
+		a.Errorf("after moving back: %v", got)
+	}
+	m.Remove("d/deep/x")
+	m.Remove("d/deep")
+	if err := m.Remove("d"); err == nil {
+		a.Errorf("Remove of non-empty directory should fail")
+	}
+	if err := m.Mkdir("e", 0777); err != nil {
+		a.Fatal(err)
+	}
+	entries, _ := m.ReadDir("/")
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := []string{"b", "d", "e"}; !reflect.DeepEqual(names, want) {
+		a.Errorf("ReadDir: %v", names)
+	}
+
+	ro := ReadOnlyFS{m}
+	if _, err := ro.OpenFile("b/c.tcl", os.O_RDONLY, 0); err != nil {
+		a.Errorf("ReadOnlyFS read: %v", err)
+	}
+	if err := WriteFile(ro, "b/c.tcl", nil, 0666); !os.IsPermission(err) {
+		a.Errorf("ReadOnlyFS write: %v", err)
+	}
+	if err := ro.Remove("b/c.tcl"); !os.IsPermission(err) {
+		a.Errorf("ReadOnlyFS remove: %v", err)
+	}
+}
+
+func TestOSFS(a *testing.T) {
+	root := a.TempDir()
+	os.WriteFile(filepath.Join(root, "inside"), []byte("in"), 0666)
+	o := OSFS{Root: root}
+	if bb, err := ReadFile(o, "/inside"); err != nil || string(bb) != "in" {
+		a.Errorf("ReadFile: %q %v", bb, err)
+	}
+	// ".." does not climb above the root.
+	if bb, err := ReadFile(o, "../../inside"); err != nil || string(bb) != "in" {
+		a.Errorf("ReadFile with ..: %q %v", bb, err)
+	}
+	if _, err := o.Stat("../" + filepath.Base(root)); !os.IsNotExist(err) {
+		a.Errorf("Stat above root: %v", err)
+	}
+}
+
+func TestSafeFS(a *testing.T) {
+	fr := NewSafeInterpreter()
+	mustPanic(a, func() { fr.FileSystem() })
+	fr.G.FS = NewMemFS(map[string]string{"/data/in.txt": "hello\n"})
+	if _, err := fr.FileSystem().Stat("/data/in.txt"); err != nil {
+		a.Error(err)
+	}
+}
+
+func mustPanic(a *testing.T, fn func()) {
+	defer func() {
+		if recover() == nil {
+			a.Errorf("expected panic")
+		}
+	}()
+	fn()
+}
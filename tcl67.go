@@ -5,6 +5,7 @@ import (
 	_ "github.com/strickyak/tcl67/extra"
 	_ "github.com/strickyak/tcl67/posix"
 	_ "github.com/strickyak/tcl67/tcl"
+	_ "github.com/strickyak/tcl67/web"
 )
 
 func main() {
@@ -0,0 +1,285 @@
+package web
+
+import (
+	"errors"
+	. "fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/strickyak/tcl67/tcl"
+)
+
+// The http command, a client and server using net/http.
+//
+//	http get url ?-headers dict? ?-timeout ms?
+//	http post url ?-headers dict? ?-body data? ?-timeout ms?
+//
+// return a dict {status code headers dict body data}.  Header names
+// are canonical, like Content-Type, and repeated headers are joined
+// with ", ".  The wait stops at the interpreter's time or context limit.
+//
+//	http serve ?-myaddr addr? port handlerProc
+//
+// listens on the port (0 picks a free one), returning a server name for
+// "http port" and "http close" in the same interpreter.  For each request,
+// the event loop calls the handler, with the Cred of the caller, passing
+// the method, the path (with any query), the headers and the body.  It
+// returns a dict with status (default 200), headers and body.  If it fails,
+// the client gets status 500, and the error goes to bgerror.
+
+var httpEnsemble = []EnsembleItem{
+	EnsembleItem{Name: "get", Cmd: cmdHttpGet, Doc: "url ?-headers dict? ?-timeout ms?"},
+	EnsembleItem{Name: "post", Cmd: cmdHttpPost, Doc: "url ?-headers dict? ?-body data? ?-timeout ms?"},
+	EnsembleItem{Name: "serve", Cmd: cmdHttpServe, Doc: "?-myaddr addr? port handlerProc"},
+	EnsembleItem{Name: "port", Cmd: cmdHttpPort, Doc: "server"},
+	EnsembleItem{Name: "close", Cmd: cmdHttpClose, Doc: "server"},
+}
+
+// dictOf makes a dict of the headers.
+func dictOf(h http.Header) T {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var zz []T
+	for _, k := range keys {
+		zz = append(zz, MkString(k), MkString(strings.Join(h[k], ", ")))
+	}
+	return MkList(zz)
+}
+
+// setHeaders sets the headers from a dict.
+func setHeaders(h http.Header, dict T) {
+	kv := dict.List()
+	if len(kv)%2 != 0 {
+		panic(Sprintf("http: headers must be a dict: %q", dict.String()))
+	}
+	for i := 0; i < len(kv); i += 2 {
+		h.Set(kv[i].String(), kv[i+1].String())
+	}
+}
+
+// request does an http get or post.
+func request(fr *Frame, method string, argv []T) T {
+	url, opts := Arg1v(argv)
+	if len(opts)%2 != 0 {
+		panic(Sprintf("usage: http %s url ?-option value ...?", strings.ToLower(method)))
+	}
+	var headers T
+	var body io.Reader
+	client := &http.Client{}
+	for i := 0; i < len(opts); i += 2 {
+		switch opt, value := opts[i].String(), opts[i+1]; opt {
+		case "-headers":
+			headers = value
+		case "-body":
+			if method != "POST" {
+				panic(`http get: -body is only for "http post"`)
+			}
+			body = strings.NewReader(value.String())
+		case "-timeout":
+			client.Timeout = time.Duration(value.Int()) * time.Millisecond
+		default:
+			panic(Sprintf("bad option %q: should be -body, -headers, or -timeout", opt))
+		}
+	}
+
+	ctx, cancel := fr.G.LimitContext()
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, url.String(), body)
+	if err != nil {
+		panic(Sprintf("http %s: %s", strings.ToLower(method), err.Error()))
+	}
+	if headers != nil {
+		setHeaders(req.Header, headers)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		fr.G.CheckTimeLimits()
+		panic(Sprintf("http %s: %s", strings.ToLower(method), err.Error()))
+	}
+	defer resp.Body.Close()
+	bb, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fr.G.CheckTimeLimits()
+		panic(Sprintf("http %s: reading body: %s", strings.ToLower(method), err.Error()))
+	}
+	return MkList([]T{
+		MkString("status"), MkInt(int64(resp.StatusCode)),
+		MkString("headers"), dictOf(resp.Header),
+		MkString("body"), MkString(string(bb)),
+	})
+}
+
+func cmdHttpGet(fr *Frame, argv []T) T {
+	return request(fr, "GET", argv)
+}
+
+func cmdHttpPost(fr *Frame, argv []T) T {
+	return request(fr, "POST", argv)
+}
+
+// Server is a server made by "http serve".
+type Server struct {
+	Name string
+	g    *Global // Interpreter that made it, the only one that may use it.
+	srv  *http.Server
+	ln   net.Listener
+}
+
+// servers holds the Servers by interpreter and name.
+var servers = struct {
+	sync.Mutex
+	m map[*Global]map[string]*Server
+	n int
+}{m: make(map[*Global]map[string]*Server)}
+
+func lookupServer(fr *Frame, t T) *Server {
+	servers.Lock()
+	defer servers.Unlock()
+	s, ok := servers.m[fr.G][t.String()]
+	if !ok {
+		panic(Sprintf("http: no such server: %q", t.String()))
+	}
+	return s
+}
+
+// reply is what the handler proc returned.
+type reply struct {
+	status  int
+	headers http.Header
+	body    string
+	failed  bool // The handler failed, so there is no reply.
+}
+
+// handle runs the handler proc in the interpreter's goroutine,
+// returning its reply.
+func handle(fr *Frame, handler T, r *http.Request, body string) (z reply) {
+	result := EvalOrApplyLists(fr, []T{handler, MkList([]T{
+		MkString(r.Method), MkString(r.URL.RequestURI()), dictOf(r.Header), MkString(body),
+	})})
+	kv := result.List()
+	if len(kv)%2 != 0 {
+		panic(Sprintf("http handler must return a dict: %q", result.String()))
+	}
+	z.status = http.StatusOK
+	for i := 0; i < len(kv); i += 2 {
+		switch k := kv[i].String(); k {
+		case "status":
+			z.status = int(kv[i+1].Int())
+		case "headers":
+			z.headers = make(http.Header)
+			setHeaders(z.headers, kv[i+1])
+		case "body":
+			z.body = kv[i+1].String()
+		default:
+			panic(Sprintf("http handler returned bad key %q: should be status, headers, or body", k))
+		}
+	}
+	return z
+}
+
+func cmdHttpServe(fr *Frame, argv []T) T {
+	args := argv[1:]
+	myaddr := ""
+	if len(args) == 4 && args[0].String() == "-myaddr" {
+		myaddr = args[1].String()
+		args = args[2:]
+	}
+	if len(args) != 2 {
+		panic("usage: http serve ?-myaddr addr? port handlerProc")
+	}
+	port, handler := args[0], args[1]
+	if _, err := strconv.ParseUint(port.String(), 10, 16); err != nil {
+		panic(Sprintf("expected port number but got %q", port.String()))
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(myaddr, port.String()))
+	if err != nil {
+		panic(Sprintf("http serve: %s", err.Error()))
+	}
+
+	events, cred := fr.G.Events, fr.Cred
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bb, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		replies := make(chan reply, 1)
+		events.PostWithCred(cred, func(fr *Frame) {
+			// If the handler fails, the client gets a plain 500,
+			// and the event loop reports the error.
+			z := reply{failed: true}
+			defer func() { replies <- z }()
+			z = handle(fr, handler, r, string(bb))
+		})
+		var z reply
+		select {
+		case z = <-replies:
+		case <-r.Context().Done():
+			return
+		}
+		if z.failed {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+		for k, vv := range z.headers {
+			w.Header()[k] = vv
+		}
+		w.WriteHeader(z.status)
+		io.WriteString(w, z.body)
+	})
+
+	servers.Lock()
+	servers.n++
+	s := &Server{Name: Sprintf("httpd%d", servers.n), g: fr.G, srv: &http.Server{Handler: h}, ln: ln}
+	if servers.m[s.g] == nil {
+		servers.m[s.g] = make(map[string]*Server)
+	}
+	servers.m[s.g][s.Name] = s
+	servers.Unlock()
+
+	events.Hold()
+	go func() {
+		defer events.Release()
+		s.srv.Serve(ln)
+	}()
+	return MkString(s.Name)
+}
+
+func cmdHttpPort(fr *Frame, argv []T) T {
+	s := lookupServer(fr, Arg1(argv))
+	return MkInt(int64(s.ln.Addr().(*net.TCPAddr).Port))
+}
+
+// cmdHttpClose stops the server, without waiting for requests.
+func cmdHttpClose(fr *Frame, argv []T) T {
+	s := lookupServer(fr, Arg1(argv))
+	servers.Lock()
+	delete(servers.m[s.g], s.Name)
+	if len(servers.m[s.g]) == 0 {
+		delete(servers.m, s.g)
+	}
+	servers.Unlock()
+	if err := s.srv.Close(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		panic(Sprintf("http close: %s", err.Error()))
+	}
+	return Empty
+}
+
+func init() {
+	if Unsafes == nil {
+		Unsafes = make(map[string]Command, 333)
+	}
+
+	Unsafes["http"] = MkEnsemble(httpEnsemble)
+	RequireCaps("http", "net")
+}
@@ -0,0 +1,118 @@
+package web
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	_ "github.com/strickyak/tcl67/posix"
+	. "github.com/strickyak/tcl67/tcl"
+)
+
+var clientTests = `
+  array set r [http get $Url/hello -headers {X-Test yes}]
+  must 200 $r(status)
+  must "GET /hello yes" $r(body)
+  array set h $r(headers)
+  must text/plain $h(Content-Type)
+
+  array set r [http post $Url/things -body "one two" -headers {Content-Type text/plain}]
+  must 201 $r(status)
+  must "POST /things one two" $r(body)
+
+  mustfail {http get $Url/slow -timeout 10}
+  set k [interp create]
+  interp limit $k time -milliseconds [expr {[clock milliseconds] % 1000 + 50}]
+  must 1 [catch {interp eval $k [list http get $Url/slow]} m]
+  must "limit exceeded: time" $m
+  mustfail {http get $Url -bogus 1}
+  mustfail {http get $Url -body x}
+  mustfail {withcred {file} {http get $Url}}
+`
+
+func TestHttpClient(a *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+			return
+		}
+		bb, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, r.Method+" "+r.URL.Path+" "+string(bb))
+			return
+		}
+		io.WriteString(w, r.Method+" "+r.URL.Path+" "+r.Header.Get("X-Test"))
+	}))
+	defer ts.Close()
+
+	fr := NewInterpreter()
+	fr.SetVar("Url", MkString(ts.URL))
+	fr.Eval(MkString(clientTests))
+}
+
+var serverTests = `
+  proc bgerror {msg} {
+    set Bg $msg
+  }
+  proc Handle {method path headers body} {
+    array set h $headers
+    set Seen "$method $path"
+    if {[string match /fail $path]} {
+      error oops
+    }
+    if {[string match /odd $path]} {
+      return {headers {X-Odd}}
+    }
+    if {[string match /exec $path]} {
+      return [list body [catch {exec true} m]]
+    }
+    list status 202 headers {X-Reply yes} body "$h(X-Test) $body"
+  }
+  set srv [http serve -myaddr 127.0.0.1 0 Handle]
+  set url "http://127.0.0.1:[http port $srv]"
+
+  set t [go [list http post $url/p?q=1 -headers {X-Test hi} -body there]]
+  vwait Seen
+  must "POST /p?q=1" $Seen
+  array set r [wait $t]
+  must 202 $r(status)
+  must "hi there" $r(body)
+  array set h $r(headers)
+  must yes $h(X-Reply)
+
+  set t [go [list http get $url/fail]]
+  vwait Seen
+  array set r [wait $t]
+  must 500 $r(status)
+  must "Internal Server Error\n" $r(body)
+  must 1 [string match "oops*" $Bg]
+
+  set t [go [list http get $url/odd]]
+  vwait Seen
+  array set r [wait $t]
+  must 500 $r(status)
+  must "Internal Server Error\n" $r(body)
+  must 1 [string match "http: headers must be a dict*" $Bg]
+
+  set k [interp create]
+  mustfail {interp eval $k [list http port $srv]}
+  mustfail {interp eval $k [list http close $srv]}
+  http close $srv
+  mustfail {http port $srv}
+
+  withcred {net} { set srv [http serve -myaddr 127.0.0.1 0 Handle] }
+  set t [go [list http get http://127.0.0.1:[http port $srv]/exec]]
+  vwait Seen
+  array set r [wait $t]
+  must 1 $r(body)
+  http close $srv
+  mustfail {http get $url/}
+`
+
+func TestHttpServe(a *testing.T) {
+	fr := NewInterpreter()
+	fr.Eval(MkString(serverTests))
+}